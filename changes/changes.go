@@ -0,0 +1,321 @@
+// Package changes implements the comparison of two scans of the same directory
+// for investigating how files have changed, moved, and been renamed between them.
+package changes
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bisgardo/dupe-nukem/scan"
+)
+
+// Kind is the kind of change of a file (or directory) between two scans.
+type Kind string
+
+// Kinds of changes.
+const (
+	// Unchanged is the kind of a file that has the same path and contents in both scans.
+	Unchanged Kind = "unchanged"
+	// Modified is the kind of a file that has the same path but different contents in the two scans.
+	Modified Kind = "modified"
+	// Added is the kind of a file that only exists in the new scan.
+	Added Kind = "added"
+	// Deleted is the kind of a file that only exists in the old scan.
+	Deleted Kind = "deleted"
+	// Moved is the kind of a file that has the same name and contents, but is located in another directory.
+	Moved Kind = "moved"
+	// Renamed is the kind of a file that has the same contents and is located in the same directory, but has another name.
+	Renamed Kind = "renamed"
+	// MovedRenamed is the kind of a file that has the same contents, but another name and is located in another directory.
+	MovedRenamed Kind = "moved_renamed"
+)
+
+// Change is the change of a file (or directory) between two scans.
+type Change struct {
+	Kind Kind `json:"kind"`
+	// Path of the file relative to the root in the new scan (empty if deleted).
+	Path string `json:"path,omitempty"`
+	// Path of the file relative to the root in the old scan if it's different from Path
+	// (i.e. if the file was moved, renamed, or deleted).
+	OldPath string `json:"old_path,omitempty"`
+	// Whether the change is of a whole directory whose files were all moved (or renamed) along with it.
+	Dir bool `json:"dir,omitempty"`
+	// Number of files in the directory (only for changes of directories).
+	Files int `json:"files,omitempty"`
+}
+
+// Result is the result of comparing two scans (see Compare).
+type Result struct {
+	// Root is the name of the (common) root of the scans.
+	Root string `json:"root"`
+	// Counts is the number of files of each kind of change.
+	// The files of a directory that was moved as a whole are counted individually.
+	Counts map[Kind]int `json:"counts"`
+	// Changes lists the changes sorted by path (the old path for deleted files).
+	// Directories that were moved as a whole are represented by a single change (with Dir set)
+	// rather than one for each file in it.
+	Changes []*Change `json:"changes"`
+}
+
+// entry is a file of a scan.
+type entry struct {
+	// Path relative to the root of the scan.
+	path string
+	// The scanned file (nil if it's empty).
+	file *scan.File
+}
+
+// contentKey identifies the contents of a file.
+type contentKey struct {
+	size int64
+	hash uint64
+}
+
+// hashed returns whether the contents of the entry can be identified by its key.
+func (e *entry) hashed() bool {
+	return e.file == nil || !e.file.Unhashed && !e.file.Unstable
+}
+
+func (e *entry) key() contentKey {
+	if e.file == nil {
+		return contentKey{}
+	}
+	return contentKey{size: e.file.Size, hash: e.file.Hash}
+}
+
+// sameContents returns whether the entries are known to have the same contents.
+// If either of them couldn't be hashed, the size and modification time (within the provided tolerance) are compared instead.
+func (e *entry) sameContents(o *entry, modTimeTolerance time.Duration) bool {
+	if e.file == nil || o.file == nil {
+		return e.file == nil && o.file == nil
+	}
+	if !e.hashed() || !o.hashed() {
+		return e.file.Size == o.file.Size && e.file.ModTimeMatches(o.file, modTimeTolerance)
+	}
+	return e.key() == o.key()
+}
+
+// Options holds the parameters of CompareWithOptions besides the scan roots.
+type Options struct {
+	// ModTimeTolerance is the maximum difference between the modification times of the two versions of a file
+	// for them to be considered unchanged if either of them couldn't be hashed.
+	// See scan.ModTimesMatch for details.
+	ModTimeTolerance time.Duration
+}
+
+// Compare compares the provided old and new scan roots with default options (see CompareWithOptions).
+func Compare(oldRoot, newRoot *scan.Dir) (*Result, error) {
+	return CompareWithOptions(oldRoot, newRoot, Options{})
+}
+
+// CompareWithOptions compares the provided old and new scan roots (which must have the same name)
+// and classifies each file according to how it changed between them.
+// Files that don't have the same path in both scans are matched by their size and hash:
+// Each added file is matched with a deleted file of the same contents,
+// preferring one with the same name, then one in the same directory.
+// Empty files are only matched by name as they'd otherwise all match each other.
+func CompareWithOptions(oldRoot, newRoot *scan.Dir, opts Options) (*Result, error) {
+	if oldRoot.Name != newRoot.Name {
+		return nil, fmt.Errorf("scans of different directories %q and %q cannot be compared", oldRoot.Name, newRoot.Name)
+	}
+	oldIdx, newIdx := newIndex(oldRoot), newIndex(newRoot)
+	res := &Result{Root: newRoot.Name, Changes: []*Change{}, Counts: make(map[Kind]int)}
+
+	var added []*entry
+	for _, e := range newIdx.entries {
+		o, ok := oldIdx.byPath[e.path]
+		switch {
+		case !ok:
+			added = append(added, e)
+		case o.sameContents(e, opts.ModTimeTolerance):
+			res.add(&Change{Kind: Unchanged, Path: e.path}, 1)
+		default:
+			res.add(&Change{Kind: Modified, Path: e.path}, 1)
+		}
+	}
+	deleted := make(map[contentKey][]*entry)
+	for _, e := range oldIdx.entries {
+		if _, ok := newIdx.byPath[e.path]; !ok {
+			deleted[e.key()] = append(deleted[e.key()], e)
+		}
+	}
+	var moves []*move
+	for _, e := range added {
+		o := takeMatch(deleted, e)
+		if o == nil {
+			res.add(&Change{Kind: Added, Path: e.path}, 1)
+			continue
+		}
+		moves = append(moves, &move{from: o.path, to: e.path})
+	}
+	for _, es := range deleted {
+		for _, e := range es {
+			res.add(&Change{Kind: Deleted, OldPath: e.path}, 1)
+		}
+	}
+	res.addMoves(moves, oldIdx, newIdx)
+	sort.Slice(res.Changes, func(i, j int) bool {
+		return res.Changes[i].sortKey() < res.Changes[j].sortKey()
+	})
+	return res, nil
+}
+
+func (r *Result) add(c *Change, files int) {
+	r.Changes = append(r.Changes, c)
+	r.Counts[c.Kind] += files
+}
+
+func (c *Change) sortKey() string {
+	if c.Path == "" {
+		return c.OldPath
+	}
+	return c.Path
+}
+
+// takeMatch removes and returns the deleted entry that best matches the provided added one (or nil if none does).
+func takeMatch(deleted map[contentKey][]*entry, e *entry) *entry {
+	if !e.hashed() {
+		return nil
+	}
+	k := e.key()
+	es := deleted[k]
+	name, dir := filepath.Base(e.path), filepath.Dir(e.path)
+	best := -1
+	for i, o := range es {
+		if !o.hashed() {
+			continue
+		}
+		if filepath.Base(o.path) == name {
+			best = i
+			break
+		}
+		if best == -1 && e.file != nil && filepath.Dir(o.path) == dir {
+			best = i
+		}
+	}
+	if best == -1 && e.file != nil {
+		for i, o := range es {
+			if o.hashed() {
+				best = i
+				break
+			}
+		}
+	}
+	if best == -1 {
+		return nil
+	}
+	o := es[best]
+	deleted[k] = append(es[:best:best], es[best+1:]...)
+	return o
+}
+
+// kindOf returns the kind of moving a file (or directory) from the provided old path to the provided new one.
+func kindOf(from, to string) Kind {
+	switch {
+	case filepath.Dir(from) == filepath.Dir(to):
+		return Renamed
+	case filepath.Base(from) == filepath.Base(to):
+		return Moved
+	}
+	return MovedRenamed
+}
+
+// index is the files of a scan indexed by their path.
+type index struct {
+	// Entries sorted by path.
+	entries []*entry
+	byPath  map[string]*entry
+	// Number of files in each directory (including subdirectories) by path.
+	dirFiles map[string]int
+}
+
+func newIndex(root *scan.Dir) *index {
+	idx := &index{byPath: make(map[string]*entry), dirFiles: make(map[string]int)}
+	idx.addDir(root, "")
+	sort.Slice(idx.entries, func(i, j int) bool {
+		return idx.entries[i].path < idx.entries[j].path
+	})
+	return idx
+}
+
+// addDir adds the files of the provided Dir (with the provided relative path) and its subdirectories.
+// Returns the number of added files.
+func (idx *index) addDir(d *scan.Dir, path string) int {
+	n := 0
+	for _, f := range d.Files {
+		idx.addEntry(&entry{path: filepath.Join(path, f.Name), file: f})
+		n++
+	}
+	for _, name := range d.EmptyFiles {
+		idx.addEntry(&entry{path: filepath.Join(path, name)})
+		n++
+	}
+	for _, s := range d.Dirs {
+		n += idx.addDir(s, filepath.Join(path, s.Name))
+	}
+	if path != "" {
+		idx.dirFiles[path] = n
+	}
+	return n
+}
+
+func (idx *index) addEntry(e *entry) {
+	idx.entries = append(idx.entries, e)
+	idx.byPath[e.path] = e
+}
+
+// move is the move of a file (or directory) from one path to another.
+type move struct {
+	from, to string
+}
+
+// addMoves adds the provided moves of files to the result,
+// collapsing the moves of all files in a directory that was moved as a whole into a single change.
+// A directory is considered to be moved as a whole if all of its files were moved into the same relative paths
+// of another directory which contains no other files.
+// The outermost such directory is used.
+func (r *Result) addMoves(moves []*move, oldIdx, newIdx *index) {
+	counts := make(map[move]int)
+	for _, m := range moves {
+		for _, d := range dirMoves(m) {
+			counts[d]++
+		}
+	}
+	dirs := make(map[move]bool)
+	for _, m := range moves {
+		var outermost *move
+		for _, d := range dirMoves(m) {
+			d := d
+			if n := counts[d]; n == oldIdx.dirFiles[d.from] && n == newIdx.dirFiles[d.to] {
+				outermost = &d
+			}
+		}
+		if outermost == nil {
+			r.add(&Change{Kind: kindOf(m.from, m.to), Path: m.to, OldPath: m.from}, 1)
+			continue
+		}
+		if !dirs[*outermost] {
+			dirs[*outermost] = true
+			n := counts[*outermost]
+			r.add(&Change{Kind: kindOf(outermost.from, outermost.to), Path: outermost.to, OldPath: outermost.from, Dir: true, Files: n}, n)
+		}
+	}
+}
+
+// dirMoves returns the moves of the directories that the provided move of a file may be part of,
+// i.e. the pairs of ancestor directories below which the old and new paths are the same (innermost first).
+func dirMoves(m *move) []move {
+	from := strings.Split(m.from, string(filepath.Separator))
+	to := strings.Split(m.to, string(filepath.Separator))
+	var res []move
+	for k := 1; k < len(from) && k < len(to) && from[len(from)-k] == to[len(to)-k]; k++ {
+		res = append(res, move{
+			from: filepath.Join(from[:len(from)-k]...),
+			to:   filepath.Join(to[:len(to)-k]...),
+		})
+	}
+	return res
+}
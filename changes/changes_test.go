@@ -0,0 +1,214 @@
+package changes
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/scan"
+)
+
+func file(name string, size int64, hash uint64) *scan.File {
+	return scan.NewFile(name, size, 0, hash)
+}
+
+func Test__Compare_classifies_files(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	old := &scan.Dir{
+		Name: "x",
+		Dirs: []*scan.Dir{
+			{Name: "d", Files: []*scan.File{file("b", 2, 2), file("g", 7, 7)}, EmptyFiles: []string{"e1"}},
+		},
+		Files: []*scan.File{
+			file("a", 1, 1),
+			file("c", 3, 3),
+			file("f", 4, 4),
+			file("h", 5, 5),
+			file("j", 8, 8),
+		},
+		EmptyFiles: []string{"e0"},
+	}
+	cur := &scan.Dir{
+		Name: "x",
+		Dirs: []*scan.Dir{
+			{Name: "d", Files: []*scan.File{file("a2", 1, 1), file("j2", 8, 8)}},
+			{Name: "e", Files: []*scan.File{file("b", 2, 2)}, EmptyFiles: []string{"e0", "e2"}},
+		},
+		Files: []*scan.File{
+			file("a", 1, 1),   // unchanged
+			file("c", 3, 30),  // modified
+			file("f2", 4, 4),  // renamed
+			file("i", 6, 6),   // added
+			file("g", 7, 7),   // moved
+			file("h2", 5, 50), // added (with "h" deleted)
+		},
+	}
+	res, err := Compare(old, cur)
+	require.NoError(t, err)
+	assert.Equal(t, &Result{
+		Root: "x",
+		Counts: map[Kind]int{
+			Unchanged:    1,
+			Modified:     1,
+			Added:        4,
+			Deleted:      2,
+			Moved:        3,
+			Renamed:      1,
+			MovedRenamed: 1,
+		},
+		Changes: []*Change{
+			{Kind: Unchanged, Path: "a"},
+			{Kind: Modified, Path: "c"},
+			{Kind: Added, Path: p("d/a2")}, // copy of "a"
+			{Kind: Deleted, OldPath: p("d/e1")},
+			{Kind: MovedRenamed, Path: p("d/j2"), OldPath: "j"},
+			{Kind: Moved, Path: p("e/b"), OldPath: p("d/b")},
+			{Kind: Moved, Path: p("e/e0"), OldPath: "e0"},
+			{Kind: Added, Path: p("e/e2")}, // empty files are only matched by name
+			{Kind: Renamed, Path: "f2", OldPath: "f"},
+			{Kind: Moved, Path: "g", OldPath: p("d/g")},
+			{Kind: Deleted, OldPath: "h"},
+			{Kind: Added, Path: "h2"},
+			{Kind: Added, Path: "i"},
+		},
+	}, res)
+}
+
+func Test__Compare_collapses_directory_moves(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	tests := []struct {
+		name string
+		old  *scan.Dir
+		cur  *scan.Dir
+		want []*Change
+	}{
+		{
+			name: "moved directory",
+			old: &scan.Dir{Name: "x", Dirs: []*scan.Dir{
+				{Name: "a", Files: []*scan.File{file("c", 3, 3)}, Dirs: []*scan.Dir{
+					{Name: "b", Files: []*scan.File{file("f1", 1, 1), file("f2", 2, 2)}},
+				}},
+			}},
+			cur: &scan.Dir{Name: "x", Dirs: []*scan.Dir{
+				{Name: "a", Files: []*scan.File{file("c", 3, 3)}},
+				{Name: "y", Dirs: []*scan.Dir{
+					{Name: "b", Files: []*scan.File{file("f1", 1, 1), file("f2", 2, 2)}},
+				}},
+			}},
+			want: []*Change{
+				{Kind: Unchanged, Path: p("a/c")},
+				{Kind: Moved, Path: p("y/b"), OldPath: p("a/b"), Dir: true, Files: 2},
+			},
+		},
+		{
+			name: "renamed directory with subdirectory",
+			old: &scan.Dir{Name: "x", Dirs: []*scan.Dir{
+				{Name: "d", Files: []*scan.File{file("f1", 1, 1)}, Dirs: []*scan.Dir{
+					{Name: "s", Files: []*scan.File{file("f2", 2, 2)}, EmptyFiles: []string{"e"}},
+				}},
+			}},
+			cur: &scan.Dir{Name: "x", Dirs: []*scan.Dir{
+				{Name: "e", Files: []*scan.File{file("f1", 1, 1)}, Dirs: []*scan.Dir{
+					{Name: "s", Files: []*scan.File{file("f2", 2, 2)}, EmptyFiles: []string{"e"}},
+				}},
+			}},
+			want: []*Change{
+				{Kind: Renamed, Path: "e", OldPath: "d", Dir: true, Files: 3},
+			},
+		},
+		{
+			name: "partially moved directory",
+			old: &scan.Dir{Name: "x", Dirs: []*scan.Dir{
+				{Name: "d", Files: []*scan.File{file("f1", 1, 1), file("f2", 2, 2)}},
+			}},
+			cur: &scan.Dir{Name: "x", Dirs: []*scan.Dir{
+				{Name: "d", Files: []*scan.File{file("f2", 2, 2)}},
+				{Name: "e", Files: []*scan.File{file("f1", 1, 1)}},
+			}},
+			want: []*Change{
+				{Kind: Unchanged, Path: p("d/f2")},
+				{Kind: Moved, Path: p("e/f1"), OldPath: p("d/f1")},
+			},
+		},
+		{
+			name: "directory moved into directory with other files",
+			old: &scan.Dir{Name: "x", Dirs: []*scan.Dir{
+				{Name: "d", Files: []*scan.File{file("f1", 1, 1), file("f2", 2, 2)}},
+			}},
+			cur: &scan.Dir{Name: "x", Dirs: []*scan.Dir{
+				{Name: "e", Files: []*scan.File{file("f1", 1, 1), file("f2", 2, 2), file("f3", 3, 3)}},
+			}},
+			want: []*Change{
+				{Kind: Moved, Path: p("e/f1"), OldPath: p("d/f1")},
+				{Kind: Moved, Path: p("e/f2"), OldPath: p("d/f2")},
+				{Kind: Added, Path: p("e/f3")},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := Compare(test.old, test.cur)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, res.Changes)
+		})
+	}
+}
+
+func Test__Compare_does_not_match_unhashed_files(t *testing.T) {
+	old := &scan.Dir{Name: "x", Files: []*scan.File{
+		{Name: "a", Size: 1, ModTime: 10, Unhashed: true},
+		{Name: "b", Size: 2, ModTime: 10, Unhashed: true},
+		{Name: "c", Size: 3, ModTime: 10, Unstable: true},
+	}}
+	cur := &scan.Dir{Name: "x", Files: []*scan.File{
+		{Name: "a", Size: 1, ModTime: 10, Hash: 1}, // compared by size and mod time
+		{Name: "b", Size: 2, ModTime: 11, Hash: 2}, // compared by size and mod time
+		{Name: "d", Size: 3, ModTime: 10, Hash: 3},
+	}}
+	res, err := Compare(old, cur)
+	require.NoError(t, err)
+	assert.Equal(t, []*Change{
+		{Kind: Unchanged, Path: "a"},
+		{Kind: Modified, Path: "b"},
+		{Kind: Deleted, OldPath: "c"},
+		{Kind: Added, Path: "d"},
+	}, res.Changes)
+}
+
+func Test__CompareWithOptions_applies_mod_time_tolerance_to_unhashed_files(t *testing.T) {
+	old := &scan.Dir{Name: "x", Files: []*scan.File{
+		{Name: "a", Size: 1, ModTime: 10, Unhashed: true},
+		{Name: "b", Size: 2, ModTime: 10, Unhashed: true},
+		{Name: "c", Size: 3, ModTime: 10, Hash: 3},
+	}}
+	cur := &scan.Dir{Name: "x", Files: []*scan.File{
+		{Name: "a", Size: 1, ModTime: 12, Hash: 1}, // within tolerance
+		{Name: "b", Size: 2, ModTime: 13, Hash: 2}, // outside tolerance
+		{Name: "c", Size: 3, ModTime: 20, Hash: 3}, // hashed files are compared by hash
+	}}
+	res, err := CompareWithOptions(old, cur, Options{ModTimeTolerance: 2 * time.Second})
+	require.NoError(t, err)
+	assert.Equal(t, []*Change{
+		{Kind: Unchanged, Path: "a"},
+		{Kind: Modified, Path: "b"},
+		{Kind: Unchanged, Path: "c"},
+	}, res.Changes)
+}
+
+func Test__Compare_different_roots_fails(t *testing.T) {
+	_, err := Compare(&scan.Dir{Name: "x"}, &scan.Dir{Name: "y"})
+	assert.EqualError(t, err, fmt.Sprintf("scans of different directories %q and %q cannot be compared", "x", "y"))
+}
+
+func Test__Compare_identical_scans_has_empty_changes(t *testing.T) {
+	res, err := Compare(&scan.Dir{Name: "x"}, &scan.Dir{Name: "x"})
+	require.NoError(t, err)
+	bs, err := json.Marshal(res)
+	require.NoError(t, err)
+	assert.Contains(t, string(bs), `"changes":[]`)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+
+	"github.com/pkg/errors"
+
+	"github.com/bisgardo/dupe-nukem/changes"
+	"github.com/bisgardo/dupe-nukem/scan"
+)
+
+// ChangesArgs holds the arguments of the "changes" command as passed from the command line.
+type ChangesArgs struct {
+	// Path of the result file of the old scan.
+	OldPath string
+	// Path of the result file of the new scan.
+	NewPath string
+	// Path mapping expressions ('<from>=<to>') to apply to the roots of the scans.
+	PathMap []string
+	// Whether to list unchanged files (they're always counted).
+	IncludeUnchanged bool
+	// Maximum difference between the modification times of unhashed files for them to be considered unchanged.
+	ModTimeTolerance string
+}
+
+// Changes loads the old and new scan files and compares them using changes.CompareWithOptions.
+func Changes(args ChangesArgs) (*changes.Result, error) {
+	if args.OldPath == "" {
+		return nil, errors.Errorf("no old scan file provided")
+	}
+	if args.NewPath == "" {
+		return nil, errors.Errorf("no new scan file provided")
+	}
+	modTimeTolerance, err := parseDuration(args.ModTimeTolerance)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid mod time tolerance %q", args.ModTimeTolerance)
+	}
+	pathMap, err := parsePathMap(args.PathMap)
+	if err != nil {
+		return nil, err
+	}
+	oldRoot, err := loadMappedScanRoot(args.OldPath, "old scan", pathMap)
+	if err != nil {
+		return nil, err
+	}
+	newRoot, err := loadMappedScanRoot(args.NewPath, "new scan", pathMap)
+	if err != nil {
+		return nil, err
+	}
+	res, err := changes.CompareWithOptions(oldRoot, newRoot, changes.Options{ModTimeTolerance: modTimeTolerance})
+	if err != nil {
+		return nil, err
+	}
+	c := res.Counts
+	log.Printf(
+		"compared scans of %q: %d unchanged, %d modified, %d added, %d deleted, %d moved, %d renamed, %d moved and renamed file(s)\n",
+		res.Root, c[changes.Unchanged], c[changes.Modified], c[changes.Added], c[changes.Deleted], c[changes.Moved], c[changes.Renamed], c[changes.MovedRenamed],
+	)
+	if !args.IncludeUnchanged {
+		var cs []*changes.Change
+		for _, ch := range res.Changes {
+			if ch.Kind != changes.Unchanged {
+				cs = append(cs, ch)
+			}
+		}
+		res.Changes = cs
+	}
+	return res, nil
+}
+
+// loadMappedScanRoot loads the root of the scan result file at the provided path (see loadScanRoot)
+// and applies the provided path map to its name.
+func loadMappedScanRoot(path, desc string, pathMap scan.PathMap) (*scan.Dir, error) {
+	root, err := loadScanRoot(path, desc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot load %s file %q", desc, path)
+	}
+	if name, ok := pathMap.Map(root.Name); ok {
+		log.Printf("mapping root %q of %s to %q\n", root.Name, desc, name)
+		root.Name = name
+	}
+	return root, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/changes"
+	"github.com/bisgardo/dupe-nukem/scan"
+	. "github.com/bisgardo/dupe-nukem/testutil"
+)
+
+func tempScanFile(t *testing.T, root *scan.Dir) string {
+	bs, err := json.Marshal(&scan.Result{TypeVersion: scan.CurrentResultTypeVersion, Root: root})
+	require.NoError(t, err)
+	return TempStringFile(t, string(bs))
+}
+
+func Test__Changes_compares_scans_with_mapped_root(t *testing.T) {
+	oldPath := tempScanFile(t, &scan.Dir{
+		Name:  "/mnt/x",
+		Files: []*scan.File{{Name: "a", Size: 1, Hash: 1}, {Name: "b", Size: 2, Hash: 2}},
+	})
+	newPath := tempScanFile(t, &scan.Dir{
+		Name:  "/data",
+		Files: []*scan.File{{Name: "a", Size: 1, Hash: 1}, {Name: "c", Size: 2, Hash: 2}},
+	})
+	logs := CaptureLogs(t)
+
+	res, err := Changes(ChangesArgs{OldPath: oldPath, NewPath: newPath, PathMap: []string{"/mnt/x=/data"}})
+	require.NoError(t, err)
+	assert.Equal(t, &changes.Result{
+		Root:    "/data",
+		Counts:  map[changes.Kind]int{changes.Unchanged: 1, changes.Renamed: 1},
+		Changes: []*changes.Change{{Kind: changes.Renamed, Path: "c", OldPath: "b"}}, // unchanged files aren't listed
+	}, res)
+	assert.Contains(t, logs.String(), fmt.Sprintf("mapping root %q of old scan to %q\n", "/mnt/x", "/data"))
+	assert.Contains(t, logs.String(), fmt.Sprintf("compared scans of %q: 1 unchanged, 0 modified, 0 added, 0 deleted, 0 moved, 1 renamed, 0 moved and renamed file(s)\n", "/data"))
+
+	res, err = Changes(ChangesArgs{OldPath: oldPath, NewPath: newPath, PathMap: []string{"/mnt/x=/data"}, IncludeUnchanged: true})
+	require.NoError(t, err)
+	assert.Len(t, res.Changes, 2)
+}
+
+func Test__Changes_applies_mod_time_tolerance(t *testing.T) {
+	oldPath := tempScanFile(t, &scan.Dir{Name: "x", Files: []*scan.File{{Name: "a", Size: 1, ModTime: 10, Unhashed: true}}})
+	newPath := tempScanFile(t, &scan.Dir{Name: "x", Files: []*scan.File{{Name: "a", Size: 1, ModTime: 12, Hash: 1}}})
+
+	res, err := Changes(ChangesArgs{OldPath: oldPath, NewPath: newPath})
+	require.NoError(t, err)
+	assert.Equal(t, map[changes.Kind]int{changes.Modified: 1}, res.Counts)
+
+	res, err = Changes(ChangesArgs{OldPath: oldPath, NewPath: newPath, ModTimeTolerance: "2s"})
+	require.NoError(t, err)
+	assert.Equal(t, map[changes.Kind]int{changes.Unchanged: 1}, res.Counts)
+}
+
+func Test__Changes_fails(t *testing.T) {
+	scanPath := tempScanFile(t, &scan.Dir{Name: "x"})
+	otherScanPath := tempScanFile(t, &scan.Dir{Name: "y"})
+	tests := []struct {
+		name    string
+		args    ChangesArgs
+		wantErr string
+	}{
+		{name: "no old scan", args: ChangesArgs{NewPath: scanPath}, wantErr: "no old scan file provided"},
+		{name: "no new scan", args: ChangesArgs{OldPath: scanPath}, wantErr: "no new scan file provided"},
+		{
+			name:    "missing old scan",
+			args:    ChangesArgs{OldPath: "missing", NewPath: scanPath},
+			wantErr: `cannot load old scan file "missing": cannot open file: not found`,
+		},
+		{
+			name:    "invalid mod time tolerance",
+			args:    ChangesArgs{OldPath: scanPath, NewPath: scanPath, ModTimeTolerance: "x"},
+			wantErr: `invalid mod time tolerance "x": invalid duration`,
+		},
+		{
+			name:    "different roots",
+			args:    ChangesArgs{OldPath: scanPath, NewPath: otherScanPath},
+			wantErr: `scans of different directories "x" and "y" cannot be compared`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Changes(test.args)
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
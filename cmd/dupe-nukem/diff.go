@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log"
+
+	"github.com/bisgardo/dupe-nukem/match"
+)
+
+// DiffArgs holds the arguments of the "diff" command as passed from the command line.
+type DiffArgs struct {
+	// Path of the result file of the scan of the source directory.
+	SourcePath string
+	// Paths of the result files of the scans of the target directories.
+	TargetPaths []string
+	// Path mapping expressions ('<from>=<to>') to apply to the roots of the scans.
+	PathMap []string
+	// Mode of matching files (see match.Mode; empty for the default).
+	Mode string
+	// Whether to match names regardless of case.
+	IgnoreCase bool
+	// Whether to match names regardless of Unicode normalization form.
+	NormalizeUnicode bool
+}
+
+// Diff loads the source and target scan files and diffs them using match.Diff.
+func Diff(args DiffArgs) (*match.DiffResult, error) {
+	source, targets, err := loadSourceAndTargets(args.SourcePath, args.TargetPaths, args.PathMap)
+	if err != nil {
+		return nil, err
+	}
+	res, err := match.Diff(source, targets, match.Options{
+		Mode:             match.Mode(args.Mode),
+		IgnoreCase:       args.IgnoreCase,
+		NormalizeUnicode: args.NormalizeUnicode,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("found %d of %d file(s) of %q missing from targets (%d byte(s))\n", len(res.Missing), res.Files, res.Source, res.MissingBytes)
+	return res, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/match"
+	"github.com/bisgardo/dupe-nukem/scan"
+	. "github.com/bisgardo/dupe-nukem/testutil"
+)
+
+func Test__Diff_lists_files_missing_from_targets(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	sourcePath := tempScanFile(t, &scan.Dir{Name: p("/src"), Files: []*scan.File{{Name: "a", Size: 1, Hash: 1}, {Name: "b", Size: 3, Hash: 2}}})
+	targetPaths := []string{
+		tempScanFile(t, &scan.Dir{Name: p("/x"), Files: []*scan.File{{Name: "A", Size: 1, Hash: 1}}}),
+		tempScanFile(t, &scan.Dir{Name: p("/y"), Dirs: []*scan.Dir{{Name: "d", Files: []*scan.File{{Name: "b", Size: 3, Hash: 2}}}}}),
+	}
+	tests := []struct {
+		args        DiffArgs
+		wantMissing []string
+	}{
+		{args: DiffArgs{}, wantMissing: []string{}},
+		{args: DiffArgs{Mode: "same-name"}, wantMissing: []string{"a"}},
+		{args: DiffArgs{Mode: "same-name", IgnoreCase: true}, wantMissing: []string{}},
+		{args: DiffArgs{Mode: "exact", IgnoreCase: true}, wantMissing: []string{"b"}},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%+v", test.args), func(t *testing.T) {
+			logs := CaptureLogs(t)
+			args := test.args
+			args.SourcePath = sourcePath
+			args.TargetPaths = targetPaths
+			res, err := Diff(args)
+			require.NoError(t, err)
+			assert.Equal(t, test.wantMissing, res.Missing)
+			assert.Equal(t, []string{p("/x"), p("/y")}, res.Targets)
+			assert.Equal(t, 2, res.Files)
+			assert.Contains(t, logs.String(), fmt.Sprintf("found %d of 2 file(s) of %q missing from targets", len(test.wantMissing), p("/src")))
+		})
+	}
+}
+
+func Test__Diff_reports_mode(t *testing.T) {
+	scanPath := tempScanFile(t, &scan.Dir{Name: "x"})
+	res, err := Diff(DiffArgs{SourcePath: scanPath, TargetPaths: []string{scanPath}})
+	require.NoError(t, err)
+	assert.Equal(t, match.ModeAnywhere, res.Mode)
+}
+
+func Test__Diff_fails(t *testing.T) {
+	scanPath := tempScanFile(t, &scan.Dir{Name: "x"})
+	tests := []struct {
+		name    string
+		args    DiffArgs
+		wantErr string
+	}{
+		{name: "no source", args: DiffArgs{TargetPaths: []string{scanPath}}, wantErr: "no source scan file provided"},
+		{name: "no targets", args: DiffArgs{SourcePath: scanPath}, wantErr: "no target scan files provided"},
+		{
+			name:    "missing source",
+			args:    DiffArgs{SourcePath: "missing", TargetPaths: []string{scanPath}},
+			wantErr: `cannot load source scan file "missing": cannot open file: not found`,
+		},
+		{
+			name:    "invalid mode",
+			args:    DiffArgs{SourcePath: scanPath, TargetPaths: []string{scanPath}, Mode: "x"},
+			wantErr: `invalid mode "x" (valid modes are ["exact" "same-dir" "same-name" "anywhere"])`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Diff(test.args)
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
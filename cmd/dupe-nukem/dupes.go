@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log"
+
+	"github.com/pkg/errors"
+
+	"github.com/bisgardo/dupe-nukem/dupes"
+	"github.com/bisgardo/dupe-nukem/plan"
+	"github.com/bisgardo/dupe-nukem/scan"
+)
+
+// DupesArgs holds the arguments of the "dupes" command as passed from the command line.
+type DupesArgs struct {
+	// Paths of the result files of the scans to search for duplicates in.
+	ScanPaths []string
+	// Path mapping expressions ('<from>=<to>') to apply to the roots of the scans.
+	PathMap []string
+	// Path of a file with rules for choosing the copy to keep of each group (see plan.ParseRules).
+	// If provided, the groups are annotated with the chosen copies.
+	RulesPath string
+}
+
+// Dupes loads the scan files (with the path mapping applied to their roots) and searches for duplicates within (and across) them using dupes.Find.
+func Dupes(args DupesArgs) (*dupes.Result, error) {
+	if len(args.ScanPaths) == 0 {
+		return nil, errors.Errorf("no scan files provided")
+	}
+	rules, err := loadRules(args.RulesPath, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	pathMap, err := parsePathMap(args.PathMap)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := loadMappedScanRoots(args.ScanPaths, pathMap)
+	if err != nil {
+		return nil, err
+	}
+	res, err := dupes.Find(roots)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("found %d group(s) of duplicates with %d reclaimable byte(s)\n", len(res.Groups), res.Reclaimable)
+	if args.RulesPath != "" {
+		if err := plan.AnnotateDupes(res, roots, rules); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// loadMappedScanRoots loads the roots of the scan files at the provided paths with the provided path mapping applied.
+func loadMappedScanRoots(paths []string, pathMap scan.PathMap) ([]*scan.Dir, error) {
+	res := make([]*scan.Dir, len(paths))
+	for i, p := range paths {
+		root, err := loadMappedScanRoot(p, "scan", pathMap)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = root
+	}
+	return res, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/dupes"
+	"github.com/bisgardo/dupe-nukem/scan"
+	. "github.com/bisgardo/dupe-nukem/testutil"
+)
+
+func Test__Dupes_finds_duplicates_across_scans(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	scanPaths := []string{
+		tempScanFile(t, &scan.Dir{Name: p("/x"), Files: []*scan.File{{Name: "a", Size: 1, Hash: 1}, {Name: "b", Size: 2, Hash: 2}}}),
+		tempScanFile(t, &scan.Dir{Name: p("/y"), Files: []*scan.File{{Name: "c", Size: 2, Hash: 2}}}),
+	}
+	logs := CaptureLogs(t)
+
+	res, err := Dupes(DupesArgs{ScanPaths: scanPaths})
+	require.NoError(t, err)
+	assert.Equal(t, &dupes.Result{
+		Roots:       []string{p("/x"), p("/y")},
+		Groups:      []*dupes.Group{{Size: 2, Hash: 2, Paths: []string{p("/x/b"), p("/y/c")}, Reclaimable: 2}},
+		Reclaimable: 2,
+	}, res)
+	assert.Contains(t, logs.String(), "found 1 group(s) of duplicates with 2 reclaimable byte(s)\n")
+}
+
+func Test__Dupes_applies_path_map_to_roots(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	scanPaths := []string{
+		tempScanFile(t, &scan.Dir{Name: p("/mnt/x"), Files: []*scan.File{{Name: "a", Size: 1, Hash: 1}}}),
+		tempScanFile(t, &scan.Dir{Name: p("/y"), Files: []*scan.File{{Name: "b", Size: 1, Hash: 1}}}),
+	}
+	logs := CaptureLogs(t)
+
+	res, err := Dupes(DupesArgs{ScanPaths: scanPaths, PathMap: []string{p("/mnt/x") + "=" + p("/x")}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{p("/x"), p("/y")}, res.Roots)
+	assert.Equal(t, []string{p("/x/a"), p("/y/b")}, res.Groups[0].Paths)
+	assert.Contains(t, logs.String(), fmt.Sprintf("mapping root %q of scan to %q\n", p("/mnt/x"), p("/x")))
+}
+
+func Test__Dupes_annotates_kept_copies_with_rules(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	scanPaths := []string{
+		tempScanFile(t, &scan.Dir{Name: p("/x"), Files: []*scan.File{{Name: "a", Size: 2, Hash: 2, ModTime: 1}}}),
+		tempScanFile(t, &scan.Dir{Name: p("/y"), Files: []*scan.File{{Name: "b", Size: 2, Hash: 2, ModTime: 2}}}),
+	}
+	rulesPath := TempStringFile(t, Lines("# keep the newest copy", "newest", "oldest"))
+
+	res, err := Dupes(DupesArgs{ScanPaths: scanPaths, RulesPath: rulesPath})
+	require.NoError(t, err)
+	assert.Equal(t, []*dupes.Group{
+		{Size: 2, Hash: 2, Paths: []string{p("/x/a"), p("/y/b")}, Reclaimable: 2, Keep: p("/y/b"), KeepRule: "newest"},
+	}, res.Groups)
+}
+
+func Test__Dupes_fails(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	scanPath := tempScanFile(t, &scan.Dir{Name: p("/x")})
+	invalidRulesPath := TempStringFile(t, "prefer")
+	tests := []struct {
+		name    string
+		args    DupesArgs
+		wantErr string
+	}{
+		{name: "no scans", args: DupesArgs{}, wantErr: "no scan files provided"},
+		{
+			name:    "missing scan",
+			args:    DupesArgs{ScanPaths: []string{scanPath, "missing"}},
+			wantErr: `cannot load scan file "missing": cannot open file: not found`,
+		},
+		{
+			name:    "same scan twice",
+			args:    DupesArgs{ScanPaths: []string{scanPath, scanPath}},
+			wantErr: fmt.Sprintf("scans of directories %q and %q overlap", p("/x"), p("/x")),
+		},
+		{
+			name:    "invalid path map",
+			args:    DupesArgs{ScanPaths: []string{scanPath}, PathMap: []string{"x"}},
+			wantErr: `invalid path mapping "x": missing '='`,
+		},
+		{
+			name:    "missing rules file",
+			args:    DupesArgs{ScanPaths: []string{scanPath}, RulesPath: "missing"},
+			wantErr: `cannot open rules file "missing": not found`,
+		},
+		{
+			name:    "invalid rules file",
+			args:    DupesArgs{ScanPaths: []string{scanPath}, RulesPath: invalidRulesPath},
+			wantErr: fmt.Sprintf(`cannot parse rules file %q: line 1: rule "prefer" requires a directory`, invalidRulesPath),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Dupes(test.args)
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
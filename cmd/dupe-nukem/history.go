@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/bisgardo/dupe-nukem/changes"
+	"github.com/bisgardo/dupe-nukem/history"
+	"github.com/bisgardo/dupe-nukem/scan"
+)
+
+// HistoryArgs holds the arguments of the "history" command as passed from the command line.
+type HistoryArgs struct {
+	// Paths of the result files of the scans in chronological order.
+	ScanPaths []string
+	// Path mapping expressions ('<from>=<to>') to apply to the roots of the scans.
+	PathMap []string
+	// Path (relative to the root or absolute) of the file or directory to only include the events of.
+	Path string
+	// Hash of the file contents to only include the events of.
+	Hash string
+	// Maximum difference between the modification times of unhashed files for them to be considered unchanged.
+	ModTimeTolerance string
+}
+
+// History loads the scan files and builds their timeline using history.BuildWithOptions.
+// If a path or hash is provided, only the events of the matching files are included.
+func History(args HistoryArgs) (*history.Timeline, error) {
+	if len(args.ScanPaths) < 2 {
+		return nil, errors.Errorf("at least two scan files must be provided")
+	}
+	if args.Path != "" && args.Hash != "" {
+		return nil, errors.Errorf("cannot filter by both path and hash")
+	}
+	var hash uint64
+	if args.Hash != "" {
+		h, err := strconv.ParseUint(args.Hash, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid hash %q", args.Hash)
+		}
+		hash = h
+	}
+	modTimeTolerance, err := parseDuration(args.ModTimeTolerance)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid mod time tolerance %q", args.ModTimeTolerance)
+	}
+	pathMap, err := parsePathMap(args.PathMap)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*scan.Result, len(args.ScanPaths))
+	for i, p := range args.ScanPaths {
+		res, err := loadScanResult(p, "scan")
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot load scan file %q", p)
+		}
+		if name, ok := pathMap.Map(res.Root.Name); ok {
+			log.Printf("mapping root %q of scan %d to %q\n", res.Root.Name, i, name)
+			res.Root.Name = name
+		}
+		results[i] = res
+	}
+	t, err := history.BuildWithOptions(results, changes.Options{ModTimeTolerance: modTimeTolerance})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("built history of %q from %d scans: %d event(s)\n", t.Root, len(t.Scans), len(t.Events))
+	switch {
+	case args.Path != "":
+		path, err := historyPath(t.Root, args.Path)
+		if err != nil {
+			return nil, err
+		}
+		t.Events = t.FilterPath(path)
+	case args.Hash != "":
+		t.Events = t.FilterHash(hash)
+	}
+	return t, nil
+}
+
+// historyPath returns the provided path relative to the provided root.
+// Absolute paths must be located inside the root; relative ones are assumed to already be relative to it.
+func historyPath(root, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return filepath.Clean(path), nil
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || filepath.IsAbs(rel) || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("path %q is not inside root directory %q", path, root)
+	}
+	return rel, nil
+}
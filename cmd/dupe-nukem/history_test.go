@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/changes"
+	"github.com/bisgardo/dupe-nukem/scan"
+	. "github.com/bisgardo/dupe-nukem/testutil"
+)
+
+func tempTimedScanFile(t *testing.T, ts time.Time, root *scan.Dir) string {
+	bs, err := json.Marshal(&scan.Result{TypeVersion: scan.CurrentResultTypeVersion, Metadata: &scan.Metadata{StartTime: ts}, Root: root})
+	require.NoError(t, err)
+	return TempStringFile(t, string(bs))
+}
+
+// testAbsPath returns the provided slash-separated path as an absolute path (with volume name on Windows).
+func testAbsPath(t *testing.T, path string) string {
+	res, err := filepath.Abs(filepath.FromSlash(path))
+	require.NoError(t, err)
+	return res
+}
+
+func Test__History_builds_timeline_of_scans_with_mapped_roots(t *testing.T) {
+	p := func(path string) string { return testAbsPath(t, path) }
+	ts := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	scanPaths := []string{
+		tempTimedScanFile(t, ts, &scan.Dir{Name: p("/mnt/x"), Files: []*scan.File{{Name: "a", Size: 1, Hash: 1}}}),
+		tempTimedScanFile(t, ts.Add(time.Hour), &scan.Dir{Name: p("/data"), Files: []*scan.File{{Name: "b", Size: 1, Hash: 1}}}),
+		tempTimedScanFile(t, ts.Add(2*time.Hour), &scan.Dir{Name: p("/data"), Files: []*scan.File{{Name: "b", Size: 2, Hash: 2}, {Name: "c", Size: 3, Hash: 3}}}),
+	}
+	pathMap := []string{p("/mnt/x") + "=" + p("/data")}
+	logs := CaptureLogs(t)
+
+	res, err := History(HistoryArgs{ScanPaths: scanPaths, PathMap: pathMap})
+	require.NoError(t, err)
+	assert.Equal(t, p("/data"), res.Root)
+	assert.Len(t, res.Scans, 3)
+	assert.Len(t, res.Events, 3)
+	assert.Contains(t, logs.String(), fmt.Sprintf("mapping root %q of scan 0 to %q\n", p("/mnt/x"), p("/data")))
+	assert.Contains(t, logs.String(), fmt.Sprintf("built history of %q from 3 scans: 3 event(s)\n", p("/data")))
+
+	tests := []struct {
+		name      string
+		args      HistoryArgs
+		wantKinds []changes.Kind
+	}{
+		{name: "relative path", args: HistoryArgs{Path: "b"}, wantKinds: []changes.Kind{changes.Renamed, changes.Modified}},
+		{name: "absolute path", args: HistoryArgs{Path: p("/data/b")}, wantKinds: []changes.Kind{changes.Renamed, changes.Modified}},
+		{name: "old path", args: HistoryArgs{Path: "a"}, wantKinds: []changes.Kind{changes.Renamed}},
+		{name: "hash", args: HistoryArgs{Hash: "3"}, wantKinds: []changes.Kind{changes.Added}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.args.ScanPaths, test.args.PathMap = scanPaths, pathMap
+			res, err := History(test.args)
+			require.NoError(t, err)
+			var kinds []changes.Kind
+			for _, e := range res.Events {
+				kinds = append(kinds, e.Kind)
+			}
+			assert.Equal(t, test.wantKinds, kinds)
+		})
+	}
+}
+
+func Test__History_fails(t *testing.T) {
+	p := func(path string) string { return testAbsPath(t, path) }
+	ts := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	scanPath := tempTimedScanFile(t, ts, &scan.Dir{Name: p("/x")})
+	tests := []struct {
+		name    string
+		args    HistoryArgs
+		wantErr string
+	}{
+		{name: "single scan", args: HistoryArgs{ScanPaths: []string{scanPath}}, wantErr: "at least two scan files must be provided"},
+		{
+			name:    "path and hash",
+			args:    HistoryArgs{ScanPaths: []string{scanPath, scanPath}, Path: "a", Hash: "1"},
+			wantErr: "cannot filter by both path and hash",
+		},
+		{
+			name:    "invalid hash",
+			args:    HistoryArgs{ScanPaths: []string{scanPath, scanPath}, Hash: "x"},
+			wantErr: `invalid hash "x": strconv.ParseUint: parsing "x": invalid syntax`,
+		},
+		{
+			name:    "invalid mod time tolerance",
+			args:    HistoryArgs{ScanPaths: []string{scanPath, scanPath}, ModTimeTolerance: "x"},
+			wantErr: `invalid mod time tolerance "x": invalid duration`,
+		},
+		{
+			name:    "missing scan",
+			args:    HistoryArgs{ScanPaths: []string{scanPath, "missing"}},
+			wantErr: `cannot load scan file "missing": cannot open file: not found`,
+		},
+		{
+			name:    "path outside root",
+			args:    HistoryArgs{ScanPaths: []string{scanPath, scanPath}, Path: p("/y/a")},
+			wantErr: fmt.Sprintf("path %q is not inside root directory %q", p("/y/a"), p("/x")),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := History(test.args)
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+
+	"github.com/pkg/errors"
+
+	"github.com/bisgardo/dupe-nukem/dupes"
+	"github.com/bisgardo/dupe-nukem/link"
+	"github.com/bisgardo/dupe-nukem/plan"
+)
+
+// LinkArgs holds the arguments of the "link" command as passed from the command line.
+type LinkArgs struct {
+	// Path of the output file of a call to "dupes".
+	DupesPath string
+	// Paths of the result files of the scans that the duplicates were found in.
+	ScanPaths []string
+	// Path mapping expressions ('<from>=<to>') to apply to the roots of the scans.
+	PathMap []string
+	// Path of a file with rules for choosing the copy to keep (see plan.ParseRules).
+	RulesPath string
+	// Rule (without directory) for choosing among equally preferred copies (see PlanArgs).
+	Keep string
+	// Directories whose copies are preferred to keep (see PlanArgs).
+	Prefer []string
+	// Reflink enables replacing copies with reflinks rather than hardlinks.
+	Reflink bool
+	// Execute enables actually replacing files (otherwise they're only verified).
+	Execute bool
+}
+
+// Link loads the result of "dupes" along with the scan files that it was computed from
+// and replaces all copies but one of each group of duplicates on the same device with links to the kept copy
+// using link.Run. Nothing is replaced unless Execute is set.
+func Link(args LinkArgs) (*link.Result, error) {
+	if args.DupesPath == "" {
+		return nil, errors.Errorf("no dupes file provided")
+	}
+	if len(args.ScanPaths) == 0 {
+		return nil, errors.Errorf("no scan files provided")
+	}
+	rules, err := loadRules(args.RulesPath, args.Keep, args.Prefer)
+	if err != nil {
+		return nil, err
+	}
+	pathMap, err := parsePathMap(args.PathMap)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := loadMappedScanRoots(args.ScanPaths, pathMap)
+	if err != nil {
+		return nil, err
+	}
+	var res dupes.Result
+	if err := loadJSONFile(args.DupesPath, &res); err != nil {
+		return nil, errors.Wrapf(err, "cannot load dupes file %q", args.DupesPath)
+	}
+	groups, err := plan.FromDupes(&res, roots)
+	if err != nil {
+		return nil, err
+	}
+	groups, unknown := link.ByDevice(groups)
+	for _, c := range unknown {
+		log.Printf("skipping %q: device not known from scan\n", c.Path)
+	}
+	p, err := plan.Build(groups, plan.Options{Action: plan.Hardlink, Rules: rules})
+	if err != nil {
+		return nil, err
+	}
+	linked, err := link.Run(p, link.Options{Reflink: args.Reflink, DryRun: !args.Execute})
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range linked.Skipped {
+		log.Printf("skipping %q: %s\n", s.Path, s.Reason)
+	}
+	kind := "hardlink(s)"
+	if linked.Reflink {
+		kind = "reflink(s)"
+	}
+	if linked.DryRun {
+		log.Printf("dry run: would replace %d file(s) with %s reclaiming %d byte(s) (skipped %d)\n", len(linked.Entries), kind, linked.Reclaimed, len(linked.Skipped))
+	} else {
+		log.Printf("replaced %d file(s) with %s reclaiming %d byte(s) (skipped %d)\n", len(linked.Entries), kind, linked.Reclaimed, len(linked.Skipped))
+	}
+	return linked, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/dupes"
+	"github.com/bisgardo/dupe-nukem/link"
+	"github.com/bisgardo/dupe-nukem/scan"
+	. "github.com/bisgardo/dupe-nukem/testutil"
+)
+
+func Test__Link_replaces_scanned_duplicates_with_hardlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("device IDs aren't recorded by scans on Windows")
+	}
+	rootPath, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	for _, n := range []string{"a", "b", "c"} {
+		require.NoError(t, os.WriteFile(filepath.Join(rootPath, n), []byte("abc"), 0644))
+	}
+	scanRes, err := Scan(rootPath, ScanArgs{})
+	require.NoError(t, err)
+	scanPath := tempScanFile(t, scanRes.Root)
+	dupesRes, err := Dupes(DupesArgs{ScanPaths: []string{scanPath}})
+	require.NoError(t, err)
+	dupesPath := tempJSONFile(t, dupesRes)
+	logs := CaptureLogs(t)
+
+	res, err := Link(LinkArgs{DupesPath: dupesPath, ScanPaths: []string{scanPath}, Keep: "shortest"})
+	require.NoError(t, err)
+	assert.True(t, res.DryRun)
+	assert.EqualValues(t, 6, res.Reclaimed)
+	assert.Contains(t, logs.String(), "dry run: would replace 2 file(s) with hardlink(s) reclaiming 6 byte(s) (skipped 0)\n")
+
+	res, err = Link(LinkArgs{DupesPath: dupesPath, ScanPaths: []string{scanPath}, Keep: "shortest", Execute: true})
+	require.NoError(t, err)
+	p := func(n string) string { return filepath.Join(rootPath, n) }
+	assert.Equal(t, &link.Result{
+		Entries: []*link.Entry{
+			{Path: p("b"), Keep: p("a"), Size: 3, Reclaimed: 3},
+			{Path: p("c"), Keep: p("a"), Size: 3, Reclaimed: 3},
+		},
+		Reclaimed: 6,
+	}, res)
+	assert.Contains(t, logs.String(), "replaced 2 file(s) with hardlink(s) reclaiming 6 byte(s) (skipped 0)\n")
+	a, err := os.Stat(p("a"))
+	require.NoError(t, err)
+	for _, n := range []string{"b", "c"} {
+		info, err := os.Stat(p(n))
+		require.NoError(t, err)
+		assert.True(t, os.SameFile(a, info))
+	}
+}
+
+func Test__Link_skips_copies_on_unknown_devices(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	scanPath := tempScanFile(t, &scan.Dir{Name: p("/x"), Files: []*scan.File{
+		{Name: "a", Size: 1, Hash: 1, Device: 1},
+		{Name: "b", Size: 1, Hash: 1},
+	}})
+	dupesPath := tempJSONFile(t, &dupes.Result{
+		Roots:  []string{p("/x")},
+		Groups: []*dupes.Group{{Size: 1, Hash: 1, Paths: []string{p("/x/a"), p("/x/b")}, Reclaimable: 1}},
+	})
+	logs := CaptureLogs(t)
+
+	res, err := Link(LinkArgs{DupesPath: dupesPath, ScanPaths: []string{scanPath}})
+	require.NoError(t, err)
+	assert.Empty(t, res.Entries)
+	assert.Contains(t, logs.String(), fmt.Sprintf("skipping %q: device not known from scan\n", p("/x/b")))
+	assert.Contains(t, logs.String(), "dry run: would replace 0 file(s) with hardlink(s) reclaiming 0 byte(s) (skipped 0)\n")
+}
+
+func Test__Link_fails(t *testing.T) {
+	scanPath := tempScanFile(t, &scan.Dir{Name: "x"})
+	dupesPath := tempJSONFile(t, &dupes.Result{})
+	tests := []struct {
+		name    string
+		args    LinkArgs
+		wantErr string
+	}{
+		{name: "no dupes file", args: LinkArgs{ScanPaths: []string{scanPath}}, wantErr: "no dupes file provided"},
+		{name: "no scans", args: LinkArgs{DupesPath: dupesPath}, wantErr: "no scan files provided"},
+		{
+			name:    "missing dupes file",
+			args:    LinkArgs{DupesPath: "missing", ScanPaths: []string{scanPath}},
+			wantErr: `cannot load dupes file "missing": cannot open file: not found`,
+		},
+		{
+			name:    "invalid keep rule",
+			args:    LinkArgs{DupesPath: dupesPath, ScanPaths: []string{scanPath}, Keep: "x"},
+			wantErr: `invalid keep rule "x": invalid rule "x" (valid rules are ["prefer" "protect" "oldest" "newest" "shortest" "complete"])`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Link(test.args)
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/spf13/cobra"
 )
@@ -49,11 +50,200 @@ func main() {
 			if err != nil {
 				return err
 			}
-			cacheFile, err := flags.GetString("cache")
+			includeExpr, err := flags.GetString("include")
 			if err != nil {
 				return err
 			}
-			res, err := Scan(dir, skipExpr, cacheFile)
+			cacheFiles, err := flags.GetStringArray("cache")
+			if err != nil {
+				return err
+			}
+			pathMap, err := flags.GetStringArray("map")
+			if err != nil {
+				return err
+			}
+			hashDBFile, err := flags.GetString("hash-db")
+			if err != nil {
+				return err
+			}
+			hashRetries, err := flags.GetInt("hash-retries")
+			if err != nil {
+				return err
+			}
+			verifyAgainstFile, err := flags.GetString("verify-against")
+			if err != nil {
+				return err
+			}
+			modTimeTolerance, err := flags.GetString("mtime-tolerance")
+			if err != nil {
+				return err
+			}
+			minSize, err := flags.GetString("min-size")
+			if err != nil {
+				return err
+			}
+			maxSize, err := flags.GetString("max-size")
+			if err != nil {
+				return err
+			}
+			newerThan, err := flags.GetString("newer-than")
+			if err != nil {
+				return err
+			}
+			olderThan, err := flags.GetString("older-than")
+			if err != nil {
+				return err
+			}
+			followSymlinks, err := flags.GetBool("follow-symlinks")
+			if err != nil {
+				return err
+			}
+			oneFileSystem, err := flags.GetBool("one-file-system")
+			if err != nil {
+				return err
+			}
+			failOnError, err := flags.GetBool("fail-on-error")
+			if err != nil {
+				return err
+			}
+			maxErrors, err := flags.GetInt("max-errors")
+			if err != nil {
+				return err
+			}
+			if failOnError {
+				maxErrors = 0
+			}
+			res, err := Scan(dir, ScanArgs{
+				SkipExpr:          skipExpr,
+				IncludeExpr:       includeExpr,
+				CachePaths:        cacheFiles,
+				PathMap:           pathMap,
+				HashDBPath:        hashDBFile,
+				HashRetries:       hashRetries,
+				VerifyAgainstPath: verifyAgainstFile,
+				ModTimeTolerance:  modTimeTolerance,
+				MinSize:           minSize,
+				MaxSize:           maxSize,
+				NewerThan:         newerThan,
+				OlderThan:         olderThan,
+				FollowSymlinks:    followSymlinks,
+				OneFileSystem:     oneFileSystem,
+			})
+			if err != nil {
+				return err
+			}
+			bs, err := json.MarshalIndent(res, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(bs))
+			// Check error count and verification after printing the result such that it's available even if the checks fail.
+			if err := checkScanErrorCount(res, maxErrors); err != nil {
+				return err
+			}
+			return checkVerification(res)
+		},
+	}
+	matchCmd := &cobra.Command{
+		Use:   "match",
+		Short: "Find directories of a source scan that are identical or similar to directories of target scans and dump them as JSON",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			sourceFile, err := flags.GetString("source")
+			if err != nil {
+				return err
+			}
+			targetFiles, err := flags.GetStringSlice("targets")
+			if err != nil {
+				return err
+			}
+			pathMap, err := flags.GetStringArray("map")
+			if err != nil {
+				return err
+			}
+			minScore, err := flags.GetFloat64("min-score")
+			if err != nil {
+				return err
+			}
+			maxCandidates, err := flags.GetInt("max-candidates")
+			if err != nil {
+				return err
+			}
+			mode, err := flags.GetString("mode")
+			if err != nil {
+				return err
+			}
+			ignoreCase, err := flags.GetBool("ignore-case")
+			if err != nil {
+				return err
+			}
+			normalizeUnicode, err := flags.GetBool("normalize-unicode")
+			if err != nil {
+				return err
+			}
+			rulesFile, err := flags.GetString("rules")
+			if err != nil {
+				return err
+			}
+			res, err := Match(MatchArgs{
+				SourcePath:       sourceFile,
+				TargetPaths:      targetFiles,
+				PathMap:          pathMap,
+				MinScore:         minScore,
+				MaxCandidates:    maxCandidates,
+				Mode:             mode,
+				IgnoreCase:       ignoreCase,
+				NormalizeUnicode: normalizeUnicode,
+				RulesPath:        rulesFile,
+			})
+			if err != nil {
+				return err
+			}
+			bs, err := json.MarshalIndent(res, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(bs))
+			return nil
+		},
+	}
+	diffCmd := &cobra.Command{
+		Use:   "diff",
+		Short: "List the files of a source scan that aren't present in any target scans and dump them as JSON",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			sourceFile, err := flags.GetString("source")
+			if err != nil {
+				return err
+			}
+			targetFiles, err := flags.GetStringSlice("targets")
+			if err != nil {
+				return err
+			}
+			pathMap, err := flags.GetStringArray("map")
+			if err != nil {
+				return err
+			}
+			mode, err := flags.GetString("mode")
+			if err != nil {
+				return err
+			}
+			ignoreCase, err := flags.GetBool("ignore-case")
+			if err != nil {
+				return err
+			}
+			normalizeUnicode, err := flags.GetBool("normalize-unicode")
+			if err != nil {
+				return err
+			}
+			res, err := Diff(DiffArgs{
+				SourcePath:       sourceFile,
+				TargetPaths:      targetFiles,
+				PathMap:          pathMap,
+				Mode:             mode,
+				IgnoreCase:       ignoreCase,
+				NormalizeUnicode: normalizeUnicode,
+			})
 			if err != nil {
 				return err
 			}
@@ -62,19 +252,397 @@ func main() {
 				return err
 			}
 			fmt.Println(string(bs))
-			return err
+			return nil
 		},
 	}
+	changesCmd := &cobra.Command{
+		Use:   "changes",
+		Short: "Compare two scans of the same directory and dump the changes (including moves and renames) as JSON",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			oldFile, err := flags.GetString("old")
+			if err != nil {
+				return err
+			}
+			newFile, err := flags.GetString("new")
+			if err != nil {
+				return err
+			}
+			pathMap, err := flags.GetStringArray("map")
+			if err != nil {
+				return err
+			}
+			includeUnchanged, err := flags.GetBool("unchanged")
+			if err != nil {
+				return err
+			}
+			modTimeTolerance, err := flags.GetString("mtime-tolerance")
+			if err != nil {
+				return err
+			}
+			res, err := Changes(ChangesArgs{
+				OldPath:          oldFile,
+				NewPath:          newFile,
+				PathMap:          pathMap,
+				IncludeUnchanged: includeUnchanged,
+				ModTimeTolerance: modTimeTolerance,
+			})
+			if err != nil {
+				return err
+			}
+			bs, err := json.MarshalIndent(res, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(bs))
+			return nil
+		},
+	}
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "Build the timeline of changes across a series of scans of the same directory and dump it as JSON",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			scanFiles, err := flags.GetStringArray("scan")
+			if err != nil {
+				return err
+			}
+			pathMap, err := flags.GetStringArray("map")
+			if err != nil {
+				return err
+			}
+			path, err := flags.GetString("path")
+			if err != nil {
+				return err
+			}
+			hash, err := flags.GetString("hash")
+			if err != nil {
+				return err
+			}
+			modTimeTolerance, err := flags.GetString("mtime-tolerance")
+			if err != nil {
+				return err
+			}
+			res, err := History(HistoryArgs{
+				ScanPaths:        scanFiles,
+				PathMap:          pathMap,
+				Path:             path,
+				Hash:             hash,
+				ModTimeTolerance: modTimeTolerance,
+			})
+			if err != nil {
+				return err
+			}
+			bs, err := json.MarshalIndent(res, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(bs))
+			return nil
+		},
+	}
+	dupesCmd := &cobra.Command{
+		Use:   "dupes",
+		Short: "Find groups of duplicate files (and directories) within one or more scans and dump them as JSON",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			scanFiles, err := flags.GetStringArray("scan")
+			if err != nil {
+				return err
+			}
+			pathMap, err := flags.GetStringArray("map")
+			if err != nil {
+				return err
+			}
+			rulesFile, err := flags.GetString("rules")
+			if err != nil {
+				return err
+			}
+			res, err := Dupes(DupesArgs{ScanPaths: scanFiles, PathMap: pathMap, RulesPath: rulesFile})
+			if err != nil {
+				return err
+			}
+			bs, err := json.MarshalIndent(res, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(bs))
+			return nil
+		},
+	}
+	planCmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Turn the output of 'dupes' or 'match' into a reviewable shell script (or JSON) for acting on the duplicates",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			dupesFile, err := flags.GetString("dupes")
+			if err != nil {
+				return err
+			}
+			matchFile, err := flags.GetString("match")
+			if err != nil {
+				return err
+			}
+			scanFiles, err := flags.GetStringArray("scan")
+			if err != nil {
+				return err
+			}
+			pathMap, err := flags.GetStringArray("map")
+			if err != nil {
+				return err
+			}
+			action, err := flags.GetString("action")
+			if err != nil {
+				return err
+			}
+			quarantine, err := flags.GetString("quarantine")
+			if err != nil {
+				return err
+			}
+			rulesFile, err := flags.GetString("rules")
+			if err != nil {
+				return err
+			}
+			keep, err := flags.GetString("keep")
+			if err != nil {
+				return err
+			}
+			prefer, err := flags.GetStringArray("prefer")
+			if err != nil {
+				return err
+			}
+			asJSON, err := flags.GetBool("json")
+			if err != nil {
+				return err
+			}
+			res, err := Plan(PlanArgs{
+				DupesPath:  dupesFile,
+				MatchPath:  matchFile,
+				ScanPaths:  scanFiles,
+				PathMap:    pathMap,
+				Action:     action,
+				Quarantine: quarantine,
+				RulesPath:  rulesFile,
+				Keep:       keep,
+				Prefer:     prefer,
+			})
+			if err != nil {
+				return err
+			}
+			if !asJSON {
+				return res.WriteScript(os.Stdout)
+			}
+			bs, err := json.MarshalIndent(res, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(bs))
+			return nil
+		},
+	}
+
+	quarantineCmd := &cobra.Command{
+		Use:   "quarantine",
+		Short: "Re-verify the files of a plan and move them into a quarantine directory (or undo a previous call)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			planFile, err := flags.GetString("plan")
+			if err != nil {
+				return err
+			}
+			undoFile, err := flags.GetString("undo")
+			if err != nil {
+				return err
+			}
+			dir, err := flags.GetString("dir")
+			if err != nil {
+				return err
+			}
+			manifestFile, err := flags.GetString("manifest")
+			if err != nil {
+				return err
+			}
+			execute, err := flags.GetBool("execute")
+			if err != nil {
+				return err
+			}
+			res, err := Quarantine(QuarantineArgs{
+				PlanPath:     planFile,
+				UndoPath:     undoFile,
+				Dir:          dir,
+				ManifestPath: manifestFile,
+				Execute:      execute,
+			})
+			if err != nil {
+				return err
+			}
+			bs, err := json.MarshalIndent(res, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(bs))
+			return nil
+		},
+	}
+
+	linkCmd := &cobra.Command{
+		Use:   "link",
+		Short: "Verify duplicates from 'dupes' byte for byte and replace those on the same file system with hardlinks or reflinks",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			dupesFile, err := flags.GetString("dupes")
+			if err != nil {
+				return err
+			}
+			scanFiles, err := flags.GetStringArray("scan")
+			if err != nil {
+				return err
+			}
+			pathMap, err := flags.GetStringArray("map")
+			if err != nil {
+				return err
+			}
+			rulesFile, err := flags.GetString("rules")
+			if err != nil {
+				return err
+			}
+			keep, err := flags.GetString("keep")
+			if err != nil {
+				return err
+			}
+			prefer, err := flags.GetStringArray("prefer")
+			if err != nil {
+				return err
+			}
+			reflink, err := flags.GetBool("reflink")
+			if err != nil {
+				return err
+			}
+			execute, err := flags.GetBool("execute")
+			if err != nil {
+				return err
+			}
+			res, err := Link(LinkArgs{
+				DupesPath: dupesFile,
+				ScanPaths: scanFiles,
+				PathMap:   pathMap,
+				RulesPath: rulesFile,
+				Keep:      keep,
+				Prefer:    prefer,
+				Reflink:   reflink,
+				Execute:   execute,
+			})
+			if err != nil {
+				return err
+			}
+			bs, err := json.MarshalIndent(res, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(bs))
+			return nil
+		},
+	}
+
 	hashFlags := hashCmd.Flags()
 	hashFlags.String("file", "", "file to hash")
 
 	scanFlags := scanCmd.Flags()
 	scanFlags.String("dir", "", "directory to scan")
 	scanFlags.String("skip", "", "comma-separated list of directories to skip")
-	scanFlags.String("cache", "", "file from a previous call to 'scan' to use as hash cache")
+	scanFlags.String("include", "", "comma-separated list of name patterns of the only files to include")
+	scanFlags.StringArray("cache", nil, "file from a previous call to 'scan' to use as hash cache (may be repeated)")
+	scanFlags.StringArray("map", nil, "path mapping '<from>=<to>' to apply to the roots of the caches (may be repeated)")
+	scanFlags.String("hash-db", "", "file of hash database to use as fallback hash cache (created or updated with the computed hashes)")
+	scanFlags.String("verify-against", "", "file from a previous call to 'scan' to verify the hashes against (reports files whose contents changed without their size or modification time changing)")
+	scanFlags.Int("hash-retries", 2, "number of times to rehash a file that changed while being hashed before recording it as unstable")
+	scanFlags.String("mtime-tolerance", "", "maximum difference between modification times of files and their cache entries (like '2s' for FAT file systems)")
+	scanFlags.String("min-size", "", "filter out files smaller than this size (in bytes, optionally with suffix K, M, G, or T)")
+	scanFlags.String("max-size", "", "filter out files larger than this size (in bytes, optionally with suffix K, M, G, or T)")
+	scanFlags.String("newer-than", "", "filter out files not modified after this time (duration before now, RFC 3339 timestamp, or date)")
+	scanFlags.String("older-than", "", "filter out files not modified before this time (duration before now, RFC 3339 timestamp, or date)")
+	scanFlags.Bool("follow-symlinks", false, "scan the targets of symlinks as if they were located at the symlink")
+	scanFlags.Bool("one-file-system", false, "skip directories on other file systems than the one containing the scanned directory")
+	scanFlags.Bool("fail-on-error", false, "exit with non-zero status if any errors were encountered (same as '--max-errors=0')")
+	scanFlags.Int("max-errors", -1, "exit with non-zero status if more than this number of errors were encountered (negative for no limit)")
+
+	matchFlags := matchCmd.Flags()
+	matchFlags.String("source", "", "file from a call to 'scan' of the source directory")
+	matchFlags.StringSlice("targets", nil, "comma-separated list of files from calls to 'scan' of the target directories (may be repeated)")
+	matchFlags.StringArray("map", nil, "path mapping '<from>=<to>' to apply to the roots of the scans (may be repeated)")
+	matchFlags.Float64("min-score", 1, "minimum similarity score (between 0 and 1) of the target directories to report (1 for only identical contents)")
+	matchFlags.Int("max-candidates", 5, "maximum number of target directories to report for each source directory (0 for no limit)")
+	matchFlags.String("mode", "anywhere", modeUsage)
+	matchFlags.Bool("ignore-case", false, "match names regardless of case")
+	matchFlags.Bool("normalize-unicode", false, "match names regardless of Unicode normalization form (like NFC and NFD)")
+	matchFlags.String("rules", "", "file with rules for choosing the directory to keep among identical ones (annotated in the output)")
+
+	diffFlags := diffCmd.Flags()
+	diffFlags.String("source", "", "file from a call to 'scan' of the source directory")
+	diffFlags.StringSlice("targets", nil, "comma-separated list of files from calls to 'scan' of the target directories (may be repeated)")
+	diffFlags.StringArray("map", nil, "path mapping '<from>=<to>' to apply to the roots of the scans (may be repeated)")
+	diffFlags.String("mode", "anywhere", modeUsage)
+	diffFlags.Bool("ignore-case", false, "match names regardless of case")
+	diffFlags.Bool("normalize-unicode", false, "match names regardless of Unicode normalization form (like NFC and NFD)")
+
+	changesFlags := changesCmd.Flags()
+	changesFlags.String("old", "", "file from a call to 'scan' of the old state of the directory")
+	changesFlags.String("new", "", "file from a call to 'scan' of the new state of the directory")
+	changesFlags.StringArray("map", nil, "path mapping '<from>=<to>' to apply to the roots of the scans (may be repeated)")
+	changesFlags.Bool("unchanged", false, "also list unchanged files (they're always counted)")
+	changesFlags.String("mtime-tolerance", "", "maximum difference between modification times of unhashed files for them to be considered unchanged (like '2s' for FAT file systems)")
+
+	historyFlags := historyCmd.Flags()
+	historyFlags.StringArray("scan", nil, "file from a call to 'scan' of the directory (repeated for each scan in chronological order)")
+	historyFlags.StringArray("map", nil, "path mapping '<from>=<to>' to apply to the roots of the scans (may be repeated)")
+	historyFlags.String("path", "", "only include the events of this file or directory (relative to the root or absolute)")
+	historyFlags.String("hash", "", "only include the events of files with this hash (and directories containing them)")
+	historyFlags.String("mtime-tolerance", "", "maximum difference between modification times of unhashed files for them to be considered unchanged (like '2s' for FAT file systems)")
+
+	dupesFlags := dupesCmd.Flags()
+	dupesFlags.StringArray("scan", nil, "file from a call to 'scan' to search for duplicates in (may be repeated)")
+	dupesFlags.StringArray("map", nil, "path mapping '<from>=<to>' to apply to the roots of the scans (may be repeated)")
+	dupesFlags.String("rules", "", "file with rules for choosing the copy to keep of each group (annotated in the output)")
+
+	planFlags := planCmd.Flags()
+	planFlags.String("dupes", "", "file from a call to 'dupes' with the duplicates to act on")
+	planFlags.String("match", "", "file from a call to 'match' with the identical directories to act on")
+	planFlags.StringArray("scan", nil, "file from a call to 'scan' that the duplicates were found in (may be repeated)")
+	planFlags.StringArray("map", nil, "path mapping '<from>=<to>' to apply to the roots of the scans (may be repeated)")
+	planFlags.String("action", "remove", "what to do with the copies that aren't kept: 'remove', 'hardlink', 'symlink', or 'quarantine'")
+	planFlags.String("quarantine", "", "directory to move copies into with action 'quarantine' (preserving their paths)")
+	planFlags.String("rules", "", "file with rules for choosing the copy to keep (cannot be combined with '--keep' and '--prefer')")
+	planFlags.String("keep", "", "rule for choosing the copy to keep among equally preferred ones: 'oldest' (default), 'newest', 'shortest', or 'complete'")
+	planFlags.StringArray("prefer", nil, "directory whose copies are kept over others (may be repeated in order of preference)")
+	planFlags.Bool("json", false, "output the plan as JSON instead of a shell script")
+
+	quarantineFlags := quarantineCmd.Flags()
+	quarantineFlags.String("plan", "", "file from a call to 'plan' with '--json' with the files to move into quarantine")
+	quarantineFlags.String("undo", "", "manifest file from a previous call whose files to move back to where they came from")
+	quarantineFlags.String("dir", "", "quarantine directory to move files into (overrides the one of the plan)")
+	quarantineFlags.String("manifest", "", "new file to write the manifest of moved files to (required with '--execute' and '--plan')")
+	quarantineFlags.Bool("execute", false, "actually move the files (otherwise they're only verified)")
+
+	linkFlags := linkCmd.Flags()
+	linkFlags.String("dupes", "", "file from a call to 'dupes' with the duplicates to link")
+	linkFlags.StringArray("scan", nil, "file from a call to 'scan' that the duplicates were found in (may be repeated)")
+	linkFlags.StringArray("map", nil, "path mapping '<from>=<to>' to apply to the roots of the scans (may be repeated)")
+	linkFlags.String("rules", "", "file with rules for choosing the copy to keep (cannot be combined with '--keep' and '--prefer')")
+	linkFlags.String("keep", "", "rule for choosing the copy to keep among equally preferred ones: 'oldest' (default), 'newest', 'shortest', or 'complete'")
+	linkFlags.StringArray("prefer", nil, "directory whose copies are kept over others (may be repeated in order of preference)")
+	linkFlags.Bool("reflink", false, "replace copies with reflinks instead of hardlinks (Linux only; requires a file system like btrfs or xfs)")
+	linkFlags.Bool("execute", false, "actually replace the files (otherwise they're only verified)")
 
 	rootCmd.AddCommand(hashCmd)
 	rootCmd.AddCommand(scanCmd)
+	rootCmd.AddCommand(matchCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(changesCmd)
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(dupesCmd)
+	rootCmd.AddCommand(planCmd)
+	rootCmd.AddCommand(quarantineCmd)
+	rootCmd.AddCommand(linkCmd)
 	if err := rootCmd.Execute(); err != nil {
 		// Print error with stack trace.
 		log.Fatalf("error: %+v\n", err)
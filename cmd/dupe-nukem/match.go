@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log"
+
+	"github.com/pkg/errors"
+
+	"github.com/bisgardo/dupe-nukem/match"
+	"github.com/bisgardo/dupe-nukem/plan"
+	"github.com/bisgardo/dupe-nukem/scan"
+)
+
+// modeUsage is the usage text of the "mode" flag of the "match" and "diff" commands.
+const modeUsage = "how the locations of files with the same contents must correspond for them to match: " +
+	"'exact' (same relative path), 'same-dir' (same relative directory), 'same-name' (same name), or 'anywhere'"
+
+// MatchArgs holds the arguments of the "match" command as passed from the command line.
+type MatchArgs struct {
+	// Path of the result file of the scan of the source directory.
+	SourcePath string
+	// Paths of the result files of the scans of the target directories.
+	TargetPaths []string
+	// Path mapping expressions ('<from>=<to>') to apply to the roots of the scans.
+	PathMap []string
+	// Minimum similarity score of the candidates to report.
+	MinScore float64
+	// Maximum number of candidates to report for each source directory (0 for no limit).
+	MaxCandidates int
+	// Mode of matching files (see match.Mode; empty for the default).
+	Mode string
+	// Whether to match names regardless of case.
+	IgnoreCase bool
+	// Whether to match names regardless of Unicode normalization form.
+	NormalizeUnicode bool
+	// Path of a file with rules for choosing the directory to keep among identical ones (see plan.ParseRules).
+	// If provided, the source directories with identical candidates are annotated with the chosen directories.
+	RulesPath string
+}
+
+// Match loads the source and target scan files and matches them using match.Match.
+func Match(args MatchArgs) (*match.Result, error) {
+	rules, err := loadRules(args.RulesPath, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	source, targets, err := loadSourceAndTargets(args.SourcePath, args.TargetPaths, args.PathMap)
+	if err != nil {
+		return nil, err
+	}
+	res, err := match.Match(source, targets, match.Options{
+		MinScore:         args.MinScore,
+		MaxCandidates:    args.MaxCandidates,
+		Mode:             match.Mode(args.Mode),
+		IgnoreCase:       args.IgnoreCase,
+		NormalizeUnicode: args.NormalizeUnicode,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("found candidates for %d directories of %q\n", len(res.Matches), res.Source)
+	if args.RulesPath != "" {
+		if err := plan.AnnotateMatch(res, append([]*scan.Dir{source}, targets...), rules); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// loadSourceAndTargets loads the source and target scan files of the "match" and "diff" commands
+// and applies the provided path mapping expressions to their roots.
+func loadSourceAndTargets(sourcePath string, targetPaths []string, pathMapExprs []string) (*scan.Dir, []*scan.Dir, error) {
+	if sourcePath == "" {
+		return nil, nil, errors.Errorf("no source scan file provided")
+	}
+	if len(targetPaths) == 0 {
+		return nil, nil, errors.Errorf("no target scan files provided")
+	}
+	pathMap, err := parsePathMap(pathMapExprs)
+	if err != nil {
+		return nil, nil, err
+	}
+	source, err := loadMappedScanRoot(sourcePath, "source scan", pathMap)
+	if err != nil {
+		return nil, nil, err
+	}
+	targets := make([]*scan.Dir, len(targetPaths))
+	for i, p := range targetPaths {
+		t, err := loadMappedScanRoot(p, "target scan", pathMap)
+		if err != nil {
+			return nil, nil, err
+		}
+		targets[i] = t
+	}
+	return source, targets, nil
+}
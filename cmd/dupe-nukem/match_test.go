@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/match"
+	"github.com/bisgardo/dupe-nukem/scan"
+	. "github.com/bisgardo/dupe-nukem/testutil"
+)
+
+func Test__Match_finds_similar_directories_of_targets(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	sourcePath := tempScanFile(t, &scan.Dir{Name: p("/mnt/src"), Files: []*scan.File{{Name: "a", Size: 1, Hash: 1}, {Name: "b", Size: 3, Hash: 2}}})
+	targetPaths := []string{
+		tempScanFile(t, &scan.Dir{Name: p("/x"), Files: []*scan.File{{Name: "a", Size: 1, Hash: 1}}}),
+		tempScanFile(t, &scan.Dir{Name: p("/y"), Files: []*scan.File{{Name: "b", Size: 3, Hash: 2}}}),
+	}
+	logs := CaptureLogs(t)
+
+	res, err := Match(MatchArgs{
+		SourcePath:  sourcePath,
+		TargetPaths: targetPaths,
+		PathMap:     []string{p("/mnt/src") + "=" + p("/src")},
+		MinScore:    0.5,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &match.Result{
+		Source:  p("/src"),
+		Targets: []string{p("/x"), p("/y")},
+		Matches: []*match.DirMatch{{Source: p("/src"), Candidates: []*match.Candidate{
+			{Target: p("/y"), Score: 0.75, SharedBytes: 3, SourceBytes: 4, TargetBytes: 3, Differences: []*match.Difference{{Kind: match.Missing, Path: "a"}}},
+		}}},
+	}, res)
+	assert.Contains(t, logs.String(), fmt.Sprintf("mapping root %q of source scan to %q\n", p("/mnt/src"), p("/src")))
+	assert.Contains(t, logs.String(), fmt.Sprintf("found candidates for 1 directories of %q\n", p("/src")))
+}
+
+func Test__Match_annotates_kept_directories_with_rules(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	sourcePath := tempScanFile(t, &scan.Dir{Name: p("/src"), Files: []*scan.File{{Name: "a", Size: 1, Hash: 1}}})
+	targetPath := tempScanFile(t, &scan.Dir{Name: p("/archive/x"), Files: []*scan.File{{Name: "a", Size: 1, Hash: 1}}})
+	rulesPath := TempStringFile(t, "prefer "+p("/archive"))
+
+	res, err := Match(MatchArgs{SourcePath: sourcePath, TargetPaths: []string{targetPath}, MinScore: 1, RulesPath: rulesPath})
+	require.NoError(t, err)
+	require.Len(t, res.Matches, 1)
+	assert.Equal(t, p("/archive/x"), res.Matches[0].Keep)
+	assert.Equal(t, "prefer "+p("/archive"), res.Matches[0].KeepRule)
+}
+
+func Test__Match_fails(t *testing.T) {
+	scanPath := tempScanFile(t, &scan.Dir{Name: "x"})
+	tests := []struct {
+		name    string
+		args    MatchArgs
+		wantErr string
+	}{
+		{name: "no source", args: MatchArgs{TargetPaths: []string{scanPath}}, wantErr: "no source scan file provided"},
+		{name: "no targets", args: MatchArgs{SourcePath: scanPath}, wantErr: "no target scan files provided"},
+		{
+			name:    "missing target",
+			args:    MatchArgs{SourcePath: scanPath, TargetPaths: []string{"missing"}},
+			wantErr: `cannot load target scan file "missing": cannot open file: not found`,
+		},
+		{
+			name:    "invalid min score",
+			args:    MatchArgs{SourcePath: scanPath, TargetPaths: []string{scanPath}, MinScore: 2},
+			wantErr: "minimum score 2 is not between 0 and 1",
+		},
+		{
+			name:    "invalid mode",
+			args:    MatchArgs{SourcePath: scanPath, TargetPaths: []string{scanPath}, Mode: "x"},
+			wantErr: `invalid mode "x" (valid modes are ["exact" "same-dir" "same-name" "anywhere"])`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Match(test.args)
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/bisgardo/dupe-nukem/dupes"
+	"github.com/bisgardo/dupe-nukem/match"
+	"github.com/bisgardo/dupe-nukem/plan"
+	"github.com/bisgardo/dupe-nukem/util"
+)
+
+// PlanArgs holds the arguments of the "plan" command as passed from the command line.
+type PlanArgs struct {
+	// Path of the output file of a call to "dupes" (mutually exclusive with MatchPath).
+	DupesPath string
+	// Path of the output file of a call to "match" (mutually exclusive with DupesPath).
+	MatchPath string
+	// Paths of the result files of the scans that the duplicates were found in.
+	ScanPaths []string
+	// Path mapping expressions ('<from>=<to>') to apply to the roots of the scans.
+	PathMap []string
+	// Action to perform on the copies that aren't kept (see plan.Action).
+	Action string
+	// Directory to move copies into for the "quarantine" action.
+	Quarantine string
+	// Path of a file with rules for choosing the copy to keep (see plan.ParseRules).
+	RulesPath string
+	// Rule (without directory) for choosing among equally preferred copies (see plan.ParseRule).
+	// Shorthand for a rules file that may not be combined with RulesPath.
+	Keep string
+	// Directories whose copies are preferred to keep (most preferred first).
+	// Shorthand for "prefer" rules that may not be combined with RulesPath.
+	Prefer []string
+}
+
+// Plan loads the result of "dupes" or "match" along with the scan files that it was computed from
+// and constructs the plan of acting on the duplicates using plan.Build.
+func Plan(args PlanArgs) (*plan.Plan, error) {
+	if (args.DupesPath == "") == (args.MatchPath == "") {
+		return nil, errors.Errorf("exactly one of a dupes and a match file must be provided")
+	}
+	if len(args.ScanPaths) == 0 {
+		return nil, errors.Errorf("no scan files provided")
+	}
+	rules, err := loadRules(args.RulesPath, args.Keep, args.Prefer)
+	if err != nil {
+		return nil, err
+	}
+	pathMap, err := parsePathMap(args.PathMap)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := loadMappedScanRoots(args.ScanPaths, pathMap)
+	if err != nil {
+		return nil, err
+	}
+	var groups []*plan.Group
+	if args.DupesPath != "" {
+		var res dupes.Result
+		if err := loadJSONFile(args.DupesPath, &res); err != nil {
+			return nil, errors.Wrapf(err, "cannot load dupes file %q", args.DupesPath)
+		}
+		groups, err = plan.FromDupes(&res, roots)
+	} else {
+		var res match.Result
+		if err := loadJSONFile(args.MatchPath, &res); err != nil {
+			return nil, errors.Wrapf(err, "cannot load match file %q", args.MatchPath)
+		}
+		groups, err = plan.FromMatch(&res, roots)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res, err := plan.Build(groups, plan.Options{
+		Action:     plan.Action(args.Action),
+		Quarantine: args.Quarantine,
+		Rules:      rules,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("planned to %s %d file(s) with %d byte(s)\n", res.Action, len(res.Steps), res.Bytes)
+	return res, nil
+}
+
+// loadRules loads the rules file at the provided path (if any)
+// or constructs the rules preferring the provided directories and then using the provided rule (if any).
+// If no rules are provided, nil is returned such that the default rules are used.
+func loadRules(path string, keep string, prefer []string) (plan.Rules, error) {
+	if path != "" {
+		if keep != "" || len(prefer) != 0 {
+			return nil, errors.Errorf("rules file cannot be combined with keep rule or preferred directories")
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrapf(util.CleanIOError(err), "cannot open rules file %q", path)
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				log.Printf("error: closing rules file %q failed: %v\n", path, err) // cannot test
+			}
+		}()
+		res, err := plan.ParseRules(f)
+		return res, errors.Wrapf(err, "cannot parse rules file %q", path)
+	}
+	var res plan.Rules
+	for _, d := range prefer {
+		r, err := plan.ParseRule(string(plan.RulePrefer) + " " + d)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid preferred directory %q", d)
+		}
+		res = append(res, r)
+	}
+	if keep != "" {
+		r, err := plan.ParseRule(keep)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid keep rule %q", keep)
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/dupes"
+	"github.com/bisgardo/dupe-nukem/match"
+	"github.com/bisgardo/dupe-nukem/plan"
+	"github.com/bisgardo/dupe-nukem/scan"
+	. "github.com/bisgardo/dupe-nukem/testutil"
+)
+
+func tempJSONFile(t *testing.T, v interface{}) string {
+	bs, err := json.Marshal(v)
+	require.NoError(t, err)
+	return TempStringFile(t, string(bs))
+}
+
+func Test__Plan_from_dupes(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	scanPath := tempScanFile(t, &scan.Dir{Name: p("/mnt/x"), Files: []*scan.File{
+		{Name: "a", Size: 1, Hash: 1, ModTime: 2},
+		{Name: "b", Size: 1, Hash: 1, ModTime: 1},
+	}})
+	dupesPath := tempJSONFile(t, &dupes.Result{
+		Roots:  []string{p("/x")},
+		Groups: []*dupes.Group{{Size: 1, Hash: 1, Paths: []string{p("/x/a"), p("/x/b")}, Reclaimable: 1}},
+	})
+	logs := CaptureLogs(t)
+
+	res, err := Plan(PlanArgs{
+		DupesPath: dupesPath,
+		ScanPaths: []string{scanPath},
+		PathMap:   []string{p("/mnt/x") + "=" + p("/x")},
+		Action:    "remove",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &plan.Plan{
+		Action: plan.Remove,
+		Steps:  []*plan.Step{{Path: p("/x/a"), Keep: p("/x/b"), KeepRule: "oldest", Size: 1, Hash: 1}},
+		Bytes:  1,
+	}, res)
+	assert.Contains(t, logs.String(), "planned to remove 1 file(s) with 1 byte(s)\n")
+}
+
+func Test__Plan_from_match(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	scanPaths := []string{
+		tempScanFile(t, &scan.Dir{Name: p("/x"), Files: []*scan.File{{Name: "a", Size: 1, Hash: 1}}}),
+		tempScanFile(t, &scan.Dir{Name: p("/archive/y"), Files: []*scan.File{{Name: "b", Size: 1, Hash: 1}}}),
+	}
+	matchPath := tempJSONFile(t, &match.Result{
+		Source:  p("/x"),
+		Targets: []string{p("/archive/y")},
+		Matches: []*match.DirMatch{{Source: p("/x"), Candidates: []*match.Candidate{{Target: p("/archive/y"), Score: 1}}}},
+	})
+
+	res, err := Plan(PlanArgs{
+		MatchPath:  matchPath,
+		ScanPaths:  scanPaths,
+		Action:     "quarantine",
+		Quarantine: p("/q"),
+		Keep:       "shortest",
+		Prefer:     []string{p("/archive")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []*plan.Step{{Path: p("/x/a"), Keep: p("/archive/y/b"), KeepRule: "prefer " + p("/archive"), Target: p("/q/x/a"), Size: 1, Hash: 1}}, res.Steps)
+}
+
+func Test__Plan_uses_rules_file(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	scanPath := tempScanFile(t, &scan.Dir{Name: p("/x"), Dirs: []*scan.Dir{
+		{Name: "ro", Files: []*scan.File{{Name: "a", Size: 1, Hash: 1, ModTime: 1}}},
+		{Name: "rw", Files: []*scan.File{{Name: "a", Size: 1, Hash: 1, ModTime: 2}, {Name: "b", Size: 1, Hash: 1, ModTime: 3}}},
+	}})
+	dupesPath := tempJSONFile(t, &dupes.Result{
+		Roots:  []string{p("/x")},
+		Groups: []*dupes.Group{{Size: 1, Hash: 1, Paths: []string{p("/x/ro/a"), p("/x/rw/a"), p("/x/rw/b")}}},
+	})
+	rulesPath := TempStringFile(t, Lines("protect "+p("/x/ro"), "newest"))
+
+	res, err := Plan(PlanArgs{DupesPath: dupesPath, ScanPaths: []string{scanPath}, Action: "hardlink", RulesPath: rulesPath})
+	require.NoError(t, err)
+	assert.Equal(t, []*plan.Step{{Path: p("/x/rw/a"), Keep: p("/x/rw/b"), KeepRule: "newest", Size: 1, Hash: 1}}, res.Steps)
+}
+
+func Test__Plan_fails(t *testing.T) {
+	scanPath := tempScanFile(t, &scan.Dir{Name: "x"})
+	dupesPath := tempJSONFile(t, &dupes.Result{})
+	invalidPath := TempStringFile(t, "{")
+	tests := []struct {
+		name    string
+		args    PlanArgs
+		wantErr string
+	}{
+		{name: "no input", args: PlanArgs{ScanPaths: []string{scanPath}}, wantErr: "exactly one of a dupes and a match file must be provided"},
+		{
+			name:    "both inputs",
+			args:    PlanArgs{DupesPath: dupesPath, MatchPath: dupesPath, ScanPaths: []string{scanPath}},
+			wantErr: "exactly one of a dupes and a match file must be provided",
+		},
+		{name: "no scans", args: PlanArgs{DupesPath: dupesPath}, wantErr: "no scan files provided"},
+		{
+			name:    "missing dupes file",
+			args:    PlanArgs{DupesPath: "missing", ScanPaths: []string{scanPath}},
+			wantErr: `cannot load dupes file "missing": cannot open file: not found`,
+		},
+		{
+			name:    "invalid match file",
+			args:    PlanArgs{MatchPath: invalidPath, ScanPaths: []string{scanPath}},
+			wantErr: fmt.Sprintf("cannot load match file %q: invalid JSON: unexpected EOF", invalidPath),
+		},
+		{
+			name:    "rules file with keep rule",
+			args:    PlanArgs{DupesPath: dupesPath, ScanPaths: []string{scanPath}, RulesPath: "rules", Keep: "oldest"},
+			wantErr: "rules file cannot be combined with keep rule or preferred directories",
+		},
+		{
+			name:    "invalid keep rule",
+			args:    PlanArgs{DupesPath: dupesPath, ScanPaths: []string{scanPath}, Keep: "prefer"},
+			wantErr: `invalid keep rule "prefer": rule "prefer" requires a directory`,
+		},
+		{
+			name:    "invalid action",
+			args:    PlanArgs{DupesPath: dupesPath, ScanPaths: []string{scanPath}, Action: "x"},
+			wantErr: `invalid action "x" (valid actions are ["remove" "hardlink" "symlink" "quarantine"])`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Plan(test.args)
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
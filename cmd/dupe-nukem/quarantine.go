@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/bisgardo/dupe-nukem/plan"
+	"github.com/bisgardo/dupe-nukem/quarantine"
+	"github.com/bisgardo/dupe-nukem/util"
+)
+
+// QuarantineArgs holds the arguments of the "quarantine" command as passed from the command line.
+type QuarantineArgs struct {
+	// Path of the output file of a call to "plan" with '--json' (mutually exclusive with UndoPath).
+	PlanPath string
+	// Path of a manifest file written by a previous call to undo (mutually exclusive with PlanPath).
+	UndoPath string
+	// Quarantine directory to move files into (overrides the targets of the plan).
+	Dir string
+	// Path of the file to write the manifest of moved files to (required when executing a plan).
+	ManifestPath string
+	// Execute enables actually moving files (otherwise they're only verified).
+	Execute bool
+}
+
+// Quarantine loads a plan and moves its files into quarantine using quarantine.Run
+// or loads the manifest of a previous call and moves the files back using quarantine.Restore.
+// Nothing is moved unless Execute is set.
+// When executing a plan, the manifest is written to a new file at ManifestPath,
+// with each entry being appended (and synced to disk) as soon as its file has been moved.
+func Quarantine(args QuarantineArgs) (*quarantine.Manifest, error) {
+	if (args.PlanPath == "") == (args.UndoPath == "") {
+		return nil, errors.Errorf("exactly one of a plan and a manifest file to undo must be provided")
+	}
+	if args.UndoPath != "" {
+		if args.Dir != "" || args.ManifestPath != "" {
+			return nil, errors.Errorf("quarantine directory and manifest file cannot be used when undoing")
+		}
+		m, err := loadManifest(args.UndoPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot load manifest file %q", args.UndoPath)
+		}
+		res := quarantine.Restore(m, !args.Execute)
+		logManifest(res, "restored", "restore")
+		return res, nil
+	}
+	var p plan.Plan
+	if err := loadJSONFile(args.PlanPath, &p); err != nil {
+		return nil, errors.Wrapf(err, "cannot load plan file %q", args.PlanPath)
+	}
+	opts := quarantine.Options{Dir: args.Dir, DryRun: !args.Execute}
+	if args.Execute {
+		if args.ManifestPath == "" {
+			return nil, errors.Errorf("no manifest file provided")
+		}
+		// Create the file up front to ensure that the manifest can be written before moving anything.
+		f, err := os.OpenFile(args.ManifestPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			if os.IsExist(err) {
+				return nil, errors.Errorf("manifest file %q already exists", args.ManifestPath)
+			}
+			return nil, errors.Wrapf(util.CleanIOError(err), "cannot create manifest file %q", args.ManifestPath)
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				log.Printf("error: closing manifest file %q failed: %v\n", args.ManifestPath, err) // cannot test
+			}
+		}()
+		opts.Record = func(e *quarantine.Entry) error {
+			if err := quarantine.WriteEntry(f, e); err != nil {
+				return errors.Wrapf(err, "cannot write manifest file %q", args.ManifestPath) // cannot test
+			}
+			return errors.Wrapf(f.Sync(), "cannot sync manifest file %q", args.ManifestPath)
+		}
+	}
+	res, err := quarantine.Run(&p, opts)
+	if err != nil {
+		return nil, err
+	}
+	logManifest(res, "quarantined", "quarantine")
+	return res, nil
+}
+
+// loadManifest reads the manifest file written when executing a plan.
+func loadManifest(path string) (*quarantine.Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(util.CleanIOError(err), "cannot open file")
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Printf("error: closing file %q failed: %v\n", path, err) // cannot test
+		}
+	}()
+	return quarantine.ReadManifest(f)
+}
+
+func logManifest(m *quarantine.Manifest, done, verb string) {
+	for _, s := range m.Skipped {
+		log.Printf("skipping %q: %s\n", s.Path, s.Reason)
+	}
+	if m.DryRun {
+		log.Printf("dry run: would %s %d file(s) with %d byte(s) (skipped %d)\n", verb, len(m.Entries), m.Bytes, len(m.Skipped))
+		return
+	}
+	log.Printf("%s %d file(s) with %d byte(s) (skipped %d)\n", done, len(m.Entries), m.Bytes, len(m.Skipped))
+}
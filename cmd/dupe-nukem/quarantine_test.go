@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/hash"
+	"github.com/bisgardo/dupe-nukem/plan"
+	"github.com/bisgardo/dupe-nukem/quarantine"
+	. "github.com/bisgardo/dupe-nukem/testutil"
+)
+
+// tempQuarantinePlan creates the duplicate files "x/a" and "y/a" in the provided directory
+// and returns the plan file of moving the former into quarantine directory "q" along with its step.
+func tempQuarantinePlan(t *testing.T, dir string) (string, *plan.Step) {
+	path, keep := filepath.Join(dir, "x", "a"), filepath.Join(dir, "y", "a")
+	for _, p := range []string{path, keep} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte("abc"), 0644))
+	}
+	q := filepath.Join(dir, "q")
+	s := &plan.Step{Path: path, Keep: keep, KeepRule: "path", Target: plan.QuarantinePath(q, path), Size: 3, Hash: hash.Bytes([]byte("abc"))}
+	return tempJSONFile(t, &plan.Plan{Action: plan.Quarantine, Quarantine: q, Steps: []*plan.Step{s}, Bytes: 3}), s
+}
+
+func Test__Quarantine_dry_run_by_default(t *testing.T) {
+	planPath, s := tempQuarantinePlan(t, t.TempDir())
+	logs := CaptureLogs(t)
+
+	res, err := Quarantine(QuarantineArgs{PlanPath: planPath})
+	require.NoError(t, err)
+	assert.True(t, res.DryRun)
+	assert.Len(t, res.Entries, 1)
+	assert.FileExists(t, s.Path)
+	assert.NoFileExists(t, s.Target)
+	assert.Contains(t, logs.String(), "dry run: would quarantine 1 file(s) with 3 byte(s) (skipped 0)\n")
+}
+
+func Test__Quarantine_execute_writes_manifest_that_can_be_undone(t *testing.T) {
+	dir := t.TempDir()
+	planPath, s := tempQuarantinePlan(t, dir)
+	manifestPath := filepath.Join(dir, "manifest.json")
+	logs := CaptureLogs(t)
+
+	res, err := Quarantine(QuarantineArgs{PlanPath: planPath, ManifestPath: manifestPath, Execute: true})
+	require.NoError(t, err)
+	assert.Equal(t, &quarantine.Manifest{
+		Entries: []*quarantine.Entry{{Path: s.Path, Target: s.Target, Keep: s.Keep, Size: 3, Hash: s.Hash}},
+		Bytes:   3,
+	}, res)
+	assert.NoFileExists(t, s.Path)
+	assert.FileExists(t, s.Target)
+	assert.Contains(t, logs.String(), "quarantined 1 file(s) with 3 byte(s) (skipped 0)\n")
+
+	m, err := loadManifest(manifestPath)
+	require.NoError(t, err)
+	assert.Equal(t, res, m)
+
+	// Manifest isn't overwritten.
+	_, err = Quarantine(QuarantineArgs{PlanPath: planPath, ManifestPath: manifestPath, Execute: true})
+	assert.EqualError(t, err, fmt.Sprintf("manifest file %q already exists", manifestPath))
+
+	res, err = Quarantine(QuarantineArgs{UndoPath: manifestPath, Execute: true})
+	require.NoError(t, err)
+	assert.Len(t, res.Entries, 1)
+	assert.FileExists(t, s.Path)
+	assert.NoFileExists(t, s.Target)
+	assert.Contains(t, logs.String(), "restored 1 file(s) with 3 byte(s) (skipped 0)\n")
+}
+
+func Test__Quarantine_logs_skipped_files(t *testing.T) {
+	planPath, s := tempQuarantinePlan(t, t.TempDir())
+	require.NoError(t, os.Remove(s.Keep))
+	logs := CaptureLogs(t)
+
+	res, err := Quarantine(QuarantineArgs{PlanPath: planPath})
+	require.NoError(t, err)
+	assert.Empty(t, res.Entries)
+	assert.Contains(t, logs.String(), fmt.Sprintf("skipping %q: kept copy %q: cannot stat file: not found\n", s.Path, s.Keep))
+	assert.Contains(t, logs.String(), "dry run: would quarantine 0 file(s) with 0 byte(s) (skipped 1)\n")
+}
+
+func Test__Quarantine_fails(t *testing.T) {
+	planPath := tempJSONFile(t, &plan.Plan{Action: plan.Remove})
+	invalidPath := TempStringFile(t, `{"path": "x"}`)
+	tests := []struct {
+		name    string
+		args    QuarantineArgs
+		wantErr string
+	}{
+		{name: "no input", args: QuarantineArgs{}, wantErr: "exactly one of a plan and a manifest file to undo must be provided"},
+		{
+			name:    "both inputs",
+			args:    QuarantineArgs{PlanPath: planPath, UndoPath: invalidPath},
+			wantErr: "exactly one of a plan and a manifest file to undo must be provided",
+		},
+		{
+			name:    "undo with manifest file",
+			args:    QuarantineArgs{UndoPath: invalidPath, ManifestPath: "x"},
+			wantErr: "quarantine directory and manifest file cannot be used when undoing",
+		},
+		{
+			name:    "missing plan file",
+			args:    QuarantineArgs{PlanPath: "missing"},
+			wantErr: `cannot load plan file "missing": cannot open file: not found`,
+		},
+		{
+			name:    "missing manifest file",
+			args:    QuarantineArgs{UndoPath: "missing"},
+			wantErr: `cannot load manifest file "missing": cannot open file: not found`,
+		},
+		{
+			name:    "invalid manifest file",
+			args:    QuarantineArgs{UndoPath: invalidPath},
+			wantErr: fmt.Sprintf("cannot load manifest file %q: invalid entry 1: missing path or target", invalidPath),
+		},
+		{name: "execute without manifest file", args: QuarantineArgs{PlanPath: planPath, Execute: true}, wantErr: "no manifest file provided"},
+		{
+			name:    "no quarantine directory",
+			args:    QuarantineArgs{PlanPath: planPath},
+			wantErr: `no quarantine directory provided for plan with action "remove"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Quarantine(test.args)
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
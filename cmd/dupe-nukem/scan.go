@@ -7,11 +7,13 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/bisgardo/dupe-nukem/hashdb"
 	"github.com/bisgardo/dupe-nukem/scan"
 	"github.com/bisgardo/dupe-nukem/util"
 )
@@ -29,16 +31,105 @@ func init() {
 	invalidSkipNameChars[filepath.Separator] = struct{}{}
 }
 
-// Scan parses the skip expression and cache path passed from the command line
-// and then runs scan.Run with the resulting values.
-func Scan(dir, skipExpr, cachePath string) (*scan.Result, error) {
-	shouldSkip, err := loadShouldSkip(skipExpr)
+// sizeSuffixes maps the (lower case) suffixes accepted in size expressions to their multipliers.
+var sizeSuffixes = map[string]int64{
+	"":  1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+}
+
+// ScanArgs holds the arguments of the "scan" command (other than the directory) as passed from the command line.
+type ScanArgs struct {
+	// Skip expression (comma-separated list of names or '@' followed by the path of a file containing them).
+	SkipExpr string
+	// Include expression (comma-separated list of name patterns or '@' followed by the path of a file containing them).
+	IncludeExpr string
+	// Paths of the result files of previous scans to use as hash caches.
+	CachePaths []string
+	// Path mapping expressions ('<from>=<to>') to apply to the roots of the caches.
+	PathMap []string
+	// Path of the hash database file to use as fallback hash cache and update with the computed hashes.
+	HashDBPath string
+	// Path of the result file of a previous scan to verify the computed hashes against.
+	// This cannot be combined with any caches.
+	VerifyAgainstPath string
+	// Number of times to rehash a file that changed while being hashed.
+	HashRetries int
+	// Maximum difference between the modification times of files and their entries in the caches (duration expression).
+	ModTimeTolerance string
+	// Minimum size of files to include (size expression).
+	MinSize string
+	// Maximum size of files to include (size expression).
+	MaxSize string
+	// Only include files modified after this time (time expression).
+	NewerThan string
+	// Only include files modified before this time (time expression).
+	OlderThan string
+	// Whether to scan the targets of symlinks (in addition to recording the symlinks themselves).
+	FollowSymlinks bool
+	// Whether to skip directories on other file systems than the root.
+	OneFileSystem bool
+}
+
+// Scan parses the skip expression, filters, include expression, and cache path passed from the command line
+// and then runs scan.RunWithOptions with the resulting values.
+func Scan(dir string, args ScanArgs) (*scan.Result, error) {
+	shouldSkip, err := loadShouldSkip(args.SkipExpr)
 	if err != nil {
-		return nil, errors.Wrapf(err, "cannot process skip dirs expression %q", skipExpr)
+		return nil, errors.Wrapf(err, "cannot process skip dirs expression %q", args.SkipExpr)
 	}
-	cache, err := loadScanCache(cachePath)
+	fileFilter, err := loadFileFilter(args, time.Now())
 	if err != nil {
-		return nil, errors.Wrapf(err, "cannot load scan cache file %q", cachePath)
+		return nil, err
+	}
+	shouldInclude, err := loadShouldInclude(args.IncludeExpr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot process include expression %q", args.IncludeExpr)
+	}
+	pathMap, err := parsePathMap(args.PathMap)
+	if err != nil {
+		return nil, err
+	}
+	if args.HashRetries < 0 {
+		return nil, fmt.Errorf("invalid number of hash retries %d: negative", args.HashRetries)
+	}
+	modTimeTolerance, err := parseDuration(args.ModTimeTolerance)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid mod time tolerance %q", args.ModTimeTolerance)
+	}
+	if args.VerifyAgainstPath != "" && (len(args.CachePaths) > 0 || args.HashDBPath != "") {
+		return nil, errors.Errorf("cannot use caches when verifying against a previous scan")
+	}
+	prev, err := loadScanRoot(args.VerifyAgainstPath, "previous scan")
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot load previous scan file %q", args.VerifyAgainstPath)
+	}
+	if prev != nil {
+		if name, ok := pathMap.Map(prev.Name); ok {
+			log.Printf("mapping root %q of previous scan to %q\n", prev.Name, name)
+			prev.Name = name
+		}
+	}
+	var caches []*scan.Dir
+	for _, p := range args.CachePaths {
+		cache, err := loadScanCache(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot load scan cache file %q", p)
+		}
+		if cache == nil {
+			continue
+		}
+		if name, ok := pathMap.Map(cache.Name); ok {
+			log.Printf("mapping root %q of scan cache to %q\n", cache.Name, name)
+			cache.Name = name
+		}
+		caches = append(caches, cache)
+	}
+	db, err := loadHashDB(args.HashDBPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot load hash database file %q", args.HashDBPath)
 	}
 	absDir, err := absPath(dir)
 	if err != nil {
@@ -48,14 +139,74 @@ func Scan(dir, skipExpr, cachePath string) (*scan.Result, error) {
 		log.Printf("absolute path of %q resolved to %q\n", dir, absDir)
 	}
 	runStart := time.Now()
-	run, err := scan.Run(absDir, shouldSkip, cache)
+	run, err := scan.RunWithOptions(absDir, scan.Options{
+		ShouldSkip:       shouldSkip,
+		ShouldInclude:    shouldInclude,
+		FileFilter:       fileFilter,
+		Caches:           caches,
+		HashCache:        hashCache(db),
+		HashRetries:      args.HashRetries,
+		ModTimeTolerance: modTimeTolerance,
+		FollowSymlinks:   args.FollowSymlinks,
+		OneFileSystem:    args.OneFileSystem,
+	})
 	if err != nil {
 		return nil, err
 	}
-	log.Printf("scan completed successfully in %v\n", timeSince(runStart))
+	run.Metadata.ToolVersion = toolVersion()
+	if db != nil {
+		if n := db.Prune(absDir); n > 0 {
+			log.Printf("removed %d entries of files that no longer exist from hash database\n", n)
+		}
+	}
+	if db != nil && db.Modified() {
+		if err := db.Save(args.HashDBPath); err != nil {
+			return nil, errors.Wrapf(err, "cannot save hash database file %q", args.HashDBPath)
+		}
+		log.Printf("hash database saved to %q (%d entries)\n", args.HashDBPath, db.Len())
+	}
+	stats := formatScanStats(run.Stats, len(caches) > 0, db != nil)
+	if n := len(run.Errors); n > 0 {
+		log.Printf("scan completed with %d error(s) in %v: %v\n", n, timeSince(runStart), stats)
+	} else {
+		log.Printf("scan completed successfully in %v: %v\n", timeSince(runStart), stats)
+	}
+	if s := run.Stats; len(caches) > 0 && s.CacheHits == 0 && s.CacheMisses+s.CacheStale > 0 {
+		log.Printf("warning: the hashes of none of the scanned files were found in the cache - is it the right one?\n")
+	}
+	if prev != nil {
+		v, err := scan.Verify(prev, run.Root, modTimeTolerance)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot verify against previous scan file %q", args.VerifyAgainstPath)
+		}
+		for _, c := range v.Corrupted {
+			log.Printf("error: hash of file %q changed from %d to %d without its size or modification time changing - it may be corrupted\n", c.Path, c.PrevHash, c.Hash)
+		}
+		log.Printf("verified against previous scan of %q: %d unchanged, %d modified, %d corrupted file(s)\n", v.Against, v.Unchanged, v.Modified, len(v.Corrupted))
+		run.Verification = v
+	}
 	return run, nil
 }
 
+// checkVerification returns an error if the verification recorded in the provided scan result (if any)
+// detected corrupted files.
+func checkVerification(res *scan.Result) error {
+	if v := res.Verification; v != nil && len(v.Corrupted) > 0 {
+		return fmt.Errorf("verification detected %d corrupted file(s)", len(v.Corrupted))
+	}
+	return nil
+}
+
+// checkScanErrorCount returns an error if the number of errors recorded in the provided scan result
+// exceeds the provided maximum.
+// A negative maximum disables the check.
+func checkScanErrorCount(res *scan.Result, maxErrors int) error {
+	if n := len(res.Errors); maxErrors >= 0 && n > maxErrors {
+		return fmt.Errorf("scan encountered %d error(s) which exceeds the maximum of %d", n, maxErrors)
+	}
+	return nil
+}
+
 func loadShouldSkip(expr string) (scan.ShouldSkipPath, error) {
 	names, err := parseSkipNames(expr)
 	if err != nil {
@@ -118,6 +269,162 @@ func parseSkipNameFile(path string) ([]string, error) {
 	}
 }
 
+// loadFileFilter constructs a FileFilter from the size and time filters of the provided arguments.
+// Relative times are resolved against the provided time.
+// If no filters are specified, then nil is returned.
+func loadFileFilter(args ScanArgs, now time.Time) (scan.FileFilter, error) {
+	minSize, err := parseSize(args.MinSize)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid min size %q", args.MinSize)
+	}
+	maxSize := int64(-1) // no max size
+	if args.MaxSize != "" {
+		maxSize, err = parseSize(args.MaxSize)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid max size %q", args.MaxSize)
+		}
+		if minSize > maxSize {
+			return nil, fmt.Errorf("min size %d is larger than max size %d", minSize, maxSize)
+		}
+	}
+	newerThan, err := parseTime(args.NewerThan, now)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid 'newer than' time %q", args.NewerThan)
+	}
+	olderThan, err := parseTime(args.OlderThan, now)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid 'older than' time %q", args.OlderThan)
+	}
+	if !newerThan.IsZero() && !olderThan.IsZero() && !newerThan.Before(olderThan) {
+		return nil, fmt.Errorf("'newer than' time %v is not before 'older than' time %v", newerThan, olderThan)
+	}
+
+	var fs []scan.FileFilter
+	if minSize > 0 || maxSize >= 0 {
+		fs = append(fs, scan.FilterFileSize(minSize, maxSize))
+	}
+	if !newerThan.IsZero() || !olderThan.IsZero() {
+		fs = append(fs, scan.FilterFileModTime(newerThan, olderThan))
+	}
+	switch len(fs) {
+	case 0:
+		return nil, nil
+	case 1:
+		return fs[0], nil
+	}
+	return scan.FilterAny(fs...), nil
+}
+
+// parseSize parses a size expression:
+// A non-negative integer number of bytes, optionally followed by one of the (binary) suffixes 'K', 'M', 'G', or 'T'.
+// The empty expression evaluates to 0.
+func parseSize(expr string) (int64, error) {
+	if expr == "" {
+		return 0, nil
+	}
+	numEnd := strings.IndexFunc(expr, func(r rune) bool { return r < '0' || r > '9' })
+	if numEnd == -1 {
+		numEnd = len(expr)
+	}
+	num, suffix := expr[:numEnd], expr[numEnd:]
+	mul, ok := sizeSuffixes[strings.ToLower(suffix)]
+	if !ok {
+		return 0, fmt.Errorf("unknown suffix %q", suffix)
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid number %q", num)
+	}
+	if n > (1<<63-1)/mul {
+		return 0, errors.Errorf("size is too large")
+	}
+	return n * mul, nil
+}
+
+// parseDuration parses a non-negative duration expression (like "2s" or "500ms").
+// The empty expression evaluates to 0.
+func parseDuration(expr string) (time.Duration, error) {
+	if expr == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(expr)
+	if err != nil {
+		return 0, errors.Errorf("invalid duration")
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration")
+	}
+	return d, nil
+}
+
+// parseTime parses a time expression:
+// Either a duration (like "36h") which is subtracted from the provided time,
+// or an absolute timestamp in RFC 3339 format (like "2006-01-02T15:04:05Z07:00")
+// or as a date (like "2006-01-02") which is interpreted as midnight in the local time zone.
+// The empty expression evaluates to the zero time.
+func parseTime(expr string, now time.Time) (time.Time, error) {
+	if expr == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(expr); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("negative duration")
+		}
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, expr); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", expr, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("not a duration, timestamp, or date")
+}
+
+func loadShouldInclude(expr string) (scan.ShouldIncludeFile, error) {
+	patterns, err := parseIncludePatterns(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) == 0 {
+		return scan.IncludeAll, nil
+	}
+	for _, p := range patterns {
+		if err := validateIncludePattern(p); err != nil {
+			return nil, errors.Wrapf(err, "invalid include pattern %q", p)
+		}
+	}
+	return scan.IncludeNamePatterns(patterns), nil
+}
+
+func parseIncludePatterns(input string) ([]string, error) {
+	if len(input) == 0 {
+		return nil, nil
+	}
+	if input[0] == '@' {
+		f := input[1:]
+		res, err := parseSkipNameFile(f) // the file format is the same
+		return res, errors.Wrapf(err, "cannot read include patterns from file %q", f)
+	}
+	return strings.Split(input, ","), nil
+}
+
+func validateIncludePattern(pattern string) error {
+	if strings.TrimSpace(pattern) != pattern {
+		return fmt.Errorf("surrounding space")
+	}
+	if pattern == "" {
+		return fmt.Errorf("empty")
+	}
+	if i := strings.IndexAny(pattern, "/"+string(filepath.Separator)); i != -1 {
+		return fmt.Errorf("invalid character '%c'", pattern[i])
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("malformed pattern")
+	}
+	return nil
+}
+
 func validateSkipName(name string) error {
 	if strings.TrimSpace(name) != name {
 		return fmt.Errorf("surrounding space")
@@ -139,34 +446,85 @@ func validateSkipName(name string) error {
 }
 
 func loadScanCache(path string) (*scan.Dir, error) {
+	return loadScanRoot(path, "scan cache")
+}
+
+// loadScanRoot loads and validates the root of the scan result file at the provided path (see loadScanResult).
+// If the path is empty, nil is returned.
+func loadScanRoot(path, desc string) (*scan.Dir, error) {
 	if path == "" {
 		return nil, nil
 	}
-	log.Printf("loading scan cache file %q...\n", path)
+	res, err := loadScanResult(path, desc)
+	if err != nil {
+		return nil, err
+	}
+	return res.Root, nil
+}
+
+// loadScanResult loads the scan result file at the provided path and validates its schema version and root.
+// The description of the file's purpose is used in the logs.
+func loadScanResult(path, desc string) (*scan.Result, error) {
+	log.Printf("loading %s file %q...\n", desc, path)
 	start := time.Now()
-	cacheRoot, err := loadScanCacheResultRoot(path)
+	res, err := loadScanResultFile(path)
 	if err != nil {
 		return nil, err
 	}
-	if cacheRoot == nil {
+	if err := checkResultTypeVersion(res.TypeVersion); err != nil {
+		return nil, err
+	}
+	if res.Root == nil {
 		return nil, errors.Errorf("no root")
 	}
 	// Could just sort lists instead of (only) validating,
 	// but it appears to be a needless complication for something that should never happen.
 	// So if it does, it probably indicates a problem that's worth alarming the user about.
-	if err := checkCacheRoot(cacheRoot); err != nil {
+	if err := checkCacheRoot(res.Root); err != nil {
 		return nil, errors.Wrap(err, "invalid root") // caller wraps path
 	}
-	log.Printf("scan cache loaded successfully from %q in %v\n", path, timeSince(start))
-	return cacheRoot, nil
+	log.Printf("%s loaded successfully from %q in %v\n", desc, path, timeSince(start))
+	return res, nil
 }
 
-func loadScanCacheResultRoot(path string) (*scan.Dir, error) {
-	res, err := loadScanResultFile(path)
+// formatScanStats formats the provided stats for the summary log of a scan.
+// Cache and hash database stats are only included if the respective kind of cache was used.
+func formatScanStats(s *scan.Stats, withCache, withHashDB bool) string {
+	reused := s.CacheHits + s.HashCacheHits + s.HardlinkHits
+	res := fmt.Sprintf("hashed %d file(s) (%d bytes), reused %d hash(es) (%d bytes)", s.FilesHashed, s.BytesHashed, reused, s.BytesReused)
+	if withCache {
+		res += fmt.Sprintf("; cache: %d hit(s), %d miss(es), %d stale, %d missing", s.CacheHits, s.CacheMisses, s.CacheStale, s.CacheMissing)
+	}
+	if withHashDB {
+		res += fmt.Sprintf("; hash database: %d hit(s)", s.HashCacheHits)
+	}
+	if s.FilesUnstable > 0 {
+		res += fmt.Sprintf("; %d file(s) kept changing while being hashed", s.FilesUnstable)
+	}
+	return res
+}
+
+func loadHashDB(path string) (*hashdb.DB, error) {
+	if path == "" {
+		return nil, nil
+	}
+	log.Printf("loading hash database file %q...\n", path)
+	start := time.Now()
+	db, err := hashdb.Load(path)
 	if err != nil {
 		return nil, err
 	}
-	return res.Root, checkResultTypeVersion(res.TypeVersion)
+	log.Printf("hash database loaded successfully from %q in %v (%d entries)\n", path, timeSince(start), db.Len())
+	return db, nil
+}
+
+// hashCache converts the provided DB into a scan.HashCache
+// (a nil *hashdb.DB would otherwise become a non-nil interface value).
+func hashCache(db *hashdb.DB) scan.HashCache {
+	if db == nil {
+		return nil
+	}
+	return db
 }
 
 func checkResultTypeVersion(v int) error {
@@ -221,8 +579,8 @@ func checkCacheRoot(root *scan.Dir) error {
 		if f.Size == 0 {
 			return fmt.Errorf("file %q on index %d has size 0, but is not listed as empty", f.Name, i)
 		}
-		// Log warning if hash is zero.
-		if f.Hash == 0 {
+		// Log warning if hash is zero (unless the file is explicitly marked as unhashed).
+		if f.Hash == 0 && !f.Unhashed {
 			log.Printf("warning: file %q is cached with hash 0 - this hash will be recomputed\n", f.Name)
 		}
 		// Timestamps are used by the cache, but any value is valid, so there's nothing to check.
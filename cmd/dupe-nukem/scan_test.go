@@ -10,11 +10,15 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/bisgardo/dupe-nukem/hash"
+	"github.com/bisgardo/dupe-nukem/hashdb"
 	"github.com/bisgardo/dupe-nukem/scan"
+	"github.com/bisgardo/dupe-nukem/scan/scantest"
 	. "github.com/bisgardo/dupe-nukem/testutil"
 )
 
@@ -114,13 +118,277 @@ func Test__regex_characters_are_invalid_in_skip_name(t *testing.T) {
 	assert.Contains(t, invalidSkipNameChars, '?')
 }
 
+func Test__parseSize(t *testing.T) {
+	tests := []struct {
+		expr string
+		want int64
+	}{
+		{expr: "", want: 0},
+		{expr: "0", want: 0},
+		{expr: "42", want: 42},
+		{expr: "1k", want: 1024},
+		{expr: "2K", want: 2048},
+		{expr: "3M", want: 3 << 20},
+		{expr: "4g", want: 4 << 30},
+		{expr: "5T", want: 5 << 40},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			res, err := parseSize(test.expr)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, res)
+		})
+	}
+}
+
+func Test__parseSize_invalid_fails(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: "K", wantErr: `invalid number ""`},
+		{expr: "-1", wantErr: `unknown suffix "-1"`},
+		{expr: "1KB", wantErr: `unknown suffix "KB"`},
+		{expr: "1 K", wantErr: `unknown suffix " K"`},
+		{expr: "99999999999999999999", wantErr: `invalid number "99999999999999999999"`},
+		{expr: "9999999T", wantErr: `size is too large`},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			_, err := parseSize(test.expr)
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
+
+func Test__parseTime(t *testing.T) {
+	now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{expr: "", want: time.Time{}},
+		{expr: "0s", want: now},
+		{expr: "36h", want: now.Add(-36 * time.Hour)},
+		{expr: "2001-02-03T04:05:06Z", want: time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)},
+		{expr: "2001-02-03", want: time.Date(2001, 2, 3, 0, 0, 0, 0, time.Local)},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			res, err := parseTime(test.expr, now)
+			require.NoError(t, err)
+			assert.True(t, test.want.Equal(res), "expected %v but got %v", test.want, res)
+		})
+	}
+}
+
+func Test__parseTime_invalid_fails(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: "-1h", wantErr: `negative duration`},
+		{expr: "1d", wantErr: `not a duration, timestamp, or date`},
+		{expr: "2001-02-30", wantErr: `not a duration, timestamp, or date`},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			_, err := parseTime(test.expr, time.Now())
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
+
+func Test__parseDuration(t *testing.T) {
+	tests := []struct {
+		expr string
+		want time.Duration
+	}{
+		{expr: "", want: 0},
+		{expr: "0s", want: 0},
+		{expr: "2s", want: 2 * time.Second},
+		{expr: "1.5ms", want: 1500 * time.Microsecond},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			res, err := parseDuration(test.expr)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, res)
+		})
+	}
+}
+
+func Test__parseDuration_invalid_fails(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: "-1s", wantErr: `negative duration`},
+		{expr: "2", wantErr: `invalid duration`},
+		{expr: "x", wantErr: `invalid duration`},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			_, err := parseDuration(test.expr)
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
+
+func Test__Scan_wraps_mod_time_tolerance_error(t *testing.T) {
+	_, err := Scan("x", ScanArgs{ModTimeTolerance: "x"})
+	assert.EqualError(t, err, `invalid mod time tolerance "x": invalid duration`)
+}
+
+func Test__loadFileFilter_without_filters_returns_nil(t *testing.T) {
+	res, err := loadFileFilter(ScanArgs{SkipExpr: "x", CachePaths: []string{"y"}}, time.Now())
+	require.NoError(t, err)
+	assert.Nil(t, res)
+}
+
+func Test__loadFileFilter_invalid_fails(t *testing.T) {
+	now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		args    ScanArgs
+		wantErr string
+	}{
+		{name: "invalid min size", args: ScanArgs{MinSize: "x"}, wantErr: `invalid min size "x": unknown suffix "x"`},
+		{name: "invalid max size", args: ScanArgs{MaxSize: "x"}, wantErr: `invalid max size "x": unknown suffix "x"`},
+		{name: "min size above max size", args: ScanArgs{MinSize: "2K", MaxSize: "1K"}, wantErr: `min size 2048 is larger than max size 1024`},
+		{name: "invalid newer than", args: ScanArgs{NewerThan: "x"}, wantErr: `invalid 'newer than' time "x": not a duration, timestamp, or date`},
+		{name: "invalid older than", args: ScanArgs{OlderThan: "x"}, wantErr: `invalid 'older than' time "x": not a duration, timestamp, or date`},
+		{
+			name:    "newer than after older than",
+			args:    ScanArgs{NewerThan: "1h", OlderThan: "2h"},
+			wantErr: `'newer than' time 2006-01-02 14:04:05 +0000 UTC is not before 'older than' time 2006-01-02 13:04:05 +0000 UTC`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadFileFilter(test.args, now)
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
+
+func Test__Scan_applies_size_filters(t *testing.T) {
+	absRootPath, err := filepath.Abs("testdata")
+	require.NoError(t, err)
+
+	res, err := Scan("testdata", ScanArgs{SkipExpr: "cache1.json", MinSize: "9", MaxSize: "100"})
+	require.NoError(t, err)
+	scantest.AssertEqualResult(t, res, &scan.Result{
+		TypeVersion: scan.CurrentResultTypeVersion,
+		Root: &scan.Dir{
+			Name: absRootPath,
+			Files: []*scan.File{
+				{Name: "cache2.json.gz", Size: 80, ModTime: ModTime(t, "./testdata/cache2.json.gz"), Hash: 921101782703557466},
+				{Name: "skipnames_crlf", Size: 11, ModTime: ModTime(t, "./testdata/skipnames_crlf"), Hash: 15953509558814875971},
+			},
+			SkippedFiles:  []string{"cache1.json"},
+			FilteredFiles: []string{".gitattributes", "skipnames"},
+		},
+	})
+}
+
+func Test__Scan_honors_zero_max_size(t *testing.T) {
+	absRootPath, err := filepath.Abs("testdata")
+	require.NoError(t, err)
+
+	res, err := Scan("testdata", ScanArgs{SkipExpr: "cache1.json", MaxSize: "0"})
+	require.NoError(t, err)
+	scantest.AssertEqualResult(t, res, &scan.Result{
+		TypeVersion: scan.CurrentResultTypeVersion,
+		Root: &scan.Dir{
+			Name:          absRootPath,
+			SkippedFiles:  []string{"cache1.json"},
+			FilteredFiles: []string{".gitattributes", "cache2.json.gz", "skipnames", "skipnames_crlf"},
+		},
+	})
+}
+
+func Test__parseIncludePatterns_splits_on_comma(t *testing.T) {
+	input := "*.jpg,*.mov"
+	want := []string{"*.jpg", "*.mov"}
+	res, err := parseIncludePatterns(input)
+	require.NoError(t, err)
+	assert.Equal(t, want, res)
+}
+
+func Test__parseIncludePatterns_with_at_prefix_splits_file_on_newline(t *testing.T) {
+	path := TempStringFile(t, "*.jpg\n\n*.cr2\r\n")
+	want := []string{"*.jpg", "*.cr2"}
+	res, err := parseIncludePatterns("@" + path)
+	require.NoError(t, err)
+	assert.Equal(t, want, res)
+}
+
+func Test__loadShouldInclude_empty_includes_all(t *testing.T) {
+	res, err := loadShouldInclude("")
+	require.NoError(t, err)
+	assert.True(t, res("x", "y", nil))
+}
+
+func Test__loadShouldInclude_invalid_patterns_fail(t *testing.T) {
+	tests := []struct {
+		patterns string
+		wantErr  string
+	}{
+		{patterns: " *.jpg", wantErr: `invalid include pattern " *.jpg": surrounding space`},
+		{patterns: "*.jpg,", wantErr: `invalid include pattern "": empty`},
+		{patterns: "x/*.jpg", wantErr: `invalid include pattern "x/*.jpg": invalid character '/'`},
+		{patterns: "[x", wantErr: `invalid include pattern "[x": malformed pattern`},
+		{patterns: "@missing", wantErr: `cannot read include patterns from file "missing": cannot open file: not found`},
+	}
+	for _, test := range tests {
+		t.Run(test.patterns, func(t *testing.T) {
+			_, err := loadShouldInclude(test.patterns)
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
+
+func Test__Scan_wraps_include_error(t *testing.T) {
+	_, err := Scan("x", ScanArgs{IncludeExpr: "[x"})
+	assert.EqualError(t, err, `cannot process include expression "[x": invalid include pattern "[x": malformed pattern`)
+}
+
+func Test__Scan_applies_include_patterns(t *testing.T) {
+	absRootPath, err := filepath.Abs("testdata")
+	require.NoError(t, err)
+
+	res, err := Scan("testdata", ScanArgs{SkipExpr: "skipnames_crlf", IncludeExpr: "*.json,skip*"})
+	require.NoError(t, err)
+	scantest.AssertEqualResult(t, res, &scan.Result{
+		TypeVersion: scan.CurrentResultTypeVersion,
+		Root: &scan.Dir{
+			Name: absRootPath,
+			Files: []*scan.File{
+				{Name: "cache1.json", Size: 297, ModTime: ModTime(t, "./testdata/cache1.json"), Hash: 4470884388509523918},
+				{Name: "skipnames", Size: 7, ModTime: ModTime(t, "./testdata/skipnames"), Hash: 10951817445047336725},
+			},
+			SkippedFiles:  []string{"skipnames_crlf"},
+			FilteredFiles: []string{".gitattributes", "cache2.json.gz"},
+		},
+	})
+}
+
+func Test__Scan_sets_tool_version(t *testing.T) {
+	res, err := Scan("testdata", ScanArgs{})
+	require.NoError(t, err)
+	require.NotNil(t, res.Metadata)
+	assert.Equal(t, toolVersion(), res.Metadata.ToolVersion)
+	assert.NotEmpty(t, res.Metadata.ToolVersion)
+}
+
 func Test__Scan_wraps_skip_file_not_found_error(t *testing.T) {
-	_, err := Scan("x", "@missing", "")
+	_, err := Scan("x", ScanArgs{SkipExpr: "@missing"})
 	assert.EqualError(t, err, `cannot process skip dirs expression "@missing": cannot read skip names from file "missing": cannot open file: not found`)
 }
 
 func Test__Scan_wraps_parse_error_of_skip_names(t *testing.T) {
-	_, err := Scan("x", "valid, it's not", "")
+	_, err := Scan("x", ScanArgs{SkipExpr: "valid, it's not"})
 	assert.EqualError(t, err, `cannot process skip dirs expression "valid, it's not": invalid skip name " it's not": surrounding space`)
 }
 
@@ -258,7 +526,7 @@ func Test__loadScanDirCacheFile_wraps_invalid_cache_error(t *testing.T) {
 func Test__Scan_wraps_invalid_dir_error(t *testing.T) {
 	dir, err := os.Getwd()
 	require.NoError(t, err)
-	_, err = Scan(string([]byte{0}), "", "")
+	_, err = Scan(string([]byte{0}), ScanArgs{})
 	want := fmt.Sprintf(`invalid root directory "%s/\x00": invalid argument (lstat)`, dir)
 	//goland:noinspection GoBoolExpressions
 	if runtime.GOOS == "windows" {
@@ -269,20 +537,20 @@ func Test__Scan_wraps_invalid_dir_error(t *testing.T) {
 }
 
 func Test__Scan_wraps_cache_file_not_found_error(t *testing.T) {
-	_, err := Scan("x", "", "missing")
+	_, err := Scan("x", ScanArgs{CachePaths: []string{"missing"}})
 	assert.EqualError(t, err, `cannot load scan cache file "missing": cannot open file: not found`)
 }
 
 func Test__Scan_wraps_cache_file_not_accessible_error(t *testing.T) {
 	path := TempStringFile(t, "")
 	MakeInaccessibleT(t, path)
-	_, err := Scan("x", "", path)
+	_, err := Scan("x", ScanArgs{CachePaths: []string{path}})
 	assert.EqualError(t, err, fmt.Sprintf("cannot load scan cache file %q: cannot open file: access denied", path))
 }
 
 func Test__Scan_wraps_cache_load_error(t *testing.T) {
 	path := TempStringFile(t, "{")
-	_, err := Scan("x", "", path)
+	_, err := Scan("x", ScanArgs{CachePaths: []string{path}})
 	assert.EqualError(t, err, fmt.Sprintf("cannot load scan cache file %q: invalid JSON: unexpected EOF", path))
 }
 
@@ -397,6 +665,68 @@ func Test__checkCache_logs_warning_on_hash_0(t *testing.T) {
 	assert.Equal(t, Lines("warning: file \"a\" is cached with hash 0 - this hash will be recomputed"), logs.String())
 }
 
+func Test__checkCache_does_not_log_warning_on_unhashed_file(t *testing.T) {
+	logs := CaptureLogs(t)
+	err := checkCacheRoot(&scan.Dir{
+		Name:  "x",
+		Files: []*scan.File{{Name: "a", Size: 1, ModTime: 19, Unhashed: true}},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, logs.String())
+}
+
+func Test__checkScanErrorCount(t *testing.T) {
+	res := &scan.Result{
+		Errors: []*scan.ErrorRecord{
+			{Path: "x", Op: scan.OpHash, Error: "access denied"},
+			{Path: "y", Op: scan.OpList, Error: "access denied"},
+		},
+	}
+	tests := []struct {
+		maxErrors int
+		wantErr   string
+	}{
+		{maxErrors: -1},
+		{maxErrors: 0, wantErr: "scan encountered 2 error(s) which exceeds the maximum of 0"},
+		{maxErrors: 1, wantErr: "scan encountered 2 error(s) which exceeds the maximum of 1"},
+		{maxErrors: 2},
+		{maxErrors: 3},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("max %d", test.maxErrors), func(t *testing.T) {
+			err := checkScanErrorCount(res, test.maxErrors)
+			if test.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.wantErr)
+			}
+		})
+	}
+	t.Run("no errors", func(t *testing.T) {
+		err := checkScanErrorCount(&scan.Result{}, 0)
+		assert.NoError(t, err)
+	})
+}
+
+func Test__scan_logs_error_count(t *testing.T) {
+	rootPath, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	path := filepath.Join(rootPath, "x")
+	err = os.WriteFile(path, []byte("x"), 0600)
+	require.NoError(t, err)
+	MakeInaccessibleT(t, path)
+
+	logs := CaptureLogs(t)
+	res, err := Scan(rootPath, ScanArgs{})
+	require.NoError(t, err)
+	assert.Equal(t, []*scan.ErrorRecord{{Path: path, Op: scan.OpHash, Error: "cannot open file: access denied"}}, res.Errors)
+	ls := strings.Split(logs.String(), "\n")
+	require.Len(t, ls, 3)
+	assert.Equal(t, fmt.Sprintf("error: cannot hash file %q: cannot open file: access denied", path), ls[0])
+	assert.Regexp(t, `^scan completed with 1 error\(s\) in [\w.]+s: hashed 0 file\(s\) \(0 bytes\), reused 0 hash\(es\) \(0 bytes\)$`, ls[1])
+	assert.Empty(t, ls[2])
+}
+
 func Test__scan_testdata(t *testing.T) {
 	absRootPath, err := filepath.Abs("testdata")
 	require.NoError(t, err)
@@ -430,9 +760,9 @@ func Test__scan_testdata(t *testing.T) {
 	}
 
 	for root := range roots {
-		res, err := Scan(root, "", "")
+		res, err := Scan(root, ScanArgs{})
 		require.NoError(t, err)
-		assert.Equal(t, want, res)
+		scantest.AssertEqualResult(t, res, want)
 	}
 }
 
@@ -441,12 +771,12 @@ func Test__scan_logs_absolute_path_of_relative_dir(t *testing.T) {
 	absDir, err := filepath.Abs(dir)
 	require.NoError(t, err)
 	logs := CaptureLogs(t)
-	_, err = Scan(dir, "", "")
+	_, err = Scan(dir, ScanArgs{})
 	require.NoError(t, err)
 	ls := strings.Split(logs.String(), "\n")
 	assert.Len(t, ls, 3)
 	assert.Equal(t, fmt.Sprintf("absolute path of %q resolved to %q", dir, absDir), ls[0])
-	assert.Regexp(t, `^scan completed successfully in [\w.]+s$`, ls[1])
+	assert.Regexp(t, `^scan completed successfully in [\w.]+s: hashed 5 file\(s\) \(403 bytes\), reused 0 hash\(es\) \(0 bytes\)$`, ls[1])
 	assert.Empty(t, ls[2])
 }
 
@@ -454,11 +784,11 @@ func Test__scan_does_not_log_absolute_dir_path(t *testing.T) {
 	absDir, err := filepath.Abs("testdata")
 	require.NoError(t, err)
 	logs := CaptureLogs(t)
-	_, err = Scan(absDir, "", "")
+	_, err = Scan(absDir, ScanArgs{})
 	require.NoError(t, err)
 	ls := strings.Split(logs.String(), "\n")
 	assert.Len(t, ls, 2)
-	assert.Regexp(t, `^scan completed successfully in [\w.]+s$`, ls[0])
+	assert.Regexp(t, `^scan completed successfully in [\w.]+s: hashed 5 file\(s\) \(403 bytes\), reused 0 hash\(es\) \(0 bytes\)$`, ls[0])
 	assert.Empty(t, ls[1])
 }
 
@@ -517,9 +847,247 @@ func Test__scan_testdata_uses_provided_cache(t *testing.T) {
 				cacheBytes = buf.Bytes()
 			}
 			cachePath := TempFileByPattern(t, pattern, cacheBytes)
-			res, err := Scan(rootPath, "", cachePath)
+			res, err := Scan(rootPath, ScanArgs{CachePaths: []string{cachePath}})
 			require.NoError(t, err)
-			assert.Equal(t, want, res)
+			scantest.AssertEqualResult(t, res, want)
+		})
+	}
+}
+
+func Test__scan_testdata_uses_cache_with_mapped_root(t *testing.T) {
+	modTime_cache1 := ModTime(t, "./testdata/cache1.json")
+	rootPath, err := filepath.Abs("./testdata")
+	require.NoError(t, err)
+	otherParentPath := filepath.FromSlash("/mnt/other")
+
+	cache := &scan.Result{
+		TypeVersion: scan.CurrentResultTypeVersion,
+		Root: &scan.Dir{
+			Name: filepath.Join(otherParentPath, "testdata"),
+			Files: []*scan.File{
+				{Name: "cache1.json", Size: 297, ModTime: modTime_cache1, Hash: 69}, // wrong hash to detect that cache is used
+			},
+		},
+	}
+	cacheBytes, err := json.Marshal(cache)
+	require.NoError(t, err)
+	cachePath := TempFileByPattern(t, "", cacheBytes)
+
+	t.Run("without mapping", func(t *testing.T) {
+		_, err := Scan(rootPath, ScanArgs{CachePaths: []string{cachePath}})
+		assert.EqualError(t, err, fmt.Sprintf("cache of directory %q cannot be used with root directory %q", cache.Root.Name, rootPath))
+	})
+	t.Run("with mapping", func(t *testing.T) {
+		logs := CaptureLogs(t)
+		res, err := Scan(rootPath, ScanArgs{
+			CachePaths: []string{cachePath},
+			PathMap:    []string{otherParentPath + "=" + filepath.Dir(rootPath)},
 		})
+		require.NoError(t, err)
+		f := scan.SafeFindFile(res.Root, "cache1.json")
+		require.NotNil(t, f)
+		assert.Equal(t, uint64(69), f.Hash)
+		assert.Contains(t, logs.String(), fmt.Sprintf("mapping root %q of scan cache to %q\n", cache.Root.Name, rootPath))
+	})
+}
+
+func Test__scan_testdata_uses_closest_of_multiple_caches(t *testing.T) {
+	modTime_cache1 := ModTime(t, "./testdata/cache1.json")
+	modTime_skipnames := ModTime(t, "./testdata/skipnames")
+	rootPath, err := filepath.Abs("./testdata")
+	require.NoError(t, err)
+
+	writeCache := func(root *scan.Dir) string {
+		bs, err := json.Marshal(&scan.Result{TypeVersion: scan.CurrentResultTypeVersion, Root: root})
+		require.NoError(t, err)
+		return TempFileByPattern(t, "", bs)
 	}
+	parentCachePath := writeCache(&scan.Dir{
+		Name: filepath.Dir(rootPath),
+		Dirs: []*scan.Dir{
+			{
+				Name: "testdata",
+				Files: []*scan.File{
+					{Name: "cache1.json", Size: 297, ModTime: modTime_cache1, Hash: 69},
+					{Name: "skipnames", Size: 7, ModTime: modTime_skipnames, Hash: 69},
+				},
+			},
+		},
+	})
+	rootCachePath := writeCache(&scan.Dir{
+		Name: rootPath,
+		Files: []*scan.File{
+			{Name: "skipnames", Size: 7, ModTime: modTime_skipnames, Hash: 70},
+		},
+	})
+
+	res, err := Scan(rootPath, ScanArgs{CachePaths: []string{parentCachePath, rootCachePath}})
+	require.NoError(t, err)
+	f := scan.SafeFindFile(res.Root, "cache1.json")
+	require.NotNil(t, f)
+	assert.Equal(t, uint64(4470884388509523918), f.Hash) // only in cache of parent, which isn't the closest one
+	f = scan.SafeFindFile(res.Root, "skipnames")
+	require.NotNil(t, f)
+	assert.Equal(t, uint64(70), f.Hash)
+}
+
+func Test__scan_uses_and_updates_hash_db(t *testing.T) {
+	rootPath := t.TempDir()
+	pathA, pathB := filepath.Join(rootPath, "a"), filepath.Join(rootPath, "b")
+	err := os.WriteFile(pathA, []byte("a\n"), 0600)
+	require.NoError(t, err)
+	err = os.WriteFile(pathB, []byte("b\n"), 0600)
+	require.NoError(t, err)
+	rootPath, err = filepath.EvalSymlinks(rootPath)
+	require.NoError(t, err)
+	pathA, pathB = filepath.Join(rootPath, "a"), filepath.Join(rootPath, "b")
+
+	// Prepare database with (wrong) hash of "a" and hash of deleted file "c".
+	dbPath := filepath.Join(t.TempDir(), "hashes.json")
+	db := hashdb.New()
+	db.Store(scan.FileKey{Path: pathA, Size: 2, ModTime: ModTime(t, pathA)}, 69)
+	db.Store(scan.FileKey{Path: filepath.Join(rootPath, "c"), Size: 2, ModTime: 1}, 42)
+	err = db.Save(dbPath)
+	require.NoError(t, err)
+
+	logs := CaptureLogs(t)
+	res, err := Scan(rootPath, ScanArgs{HashDBPath: dbPath})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(69), scan.SafeFindFile(res.Root, "a").Hash)
+	hashB := scan.SafeFindFile(res.Root, "b").Hash
+	assert.Equal(t, hash.Bytes([]byte("b\n")), hashB)
+	assert.Contains(t, logs.String(), "removed 1 entries of files that no longer exist from hash database\n")
+	assert.Contains(t, logs.String(), fmt.Sprintf("hash database saved to %q (2 entries)\n", dbPath))
+
+	// Database was updated with the hash of "b" (and without the one of "c").
+	db, err = hashdb.Load(dbPath)
+	require.NoError(t, err)
+	h, ok := db.Lookup(scan.FileKey{Path: pathB, Size: 2, ModTime: ModTime(t, pathB)})
+	assert.True(t, ok)
+	assert.Equal(t, hashB, h)
+}
+
+func Test__Scan_wraps_invalid_hash_db_error(t *testing.T) {
+	path := TempStringFile(t, "{}")
+	_, err := Scan("x", ScanArgs{HashDBPath: path})
+	assert.EqualError(t, err, fmt.Sprintf("cannot load hash database file %q: schema version is missing", path))
+}
+
+func Test__scan_with_ineffective_cache_logs_stats_and_warning(t *testing.T) {
+	rootPath, err := filepath.Abs("./testdata")
+	require.NoError(t, err)
+	bs, err := json.Marshal(&scan.Result{
+		TypeVersion: scan.CurrentResultTypeVersion,
+		Root: &scan.Dir{
+			Name:  rootPath,
+			Files: []*scan.File{{Name: "missing", Size: 1, Hash: 1}},
+		},
+	})
+	require.NoError(t, err)
+	cachePath := TempFileByPattern(t, "", bs)
+
+	logs := CaptureLogs(t)
+	_, err = Scan(rootPath, ScanArgs{CachePaths: []string{cachePath}})
+	require.NoError(t, err)
+	ls := strings.Split(logs.String(), "\n")
+	require.Len(t, ls, 5)
+	assert.Regexp(t, `^scan completed successfully in [\w.]+s: hashed 5 file\(s\) \(403 bytes\), reused 0 hash\(es\) \(0 bytes\); cache: 0 hit\(s\), 5 miss\(es\), 0 stale, 1 missing$`, ls[2])
+	assert.Equal(t, "warning: the hashes of none of the scanned files were found in the cache - is it the right one?", ls[3])
+	assert.Empty(t, ls[4])
+}
+
+func Test__formatScanStats(t *testing.T) {
+	s := &scan.Stats{
+		FilesHashed:   1,
+		BytesHashed:   2,
+		BytesReused:   3,
+		CacheHits:     4,
+		CacheMisses:   5,
+		CacheStale:    6,
+		CacheMissing:  7,
+		HashCacheHits: 8,
+		HardlinkHits:  9,
+	}
+	assert.Equal(t, "hashed 1 file(s) (2 bytes), reused 21 hash(es) (3 bytes)", formatScanStats(s, false, false))
+	assert.Equal(t,
+		"hashed 1 file(s) (2 bytes), reused 21 hash(es) (3 bytes); cache: 4 hit(s), 5 miss(es), 6 stale, 7 missing; hash database: 8 hit(s)",
+		formatScanStats(s, true, true),
+	)
+	s.FilesUnstable = 10
+	assert.Equal(t,
+		"hashed 1 file(s) (2 bytes), reused 21 hash(es) (3 bytes); 10 file(s) kept changing while being hashed",
+		formatScanStats(s, false, false),
+	)
+}
+
+func Test__Scan_rejects_negative_hash_retries(t *testing.T) {
+	_, err := Scan("x", ScanArgs{HashRetries: -1})
+	assert.EqualError(t, err, "invalid number of hash retries -1: negative")
+}
+
+func Test__scan_verify_against_previous_scan_detects_corruption(t *testing.T) {
+	rootPath := t.TempDir()
+	err := os.WriteFile(filepath.Join(rootPath, "a"), []byte("a\n"), 0600)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(rootPath, "b"), []byte("b\n"), 0600)
+	require.NoError(t, err)
+	rootPath, err = filepath.EvalSymlinks(rootPath)
+	require.NoError(t, err)
+	pathB := filepath.Join(rootPath, "b")
+
+	prev, err := Scan(rootPath, ScanArgs{})
+	require.NoError(t, err)
+	prevPath := filepath.Join(t.TempDir(), "prev.json")
+	bs, err := json.Marshal(prev)
+	require.NoError(t, err)
+	err = os.WriteFile(prevPath, bs, 0600)
+	require.NoError(t, err)
+
+	// Change contents of "b" without changing its size or modification time.
+	info, err := os.Stat(pathB)
+	require.NoError(t, err)
+	err = os.WriteFile(pathB, []byte("c\n"), 0600)
+	require.NoError(t, err)
+	err = os.Chtimes(pathB, time.Time{}, info.ModTime())
+	require.NoError(t, err)
+
+	logs := CaptureLogs(t)
+	res, err := Scan(rootPath, ScanArgs{VerifyAgainstPath: prevPath})
+	require.NoError(t, err)
+	assert.Equal(t, &scan.Verification{
+		Against:   rootPath,
+		Unchanged: 1,
+		Corrupted: []*scan.Corruption{
+			{
+				Path:         pathB,
+				Size:         2,
+				ModTime:      info.ModTime().Unix(),
+				ModTimeNanos: int64(info.ModTime().Nanosecond()),
+				PrevHash:     hash.Bytes([]byte("b\n")),
+				Hash:         hash.Bytes([]byte("c\n")),
+			},
+		},
+	}, res.Verification)
+	assert.Contains(t, logs.String(),
+		fmt.Sprintf("error: hash of file %q changed from %d to %d without its size or modification time changing - it may be corrupted\n", pathB, hash.Bytes([]byte("b\n")), hash.Bytes([]byte("c\n"))),
+	)
+	assert.Contains(t, logs.String(), fmt.Sprintf("verified against previous scan of %q: 1 unchanged, 0 modified, 1 corrupted file(s)\n", rootPath))
+	assert.EqualError(t, checkVerification(res), "verification detected 1 corrupted file(s)")
+}
+
+func Test__checkVerification_without_corruption_passes(t *testing.T) {
+	assert.NoError(t, checkVerification(&scan.Result{}))
+	assert.NoError(t, checkVerification(&scan.Result{Verification: &scan.Verification{Unchanged: 1, Modified: 1}}))
+}
+
+func Test__Scan_verify_against_rejects_caches(t *testing.T) {
+	_, err := Scan("x", ScanArgs{VerifyAgainstPath: "y", CachePaths: []string{"z"}})
+	assert.EqualError(t, err, "cannot use caches when verifying against a previous scan")
+	_, err = Scan("x", ScanArgs{VerifyAgainstPath: "y", HashDBPath: "z"})
+	assert.EqualError(t, err, "cannot use caches when verifying against a previous scan")
+}
+
+func Test__Scan_wraps_previous_scan_load_error(t *testing.T) {
+	_, err := Scan("x", ScanArgs{VerifyAgainstPath: "missing"})
+	assert.EqualError(t, err, `cannot load previous scan file "missing": cannot open file: not found`)
 }
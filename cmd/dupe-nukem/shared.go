@@ -41,6 +41,25 @@ func loadScanResultFile(path string) (*scan.Result, error) {
 	return decodeScanResult(r)
 }
 
+// loadJSONFile decodes the (optionally gzipped) JSON file at the provided path into the provided value.
+// This is used for loading the output of commands other than "scan" (like "dupes" and "match").
+func loadJSONFile(path string, v interface{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(util.CleanIOError(err), "cannot open file")
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Printf("error: closing file %q failed: %v\n", path, err) // cannot test
+		}
+	}()
+	r, err := resolveReader(f)
+	if err != nil {
+		return errors.Wrap(err, "cannot resolve file reader")
+	}
+	return util.CleanJSONError(json.NewDecoder(r).Decode(v))
+}
+
 func decodeScanResult(r io.Reader) (*scan.Result, error) {
 	var res scan.Result
 	err := json.NewDecoder(r).Decode(&res)
@@ -57,3 +76,36 @@ func absPath(path string) (string, error) {
 	//}
 	return a, nil
 }
+
+// parsePathMap parses a list of path mapping expressions of the form "<from>=<to>".
+func parsePathMap(exprs []string) (scan.PathMap, error) {
+	var res scan.PathMap
+	for _, e := range exprs {
+		m, err := parsePathMapping(e)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid path mapping %q", e)
+		}
+		for _, n := range res {
+			if n.From == m.From {
+				return nil, errors.Errorf("duplicate mapping of path %q", m.From)
+			}
+		}
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+func parsePathMapping(expr string) (scan.PathMapping, error) {
+	parts := strings.SplitN(expr, "=", 2)
+	if len(parts) != 2 {
+		return scan.PathMapping{}, errors.Errorf("missing '='")
+	}
+	from, to := parts[0], parts[1]
+	if from == "" {
+		return scan.PathMapping{}, errors.Errorf("empty source path")
+	}
+	if to == "" {
+		return scan.PathMapping{}, errors.Errorf("empty target path")
+	}
+	return scan.PathMapping{From: filepath.Clean(from), To: filepath.Clean(to)}, nil
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -70,3 +72,38 @@ func Test__loadScanResultFile_wraps_scan_file_error(t *testing.T) {
 	_, err := loadScanResultFile(path)
 	assert.EqualError(t, err, "cannot resolve file reader: EOF")
 }
+
+func Test__parsePathMap(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	res, err := parsePathMap([]string{"/mnt/usb1=/media/backup", "/x/=y/./z"})
+	require.NoError(t, err)
+	want := scan.PathMap{
+		{From: p("/mnt/usb1"), To: p("/media/backup")},
+		{From: p("/x"), To: p("y/z")},
+	}
+	assert.Equal(t, want, res)
+}
+
+func Test__parsePathMap_empty_returns_nil(t *testing.T) {
+	res, err := parsePathMap(nil)
+	require.NoError(t, err)
+	assert.Nil(t, res)
+}
+
+func Test__parsePathMap_invalid_fails(t *testing.T) {
+	tests := []struct {
+		exprs   []string
+		wantErr string
+	}{
+		{exprs: []string{"x"}, wantErr: `invalid path mapping "x": missing '='`},
+		{exprs: []string{"=x"}, wantErr: `invalid path mapping "=x": empty source path`},
+		{exprs: []string{"x="}, wantErr: `invalid path mapping "x=": empty target path`},
+		{exprs: []string{"x=y", "x/=z"}, wantErr: `duplicate mapping of path "x"`},
+	}
+	for _, test := range tests {
+		t.Run(strings.Join(test.exprs, ","), func(t *testing.T) {
+			_, err := parsePathMap(test.exprs)
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"runtime/debug"
+)
+
+// version is the version of the application.
+// It's injected at build time using '-ldflags "-X main.version=<version>"' (see Makefile).
+var version string
+
+// toolVersion returns the injected version of the application if available
+// and otherwise falls back to the version of the main module as recorded by the Go toolchain.
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown" // cannot test
+}
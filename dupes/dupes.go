@@ -0,0 +1,311 @@
+// Package dupes implements the search for duplicate files (and directories) within one or more scans.
+package dupes
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/bisgardo/dupe-nukem/scan"
+	"github.com/bisgardo/dupe-nukem/util"
+)
+
+// Group is a group of files (or directories) with identical contents.
+type Group struct {
+	// Whether the group is of whole directories with identical contents (including names) rather than of files.
+	Dir bool `json:"dir,omitempty"`
+	// Size of each copy (the total size of the files in it for directories).
+	Size int64 `json:"size"`
+	// Hash of the contents of the files (0 for directories).
+	Hash uint64 `json:"hash,omitempty"`
+	// Number of (non-empty) files in each copy (only for directories).
+	Files int `json:"files,omitempty"`
+	// Paths of the copies (in the order of the scans and otherwise sorted).
+	Paths []string `json:"paths"`
+	// Paths of files that are hardlinks of one of the copies in Paths.
+	// They don't occupy any space of their own so aren't counted as copies.
+	Hardlinks []string `json:"hardlinks,omitempty"`
+	// Number of bytes that would be reclaimed by removing all but one of the copies.
+	Reclaimable int64 `json:"reclaimable"`
+	// Path of the copy to keep and the rule that chose it (only when annotated with keep rules; see plan.AnnotateDupes).
+	Keep     string `json:"keep,omitempty"`
+	KeepRule string `json:"keep_rule,omitempty"`
+}
+
+// Result is the result of searching for duplicates (see Find).
+type Result struct {
+	// Roots are the names of the roots of the scans.
+	Roots []string `json:"roots"`
+	// Groups lists the groups of duplicates sorted by reclaimable bytes (largest first).
+	// Directories whose subtrees are identical are represented by a single group rather than one for each file in them.
+	Groups []*Group `json:"groups"`
+	// Reclaimable is the total number of bytes that would be reclaimed by removing all but one copy of every file.
+	// Unlike the sum of the groups, this doesn't count the files in nested directory groups more than once.
+	Reclaimable int64 `json:"reclaimable"`
+}
+
+// contentKey identifies the contents of a file.
+type contentKey struct {
+	size int64
+	hash uint64
+}
+
+// dirKey identifies the contents of a directory.
+type dirKey struct {
+	size  int64
+	files int
+	hash  uint64
+}
+
+// dirInfo is a directory of a scan.
+type dirInfo struct {
+	path   string
+	dir    *scan.Dir
+	parent *dirInfo
+	// Depth of the directory below the root of its scan.
+	depth int
+	// Total size and number of non-empty files in the subtree.
+	size  int64
+	files int
+	// Reported group of directories with the same contents
+	// (which is the group of the parent if the group of the directory is implied by it).
+	group *dirGroup
+}
+
+func (d *dirInfo) key() dirKey {
+	return dirKey{size: d.size, files: d.files, hash: d.dir.Hash}
+}
+
+// dirGroup is a group of directories with the same contents.
+type dirGroup struct {
+	dirs []*dirInfo
+	*Group
+}
+
+// fileInfo is a file of a scan.
+type fileInfo struct {
+	path string
+	file *scan.File
+	dir  *dirInfo
+}
+
+// finder accumulates the files and directories of the scans.
+type finder struct {
+	files    map[contentKey][]*fileInfo
+	fileKeys []contentKey
+	dirs     map[dirKey][]*dirInfo
+	dirKeys  []dirKey
+}
+
+// Find finds the groups of duplicate files in the provided scan roots.
+// Files are grouped by their size and hash; empty files and files that couldn't be hashed (or were unstable) are ignored.
+// Files that are hardlinks of each other (see scan.File.IsHardlinkOf) are only counted once.
+// If all copies of some files are located in directories whose whole subtrees are identical,
+// the directories are reported as a single group instead (using the outermost such directories).
+// The directory hashes of the roots are (re)computed using scan.ComputeDirHashes.
+// The roots must not overlap.
+func Find(roots []*scan.Dir) (*Result, error) {
+	for i, r := range roots {
+		for _, s := range roots[:i] {
+			if util.IsSubpath(s.Name, r.Name) || util.IsSubpath(r.Name, s.Name) {
+				return nil, fmt.Errorf("scans of directories %q and %q overlap", s.Name, r.Name)
+			}
+		}
+	}
+	f := &finder{files: make(map[contentKey][]*fileInfo), dirs: make(map[dirKey][]*dirInfo)}
+	res := &Result{Roots: []string{}, Groups: []*Group{}}
+	for _, r := range roots {
+		res.Roots = append(res.Roots, r.Name)
+		scan.ComputeDirHashes(r)
+		f.addDir(r, r.Name, nil)
+	}
+	f.sortDirKeys()
+	for _, k := range f.dirKeys {
+		if g := f.dirGroup(f.dirs[k]); g != nil {
+			res.Groups = append(res.Groups, g.Group)
+		}
+	}
+	for _, k := range f.fileKeys {
+		g := fileGroup(k, f.files[k])
+		if g == nil {
+			continue
+		}
+		res.Reclaimable += g.Reclaimable
+		if !coveredByDirGroup(f.files[k]) {
+			res.Groups = append(res.Groups, g)
+		}
+	}
+	sort.SliceStable(res.Groups, func(i, j int) bool {
+		return res.Groups[i].Reclaimable > res.Groups[j].Reclaimable
+	})
+	return res, nil
+}
+
+// addDir adds the files and subdirectories of the provided Dir (with the provided path).
+// Directories are grouped by their hash (see scan.ComputeDirHashes) which is only known if their contents are fully known.
+func (f *finder) addDir(d *scan.Dir, path string, parent *dirInfo) *dirInfo {
+	info := &dirInfo{path: path, dir: d, parent: parent}
+	if parent != nil {
+		info.depth = parent.depth + 1
+	}
+	for _, file := range d.Files {
+		if !file.Unhashed && !file.Unstable {
+			k := contentKey{size: file.Size, hash: file.Hash}
+			if _, ok := f.files[k]; !ok {
+				f.fileKeys = append(f.fileKeys, k)
+			}
+			f.files[k] = append(f.files[k], &fileInfo{path: filepath.Join(path, file.Name), file: file, dir: info})
+		}
+		info.size += file.Size
+		info.files++
+	}
+	for _, s := range d.Dirs {
+		sub := f.addDir(s, filepath.Join(path, s.Name), info)
+		info.size += sub.size
+		info.files += sub.files
+	}
+	if d.Hash != 0 && info.size > 0 {
+		k := info.key()
+		if _, ok := f.dirs[k]; !ok {
+			f.dirKeys = append(f.dirKeys, k)
+		}
+		f.dirs[k] = append(f.dirs[k], info)
+	}
+	return info
+}
+
+// sortDirKeys sorts the keys of the directories such that the groups of parents are determined before those of their children:
+// Parents are at least as large as their subdirectories, and closer to the root if they have the same size.
+func (f *finder) sortDirKeys() {
+	minDepth := func(k dirKey) int {
+		res := -1
+		for _, d := range f.dirs[k] {
+			if res == -1 || d.depth < res {
+				res = d.depth
+			}
+		}
+		return res
+	}
+	sort.SliceStable(f.dirKeys, func(i, j int) bool {
+		ki, kj := f.dirKeys[i], f.dirKeys[j]
+		if ki.size != kj.size {
+			return ki.size > kj.size
+		}
+		return minDepth(ki) < minDepth(kj)
+	})
+}
+
+// dirGroup returns the group of the provided directories with identical contents
+// or nil if it shouldn't be reported.
+// This is the case if there aren't multiple directories, if all of them are hardlinked copies,
+// or if the group is implied by a group of their parents (which is reported instead).
+// The groups of any parents must already have been determined (see sortDirKeys).
+func (f *finder) dirGroup(ds []*dirInfo) *dirGroup {
+	if len(ds) < 2 {
+		return nil
+	}
+	if impliedByParents(ds) {
+		// Associate the directories with the group of their parents such that the groups of their subdirectories are also implied.
+		for _, d := range ds {
+			d.group = d.parent.group
+		}
+		return nil
+	}
+	g := &dirGroup{dirs: ds, Group: &Group{Dir: true, Size: ds[0].size, Files: ds[0].files}}
+	for _, d := range ds {
+		g.Paths = append(g.Paths, d.path)
+	}
+	for _, d := range ds[1:] {
+		g.Reclaimable += unlinkedSize(ds[0].dir, d.dir)
+	}
+	if g.Reclaimable == 0 {
+		return nil
+	}
+	for _, d := range ds {
+		d.group = g
+	}
+	return g
+}
+
+// impliedByParents returns whether the parents of the provided directories with identical contents
+// make up a reported group of the same size (i.e. whether each directory is the corresponding subdirectory of such a parent).
+func impliedByParents(ds []*dirInfo) bool {
+	p := ds[0].parent
+	if p == nil || p.group == nil || len(p.group.dirs) != len(ds) {
+		return false
+	}
+	for _, d := range ds[1:] {
+		if d.parent == nil || d.parent.group != p.group {
+			return false
+		}
+	}
+	return true
+}
+
+// unlinkedSize returns the total size of the files in the subtree of the provided Dir
+// that aren't hardlinks of the corresponding files in the subtree of the provided original Dir with identical contents.
+func unlinkedSize(orig, d *scan.Dir) int64 {
+	var res int64
+	for i, f := range d.Files {
+		if !f.IsHardlinkOf(orig.Files[i]) {
+			res += f.Size
+		}
+	}
+	for i, s := range d.Dirs {
+		res += unlinkedSize(orig.Dirs[i], s)
+	}
+	return res
+}
+
+// fileGroup returns the group of the provided files with the same contents
+// or nil if there aren't multiple copies of them that aren't hardlinks of each other.
+func fileGroup(k contentKey, fs []*fileInfo) *Group {
+	g := &Group{Size: k.size, Hash: k.hash}
+	var copies []*scan.File
+	for _, f := range fs {
+		if isHardlinkOfAny(f.file, copies) {
+			g.Hardlinks = append(g.Hardlinks, f.path)
+			continue
+		}
+		copies = append(copies, f.file)
+		g.Paths = append(g.Paths, f.path)
+	}
+	if len(copies) < 2 {
+		return nil
+	}
+	g.Reclaimable = k.size * int64(len(copies)-1)
+	return g
+}
+
+func isHardlinkOfAny(f *scan.File, fs []*scan.File) bool {
+	for _, g := range fs {
+		if f.IsHardlinkOf(g) {
+			return true
+		}
+	}
+	return false
+}
+
+// coveredByDirGroup returns whether all the provided files are located in directories of the same reported group,
+// in which case they're already reported as part of that group.
+// For each file, the innermost reported directory containing it is used.
+func coveredByDirGroup(fs []*fileInfo) bool {
+	var group *dirGroup
+	for _, f := range fs {
+		g := innermostGroup(f.dir)
+		if g == nil || group != nil && g != group {
+			return false
+		}
+		group = g
+	}
+	return true
+}
+
+func innermostGroup(d *dirInfo) *dirGroup {
+	for ; d != nil; d = d.parent {
+		if d.group != nil {
+			return d.group
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package dupes
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/scan"
+)
+
+func file(name string, size int64, hash uint64) *scan.File {
+	return scan.NewFile(name, size, 0, hash)
+}
+
+func Test__Find_groups_files_by_size_and_hash(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	root := &scan.Dir{
+		Name: p("/x"),
+		Dirs: []*scan.Dir{
+			{Name: "d", Files: []*scan.File{file("a", 1, 1), file("b", 20, 2)}},
+			{Name: "e", Files: []*scan.File{file("c", 20, 2), file("u", 3, 3)}, EmptyFiles: []string{"e"}},
+		},
+		Files: []*scan.File{
+			file("a", 1, 1),
+			file("b", 20, 2),
+			file("c", 3, 30), // same size but different hash as "u"
+			{Name: "u", Size: 3, Hash: 3, Unhashed: true},
+		},
+		EmptyFiles: []string{"e"},
+	}
+	res, err := Find([]*scan.Dir{root})
+	require.NoError(t, err)
+	assert.Equal(t, &Result{
+		Roots: []string{p("/x")},
+		Groups: []*Group{
+			{Size: 20, Hash: 2, Paths: []string{p("/x/b"), p("/x/d/b"), p("/x/e/c")}, Reclaimable: 40},
+			{Size: 1, Hash: 1, Paths: []string{p("/x/a"), p("/x/d/a")}, Reclaimable: 1},
+		},
+		Reclaimable: 41,
+	}, res)
+}
+
+func Test__Find_does_not_count_hardlinks(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	root := &scan.Dir{
+		Name: p("/x"),
+		Files: []*scan.File{
+			{Name: "a", Size: 2, Hash: 2, Device: 1, Inode: 10},
+			{Name: "b", Size: 2, Hash: 2, Device: 1, Inode: 10},
+			{Name: "c", Size: 2, Hash: 2, Device: 1, Inode: 11},
+			{Name: "d", Size: 3, Hash: 3, Device: 1, Inode: 12},
+			{Name: "e", Size: 3, Hash: 3, Device: 1, Inode: 12},
+		},
+	}
+	res, err := Find([]*scan.Dir{root})
+	require.NoError(t, err)
+	assert.Equal(t, &Result{
+		Roots: []string{p("/x")},
+		Groups: []*Group{
+			{Size: 2, Hash: 2, Paths: []string{p("/x/a"), p("/x/c")}, Hardlinks: []string{p("/x/b")}, Reclaimable: 2},
+		},
+		Reclaimable: 2,
+	}, res)
+}
+
+func Test__Find_aggregates_identical_directories(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	subtree := func(name string) *scan.Dir {
+		return &scan.Dir{
+			Name: name,
+			Dirs: []*scan.Dir{
+				{Name: "s", Files: []*scan.File{file("a", 1, 1), file("b", 2, 2)}},
+			},
+			Files:      []*scan.File{file("c", 3, 3)},
+			EmptyFiles: []string{"e"},
+		}
+	}
+	incomplete := subtree("i")
+	incomplete.SkippedFiles = []string{"skipped"}
+	renamed := subtree("r")
+	renamed.Files[0].Name = "c2"
+	roots := []*scan.Dir{
+		{Name: p("/x"), Dirs: []*scan.Dir{subtree("d"), incomplete, renamed}, Files: []*scan.File{file("f", 10, 10)}},
+		{Name: p("/y"), Dirs: []*scan.Dir{subtree("d")}, Files: []*scan.File{file("a", 1, 1)}},
+	}
+	res, err := Find(roots)
+	require.NoError(t, err)
+	assert.Equal(t, &Result{
+		Roots: []string{p("/x"), p("/y")},
+		Groups: []*Group{
+			// Subdirectory "s" is identical in all copies (including the incomplete and renamed ones).
+			{Dir: true, Size: 3, Files: 2, Paths: []string{p("/x/d/s"), p("/x/i/s"), p("/x/r/s"), p("/y/d/s")}, Reclaimable: 9},
+			{Size: 3, Hash: 3, Paths: []string{p("/x/d/c"), p("/x/i/c"), p("/x/r/c2"), p("/y/d/c")}, Reclaimable: 9},
+			{Dir: true, Size: 6, Files: 3, Paths: []string{p("/x/d"), p("/y/d")}, Reclaimable: 6},
+			{Size: 1, Hash: 1, Paths: []string{p("/x/d/s/a"), p("/x/i/s/a"), p("/x/r/s/a"), p("/y/a"), p("/y/d/s/a")}, Reclaimable: 4},
+		},
+		Reclaimable: 3*2 + 3*3 + 1*4,
+	}, res)
+}
+
+func Test__Find_reports_only_outermost_identical_directories(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	subtree := func(name string) *scan.Dir {
+		return &scan.Dir{Name: name, Dirs: []*scan.Dir{
+			{Name: "s", Dirs: []*scan.Dir{
+				{Name: "t", Files: []*scan.File{file("a", 1, 1)}},
+			}},
+		}}
+	}
+	res, err := Find([]*scan.Dir{{Name: p("/x"), Dirs: []*scan.Dir{subtree("d"), subtree("e")}}})
+	require.NoError(t, err)
+	assert.Equal(t, []*Group{
+		{Dir: true, Size: 1, Files: 1, Paths: []string{p("/x/d"), p("/x/e")}, Reclaimable: 1},
+	}, res.Groups)
+}
+
+func Test__Find_does_not_report_hardlinked_directories(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	root := &scan.Dir{Name: p("/x"), Dirs: []*scan.Dir{
+		{Name: "d", Files: []*scan.File{{Name: "a", Size: 1, Hash: 1, Device: 1, Inode: 10}}},
+		{Name: "e", Files: []*scan.File{{Name: "a", Size: 1, Hash: 1, Device: 1, Inode: 10}}},
+	}}
+	res, err := Find([]*scan.Dir{root})
+	require.NoError(t, err)
+	assert.Empty(t, res.Groups)
+	assert.Zero(t, res.Reclaimable)
+}
+
+func Test__Find_overlapping_roots_fails(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	_, err := Find([]*scan.Dir{{Name: p("/x/y")}, {Name: p("/x")}})
+	assert.EqualError(t, err, fmt.Sprintf("scans of directories %q and %q overlap", p("/x/y"), p("/x")))
+}
@@ -0,0 +1,248 @@
+// Package hashdb implements a persistent cache of file hashes that isn't tied to the structure of any scanned directory.
+// It's intended to be shared by all scans on a host, such that moving or renaming files doesn't cause them to be rehashed.
+package hashdb
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/bisgardo/dupe-nukem/scan"
+	"github.com/bisgardo/dupe-nukem/util"
+)
+
+// CurrentTypeVersion is the currently expected value of the "schema_version" field of a database file.
+// Like for scan.CurrentResultTypeVersion, the value isn't going to be bumped before the application reaches a stable state.
+const CurrentTypeVersion = 1
+
+// DB is an in-memory cache of file hashes which can be loaded from and saved to a file.
+// Hashes are looked up by the device and inode numbers of the file if available (which survives renames and moves)
+// and otherwise by its path.
+// In either case, the size and modification time must match for the entry to be used
+// (the latter with the best precision that is known for both times, see scan.ModTimesMatch).
+//
+// The device number of removable disks may change between the times that they're mounted.
+// Such files are still found by path as long as the disk is mounted at the same location.
+type DB struct {
+	// Entries indexed by file path.
+	byPath map[string]*entry
+	// Entries indexed by device and inode numbers (for entries with available inode numbers only).
+	byID map[fileID]*entry
+	// Whether entries have been stored since the database was loaded.
+	modified bool
+}
+
+// entry is the serialized representation of a cached hash.
+type entry struct {
+	Path    string `json:"path"`
+	Device  uint64 `json:"dev,omitempty"`
+	Inode   uint64 `json:"ino,omitempty"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"ts"`
+	// Sub-second part of the modification time (see scan.File.ModTimeNanos).
+	ModTimeNanos int64  `json:"ts_nanos,omitempty"`
+	Hash         uint64 `json:"hash"`
+}
+
+// file is the serialized representation of a DB.
+type file struct {
+	TypeVersion int      `json:"schema_version"`
+	Entries     []*entry `json:"entries"`
+}
+
+type fileID struct {
+	device uint64
+	inode  uint64
+}
+
+var _ scan.HashCache = (*DB)(nil) // declare that DB conforms to scan.HashCache
+
+// New constructs an empty DB.
+func New() *DB {
+	return &DB{
+		byPath: make(map[string]*entry),
+		byID:   make(map[fileID]*entry),
+	}
+}
+
+// Load loads a DB from the file at the provided path.
+// If the file doesn't exist, an empty DB is returned.
+func Load(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return New(), nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(util.CleanIOError(err), "cannot open file")
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Printf("error: cannot close hash database file %q: %v\n", path, err) // cannot test
+		}
+	}()
+	return Decode(f)
+}
+
+// Decode decodes a DB from the provided reader.
+func Decode(r io.Reader) (*DB, error) {
+	var f file
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, util.CleanJSONError(err)
+	}
+	if f.TypeVersion == 0 {
+		return nil, errors.Errorf("schema version is missing")
+	}
+	if f.TypeVersion != CurrentTypeVersion {
+		return nil, errors.Errorf("unsupported schema version: %d", f.TypeVersion)
+	}
+	db := New()
+	for _, e := range f.Entries {
+		db.add(e)
+	}
+	return db, nil
+}
+
+// Len returns the number of entries in the DB.
+func (db *DB) Len() int {
+	return len(db.byPath)
+}
+
+// Modified returns whether any entries have been stored since the DB was constructed or loaded.
+func (db *DB) Modified() bool {
+	return db.modified
+}
+
+// Lookup returns the cached hash of the file identified by the provided key.
+func (db *DB) Lookup(key scan.FileKey) (uint64, bool) {
+	if key.Inode != 0 {
+		if e := db.byID[fileID{device: key.Device, inode: key.Inode}]; e != nil && e.matches(key) {
+			return e.Hash, true
+		}
+	}
+	if e := db.byPath[key.Path]; e != nil && e.matches(key) {
+		return e.Hash, true
+	}
+	return 0, false
+}
+
+// Store stores the hash of the file identified by the provided key,
+// replacing any existing entry of the same path or device and inode numbers.
+func (db *DB) Store(key scan.FileKey, hash uint64) {
+	if e := db.byPath[key.Path]; e != nil && e.matches(key) && e.ModTimeNanos == key.ModTimeNanos && e.Device == key.Device && e.Inode == key.Inode && e.Hash == hash {
+		return // already up-to-date
+	}
+	db.add(&entry{
+		Path:         key.Path,
+		Device:       key.Device,
+		Inode:        key.Inode,
+		Size:         key.Size,
+		ModTime:      key.ModTime,
+		ModTimeNanos: key.ModTimeNanos,
+		Hash:         hash,
+	})
+	db.modified = true
+}
+
+// add adds the provided entry, replacing any existing entry of the same path.
+// The entry replaces any existing entry of the same device and inode numbers in the index of such entries,
+// but an entry of another path is otherwise kept (as it may still be valid if the file is hardlinked).
+func (db *DB) add(e *entry) {
+	if old := db.byPath[e.Path]; old != nil && old.Inode != 0 {
+		id := fileID{device: old.Device, inode: old.Inode}
+		if db.byID[id] == old {
+			delete(db.byID, id)
+		}
+	}
+	db.byPath[e.Path] = e
+	if e.Inode != 0 {
+		db.byID[fileID{device: e.Device, inode: e.Inode}] = e
+	}
+}
+
+// Prune removes the entries of the files inside the provided directory (or the directory itself) that no longer exist
+// and returns the number of removed entries.
+// Entries are otherwise never removed, so this is intended to be called with the root of each scan
+// to prevent the database from growing indefinitely as files are deleted, moved, or renamed.
+// Entries outside the directory are left alone as they may be of files on disks that aren't currently mounted.
+func (db *DB) Prune(dir string) int {
+	dir = filepath.Clean(dir)
+	var res int
+	for p, e := range db.byPath {
+		if !util.IsSubpath(dir, p) {
+			continue
+		}
+		if _, err := os.Lstat(p); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		delete(db.byPath, p)
+		if e.Inode != 0 {
+			id := fileID{device: e.Device, inode: e.Inode}
+			if db.byID[id] == e {
+				delete(db.byID, id)
+			}
+		}
+		res++
+	}
+	if res > 0 {
+		db.modified = true
+	}
+	return res
+}
+
+func (e *entry) matches(key scan.FileKey) bool {
+	return e.Size == key.Size && scan.ModTimesMatch(e.ModTime, e.ModTimeNanos, key.ModTime, key.ModTimeNanos, 0)
+}
+
+// Encode writes the DB to the provided writer.
+// The entries are sorted by path to make the output deterministic.
+func (db *DB) Encode(w io.Writer) error {
+	f := file{TypeVersion: CurrentTypeVersion, Entries: make([]*entry, 0, len(db.byPath))}
+	for _, e := range db.byPath {
+		f.Entries = append(f.Entries, e)
+	}
+	sort.Slice(f.Entries, func(i, j int) bool {
+		return f.Entries[i].Path < f.Entries[j].Path
+	})
+	return json.NewEncoder(w).Encode(f)
+}
+
+// Save writes the DB to the file at the provided path.
+// The file is replaced atomically (by writing to a temporary file in the same directory and then renaming it)
+// such that concurrent scans cannot corrupt it, though entries stored by all but the last one to save are lost.
+// The permissions of an existing file are kept; a new file is only accessible by the owner.
+func (db *DB) Save(path string) error {
+	var perm os.FileMode
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(util.CleanIOError(err), "cannot create temporary file")
+	}
+	tmpPath := tmp.Name()
+	if err := db.Encode(tmp); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return errors.Wrap(util.CleanIOError(err), "cannot write temporary file") // cannot test
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return errors.Wrap(util.CleanIOError(err), "cannot close temporary file") // cannot test
+	}
+	if perm != 0 {
+		if err := os.Chmod(tmpPath, perm); err != nil {
+			_ = os.Remove(tmpPath)
+			return errors.Wrap(util.CleanIOError(err), "cannot set permissions of temporary file") // cannot test
+		}
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return errors.Wrap(util.CleanIOError(err), "cannot replace file") // cannot test
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package hashdb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/scan"
+	. "github.com/bisgardo/dupe-nukem/testutil"
+)
+
+func Test__empty_db_has_no_entries(t *testing.T) {
+	db := New()
+	assert.Equal(t, 0, db.Len())
+	assert.False(t, db.Modified())
+	_, ok := db.Lookup(scan.FileKey{Path: "x", Size: 1})
+	assert.False(t, ok)
+}
+
+func Test__lookup_by_id_survives_move(t *testing.T) {
+	db := New()
+	db.Store(scan.FileKey{Path: "a", Device: 1, Inode: 2, Size: 3, ModTime: 4}, 42)
+	assert.True(t, db.Modified())
+
+	h, ok := db.Lookup(scan.FileKey{Path: "b", Device: 1, Inode: 2, Size: 3, ModTime: 4})
+	assert.True(t, ok)
+	assert.Equal(t, uint64(42), h)
+}
+
+func Test__lookup_by_path_if_id_differs_or_is_unavailable(t *testing.T) {
+	db := New()
+	db.Store(scan.FileKey{Path: "a", Device: 1, Inode: 2, Size: 3, ModTime: 4}, 42)
+	db.Store(scan.FileKey{Path: "b", Size: 3, ModTime: 4}, 53)
+
+	// Device number changed (as may happen for removable disks).
+	h, ok := db.Lookup(scan.FileKey{Path: "a", Device: 7, Inode: 2, Size: 3, ModTime: 4})
+	assert.True(t, ok)
+	assert.Equal(t, uint64(42), h)
+	// No ID.
+	h, ok = db.Lookup(scan.FileKey{Path: "b", Size: 3, ModTime: 4})
+	assert.True(t, ok)
+	assert.Equal(t, uint64(53), h)
+}
+
+func Test__lookup_with_mismatching_size_or_mod_time_misses(t *testing.T) {
+	db := New()
+	db.Store(scan.FileKey{Path: "a", Device: 1, Inode: 2, Size: 3, ModTime: 4}, 42)
+
+	_, ok := db.Lookup(scan.FileKey{Path: "a", Device: 1, Inode: 2, Size: 5, ModTime: 4})
+	assert.False(t, ok)
+	_, ok = db.Lookup(scan.FileKey{Path: "a", Device: 1, Inode: 2, Size: 3, ModTime: 5})
+	assert.False(t, ok)
+}
+
+func Test__lookup_compares_mod_time_nanos_if_known(t *testing.T) {
+	db := New()
+	db.Store(scan.FileKey{Path: "a", Size: 3, ModTime: 4, ModTimeNanos: 5}, 42)
+	db.Store(scan.FileKey{Path: "b", Size: 3, ModTime: 4}, 53)
+
+	_, ok := db.Lookup(scan.FileKey{Path: "a", Size: 3, ModTime: 4, ModTimeNanos: 6})
+	assert.False(t, ok)
+	h, ok := db.Lookup(scan.FileKey{Path: "a", Size: 3, ModTime: 4, ModTimeNanos: 5})
+	assert.True(t, ok)
+	assert.Equal(t, uint64(42), h)
+	// Entry with unknown nanos (like one stored from a file system with second precision).
+	h, ok = db.Lookup(scan.FileKey{Path: "b", Size: 3, ModTime: 4, ModTimeNanos: 6})
+	assert.True(t, ok)
+	assert.Equal(t, uint64(53), h)
+}
+
+func Test__store_replaces_entry_of_same_path(t *testing.T) {
+	db := New()
+	db.Store(scan.FileKey{Path: "a", Device: 1, Inode: 2, Size: 3, ModTime: 4}, 42)
+	db.Store(scan.FileKey{Path: "a", Device: 1, Inode: 5, Size: 3, ModTime: 6}, 53)
+	assert.Equal(t, 1, db.Len())
+
+	// Old ID is no longer indexed.
+	_, ok := db.Lookup(scan.FileKey{Path: "b", Device: 1, Inode: 2, Size: 3, ModTime: 4})
+	assert.False(t, ok)
+	h, ok := db.Lookup(scan.FileKey{Path: "b", Device: 1, Inode: 5, Size: 3, ModTime: 6})
+	assert.True(t, ok)
+	assert.Equal(t, uint64(53), h)
+}
+
+func Test__storing_existing_entry_does_not_modify_db(t *testing.T) {
+	db, err := Decode(strings.NewReader(`{"schema_version":1,"entries":[{"path":"a","dev":1,"ino":2,"size":3,"ts":4,"hash":42}]}`))
+	require.NoError(t, err)
+	db.Store(scan.FileKey{Path: "a", Device: 1, Inode: 2, Size: 3, ModTime: 4}, 42)
+	assert.False(t, db.Modified())
+	db.Store(scan.FileKey{Path: "a", Device: 1, Inode: 2, Size: 3, ModTime: 4}, 43)
+	assert.True(t, db.Modified())
+}
+
+func Test__encode_sorts_entries_by_path(t *testing.T) {
+	db := New()
+	db.Store(scan.FileKey{Path: "b", Size: 3, ModTime: 4}, 53)
+	db.Store(scan.FileKey{Path: "a", Device: 1, Inode: 2, Size: 3, ModTime: 4}, 42)
+	var buf bytes.Buffer
+	err := db.Encode(&buf)
+	require.NoError(t, err)
+	assert.Equal(t,
+		`{"schema_version":1,"entries":[{"path":"a","dev":1,"ino":2,"size":3,"ts":4,"hash":42},{"path":"b","size":3,"ts":4,"hash":53}]}`+"\n",
+		buf.String(),
+	)
+}
+
+func Test__save_and_load_roundtrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.json")
+	db, err := Load(path) // file doesn't exist
+	require.NoError(t, err)
+	assert.Equal(t, 0, db.Len())
+
+	db.Store(scan.FileKey{Path: "a", Device: 1, Inode: 2, Size: 3, ModTime: 4}, 42)
+	db.Store(scan.FileKey{Path: "b", Size: 3, ModTime: 4}, 53)
+	err = db.Save(path)
+	require.NoError(t, err)
+
+	res, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, db.byPath, res.byPath)
+	assert.Equal(t, db.byID, res.byID)
+	assert.False(t, res.Modified())
+
+	// No temporary files are left behind.
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func Test__save_keeps_permissions_of_existing_file(t *testing.T) {
+	//goland:noinspection GoBoolExpressions
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows doesn't support file permissions.")
+	}
+	path := filepath.Join(t.TempDir(), "hashes.json")
+	err := New().Save(path)
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	err = os.Chmod(path, 0640)
+	require.NoError(t, err)
+	err = New().Save(path)
+	require.NoError(t, err)
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+}
+
+func Test__prune_removes_entries_of_missing_files_inside_dir(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "a")
+	err := os.WriteFile(existing, nil, 0644)
+	require.NoError(t, err)
+	missing := filepath.Join(dir, "b")
+	outside := filepath.Join(filepath.Dir(dir), filepath.Base(dir)+"x", "c")
+
+	db := New()
+	db.Store(scan.FileKey{Path: existing, Size: 3, ModTime: 4}, 42)
+	db.Store(scan.FileKey{Path: missing, Device: 1, Inode: 2, Size: 3, ModTime: 4}, 53)
+	db.Store(scan.FileKey{Path: outside, Size: 3, ModTime: 4}, 64)
+	db.modified = false
+
+	assert.Equal(t, 1, db.Prune(dir))
+	assert.True(t, db.Modified())
+	assert.Equal(t, 2, db.Len())
+	_, ok := db.Lookup(scan.FileKey{Path: existing, Size: 3, ModTime: 4})
+	assert.True(t, ok)
+	_, ok = db.Lookup(scan.FileKey{Path: "d", Device: 1, Inode: 2, Size: 3, ModTime: 4})
+	assert.False(t, ok)
+	_, ok = db.Lookup(scan.FileKey{Path: outside, Size: 3, ModTime: 4})
+	assert.True(t, ok)
+
+	db.modified = false
+	assert.Equal(t, 0, db.Prune(dir))
+	assert.False(t, db.Modified())
+}
+
+func Test__decode_invalid_fails(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{name: "invalid JSON", input: "{", wantErr: "invalid JSON: unexpected EOF"},
+		{name: "no version", input: `{"entries":[]}`, wantErr: "schema version is missing"},
+		{name: "unsupported version", input: `{"schema_version":2}`, wantErr: "unsupported schema version: 2"},
+		{
+			name:    "wrong type",
+			input:   `{"schema_version":"x"}`,
+			wantErr: `cannot decode field "schema_version" of type "int" with value of type "string"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Decode(strings.NewReader(test.input))
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
+
+func Test__load_inaccessible_file_fails(t *testing.T) {
+	path := TempStringFile(t, "{}")
+	MakeInaccessibleT(t, path)
+	_, err := Load(path)
+	assert.EqualError(t, err, "cannot open file: access denied")
+}
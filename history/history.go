@@ -0,0 +1,163 @@
+// Package history implements the timeline of how the files of a directory changed across a series of scans of it.
+package history
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bisgardo/dupe-nukem/changes"
+	"github.com/bisgardo/dupe-nukem/scan"
+	"github.com/bisgardo/dupe-nukem/util"
+)
+
+// Timeline is the history of the changes of the files of a directory across a series of scans (see Build).
+type Timeline struct {
+	// Root is the name of the (common) root of the scans.
+	Root string `json:"root"`
+	// Scans describes the scans of the series in chronological order.
+	Scans []*Scan `json:"scans"`
+	// Events lists the changes between the scans in chronological order
+	// and otherwise sorted by path (as given by changes.Compare).
+	Events []*Event `json:"events"`
+	// Roots of the scans for looking up the files of events.
+	roots []*scan.Dir
+}
+
+// Scan describes a scan of a series.
+type Scan struct {
+	// Time is the start time of the scan (nil if the scan doesn't include metadata).
+	Time *time.Time `json:"time,omitempty"`
+}
+
+// Event is a change of a file (or directory) that was first observed in some scan of a series.
+// Files that are present in the first scan are considered to have appeared no later than that,
+// so only subsequent changes are recorded.
+// Unchanged files don't produce any events.
+type Event struct {
+	// Scan is the index of the scan in which the change was first observed.
+	Scan int `json:"scan"`
+	*changes.Change
+	// Size and hash of the file (its old version if deleted, and zero for directories and empty files).
+	Size int64  `json:"size,omitempty"`
+	Hash uint64 `json:"hash,omitempty"`
+}
+
+// Build builds the timeline of the provided scan results with default options (see BuildWithOptions).
+func Build(results []*scan.Result) (*Timeline, error) {
+	return BuildWithOptions(results, changes.Options{})
+}
+
+// BuildWithOptions builds the timeline of the provided scan results in chronological order.
+// The roots of the scans must all have the same name (the caller may need to apply a scan.PathMap to ensure this).
+// The change between each pair of subsequent scans is determined using changes.CompareWithOptions
+// with the provided options.
+// If the start times of the scans are known, they're checked to be in chronological order.
+func BuildWithOptions(results []*scan.Result, opts changes.Options) (*Timeline, error) {
+	if len(results) < 2 {
+		return nil, fmt.Errorf("at least two scans are required")
+	}
+	t := &Timeline{Root: results[0].Root.Name, Events: []*Event{}}
+	var prevTime *time.Time
+	for i, r := range results {
+		s := &Scan{}
+		if r.Metadata != nil {
+			ts := r.Metadata.StartTime
+			s.Time = &ts
+			if prevTime != nil && ts.Before(*prevTime) {
+				return nil, fmt.Errorf("scan %d (started at %v) is older than scan %d (started at %v)", i, ts, i-1, *prevTime)
+			}
+			prevTime = &ts
+		}
+		t.Scans = append(t.Scans, s)
+		t.roots = append(t.roots, r.Root)
+		if i == 0 {
+			continue
+		}
+		cs, err := changes.CompareWithOptions(results[i-1].Root, r.Root, opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot compare scan %d with scan %d", i-1, i)
+		}
+		for _, c := range cs.Changes {
+			if c.Kind == changes.Unchanged {
+				continue
+			}
+			e := &Event{Scan: i, Change: c}
+			if f := t.file(e); f != nil {
+				e.Size, e.Hash = f.Size, f.Hash
+			}
+			t.Events = append(t.Events, e)
+		}
+	}
+	return t, nil
+}
+
+// file returns the file of the provided event
+// (from the scan preceding the event if the file was deleted, and nil if it's a directory or empty).
+func (t *Timeline) file(e *Event) *scan.File {
+	switch {
+	case e.Dir:
+		return nil
+	case e.Kind == changes.Deleted:
+		return scan.SafeFindFileByPath(t.roots[e.Scan-1], e.OldPath)
+	}
+	return scan.SafeFindFileByPath(t.roots[e.Scan], e.Path)
+}
+
+// FilterPath returns the events of the file or directory with the provided path (relative to the root),
+// including the events of all the files inside it.
+// An event of a file matches if either its new or old path matches.
+// An event of a directory also matches if the path is inside the directory.
+func (t *Timeline) FilterPath(path string) []*Event {
+	path = filepath.Clean(path)
+	res := []*Event{}
+	for _, e := range t.Events {
+		if isWithin(e.Path, path) || isWithin(e.OldPath, path) ||
+			e.Dir && (isWithin(path, e.Path) || isWithin(path, e.OldPath)) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// FilterHash returns the events of the files with the provided hash,
+// including events of directories that contain such files (in the scan of the event).
+func (t *Timeline) FilterHash(hash uint64) []*Event {
+	res := []*Event{}
+	for _, e := range t.Events {
+		if e.Hash == hash && !e.Dir || e.Dir && containsHash(scan.SafeFindDirByPath(t.roots[e.Scan], e.Path), hash) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// containsHash returns whether the provided Dir (which may be nil) or any of its subdirectories
+// contain a file with the provided hash.
+func containsHash(d *scan.Dir, hash uint64) bool {
+	if d == nil {
+		return false
+	}
+	for _, f := range d.Files {
+		if f.Hash == hash && !f.Unhashed {
+			return true
+		}
+	}
+	for _, s := range d.Dirs {
+		if containsHash(s, hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWithin returns whether the provided (clean, relative) path is equal to or nested inside the provided directory path.
+// The empty path is never within any directory.
+func isWithin(path, dir string) bool {
+	if path == "" {
+		return false
+	}
+	return dir == "." || util.IsSubpath(dir, path)
+}
@@ -0,0 +1,174 @@
+package history
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/changes"
+	"github.com/bisgardo/dupe-nukem/scan"
+)
+
+func file(name string, size int64, hash uint64) *scan.File {
+	return scan.NewFile(name, size, 0, hash)
+}
+
+func result(ts time.Time, root *scan.Dir) *scan.Result {
+	return &scan.Result{Root: root, Metadata: &scan.Metadata{StartTime: ts}}
+}
+
+// testResults returns a series of scans in which:
+// - "a" is modified in scan 1 and deleted in scan 2,
+// - "d" is renamed to "e" in scan 1 (as a whole) and "e/c" is added in scan 2.
+func testResults(ts time.Time) []*scan.Result {
+	return []*scan.Result{
+		result(ts, &scan.Dir{
+			Name:  "x",
+			Dirs:  []*scan.Dir{{Name: "d", Files: []*scan.File{file("b", 2, 2)}}},
+			Files: []*scan.File{file("a", 1, 1)},
+		}),
+		result(ts.Add(time.Hour), &scan.Dir{
+			Name:  "x",
+			Dirs:  []*scan.Dir{{Name: "e", Files: []*scan.File{file("b", 2, 2)}}},
+			Files: []*scan.File{file("a", 1, 10)},
+		}),
+		result(ts.Add(2*time.Hour), &scan.Dir{
+			Name: "x",
+			Dirs: []*scan.Dir{{Name: "e", Files: []*scan.File{file("b", 2, 2), file("c", 3, 3)}}},
+		}),
+	}
+}
+
+func Test__Build_records_events_of_subsequent_scans(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	ts := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	ts1, ts2 := ts.Add(time.Hour), ts.Add(2*time.Hour)
+
+	res, err := Build(testResults(ts))
+	require.NoError(t, err)
+	assert.Equal(t, "x", res.Root)
+	assert.Equal(t, []*Scan{{Time: &ts}, {Time: &ts1}, {Time: &ts2}}, res.Scans)
+	assert.Equal(t, []*Event{
+		{Scan: 1, Change: &changes.Change{Kind: changes.Modified, Path: "a"}, Size: 1, Hash: 10},
+		{Scan: 1, Change: &changes.Change{Kind: changes.Renamed, Path: "e", OldPath: "d", Dir: true, Files: 1}},
+		{Scan: 2, Change: &changes.Change{Kind: changes.Deleted, OldPath: "a"}, Size: 1, Hash: 10},
+		{Scan: 2, Change: &changes.Change{Kind: changes.Added, Path: p("e/c")}, Size: 3, Hash: 3},
+	}, res.Events)
+}
+
+func Test__BuildWithOptions_applies_mod_time_tolerance(t *testing.T) {
+	ts := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	results := []*scan.Result{
+		result(ts, &scan.Dir{Name: "x", Files: []*scan.File{{Name: "a", Size: 1, ModTime: 10, Unhashed: true}}}),
+		result(ts.Add(time.Hour), &scan.Dir{Name: "x", Files: []*scan.File{{Name: "a", Size: 1, ModTime: 11, Hash: 1}}}),
+	}
+
+	res, err := Build(results)
+	require.NoError(t, err)
+	assert.Equal(t, []*Event{{Scan: 1, Change: &changes.Change{Kind: changes.Modified, Path: "a"}, Size: 1, Hash: 1}}, res.Events)
+
+	res, err = BuildWithOptions(results, changes.Options{ModTimeTolerance: time.Second})
+	require.NoError(t, err)
+	assert.Empty(t, res.Events)
+}
+
+func Test__Timeline_FilterPath(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	res, err := Build(testResults(time.Now()))
+	require.NoError(t, err)
+
+	tests := []struct {
+		path      string
+		wantKinds []changes.Kind
+	}{
+		{path: ".", wantKinds: []changes.Kind{changes.Modified, changes.Renamed, changes.Deleted, changes.Added}},
+		{path: "a", wantKinds: []changes.Kind{changes.Modified, changes.Deleted}},
+		{path: "d", wantKinds: []changes.Kind{changes.Renamed}},      // old path of directory
+		{path: p("d/b"), wantKinds: []changes.Kind{changes.Renamed}}, // file inside directory
+		{path: "e", wantKinds: []changes.Kind{changes.Renamed, changes.Added}},
+		{path: p("e/c"), wantKinds: []changes.Kind{changes.Renamed, changes.Added}}, // inside renamed directory
+		{path: "f", wantKinds: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			var kinds []changes.Kind
+			for _, e := range res.FilterPath(test.path) {
+				kinds = append(kinds, e.Kind)
+			}
+			assert.Equal(t, test.wantKinds, kinds)
+		})
+	}
+}
+
+func Test__Timeline_FilterHash(t *testing.T) {
+	res, err := Build(testResults(time.Now()))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		hash      uint64
+		wantKinds []changes.Kind
+	}{
+		{name: "modified and deleted file", hash: 10, wantKinds: []changes.Kind{changes.Modified, changes.Deleted}},
+		{name: "file in renamed directory", hash: 2, wantKinds: []changes.Kind{changes.Renamed}},
+		{name: "added file", hash: 3, wantKinds: []changes.Kind{changes.Added}},
+		{name: "no longer present content", hash: 1, wantKinds: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var kinds []changes.Kind
+			for _, e := range res.FilterHash(test.hash) {
+				kinds = append(kinds, e.Kind)
+			}
+			assert.Equal(t, test.wantKinds, kinds)
+		})
+	}
+}
+
+func Test__Event_is_encoded_flat(t *testing.T) {
+	bs, err := json.Marshal(&Event{Scan: 1, Change: &changes.Change{Kind: changes.Renamed, Path: "b", OldPath: "a"}, Size: 2, Hash: 3})
+	require.NoError(t, err)
+	assert.Equal(t, `{"scan":1,"kind":"renamed","path":"b","old_path":"a","size":2,"hash":3}`, string(bs))
+}
+
+func Test__Timeline_without_events_is_encoded_with_empty_events(t *testing.T) {
+	ts := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	res, err := Build([]*scan.Result{result(ts, &scan.Dir{Name: "x"}), result(ts.Add(time.Hour), &scan.Dir{Name: "x"})})
+	require.NoError(t, err)
+	bs, err := json.Marshal(res)
+	require.NoError(t, err)
+	assert.Contains(t, string(bs), `"events":[]`)
+	assert.NotNil(t, res.FilterPath("a"))
+	assert.NotNil(t, res.FilterHash(1))
+}
+
+func Test__Build_fails(t *testing.T) {
+	ts := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		results []*scan.Result
+		wantErr string
+	}{
+		{name: "single scan", results: testResults(ts)[:1], wantErr: "at least two scans are required"},
+		{
+			name:    "scans out of order",
+			results: []*scan.Result{result(ts, &scan.Dir{Name: "x"}), result(ts.Add(-time.Hour), &scan.Dir{Name: "x"})},
+			wantErr: "scan 1 (started at 2006-01-02 14:04:05 +0000 UTC) is older than scan 0 (started at 2006-01-02 15:04:05 +0000 UTC)",
+		},
+		{
+			name:    "different roots",
+			results: []*scan.Result{result(ts, &scan.Dir{Name: "x"}), result(ts, &scan.Dir{Name: "y"})},
+			wantErr: `cannot compare scan 0 with scan 1: scans of different directories "x" and "y" cannot be compared`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Build(test.results)
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux || !(386 || amd64 || arm || arm64 || riscv64)
+// +build !linux !386,!amd64,!arm,!arm64,!riscv64
+
+package link
+
+import (
+	"os"
+)
+
+const reflinkSupported = false
+
+// clone isn't supported on this platform (Run checks reflinkSupported before calling it).
+func clone(*os.File, *os.File) error {
+	return errReflinkNotSupported
+}
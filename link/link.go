@@ -0,0 +1,283 @@
+// Package link implements replacing duplicate files with hardlinks or reflinks (clones)
+// of the copy that's kept, such that the space of the duplicates is reclaimed without removing any paths.
+// Both kinds of links require the files to be on the same file system,
+// so the groups of duplicates are split by the devices recorded in the scans (see ByDevice)
+// before building the plan (with action plan.Hardlink) that is executed by Run.
+package link
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/bisgardo/dupe-nukem/plan"
+	"github.com/bisgardo/dupe-nukem/scan"
+	"github.com/bisgardo/dupe-nukem/util"
+)
+
+var (
+	errReflinkNotSupported = fmt.Errorf("reflinks not supported by the file system")
+	errNotSameDevice       = fmt.Errorf("not on the same device")
+)
+
+// Entry is a file that has been (or would be) replaced by a link.
+type Entry struct {
+	// Path of the file that was replaced.
+	Path string `json:"path"`
+	// Keep is the path of the copy that the file now links to.
+	Keep string `json:"keep"`
+	// Size of the file.
+	Size int64 `json:"size"`
+	// Reclaimed is the number of bytes reclaimed by replacing the file
+	// (0 if the file has other hardlinks, since they still hold on to its contents).
+	Reclaimed int64 `json:"reclaimed"`
+}
+
+// Skip is a file that was skipped because it couldn't be verified or replaced.
+type Skip struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// Result is the result of Run.
+type Result struct {
+	// DryRun is true if no files have actually been replaced.
+	DryRun bool `json:"dry_run"`
+	// Reflink is true if files have been replaced by reflinks rather than hardlinks.
+	Reflink bool `json:"reflink,omitempty"`
+	// Entries lists the files that have been replaced.
+	Entries []*Entry `json:"entries"`
+	// Skipped lists the files that have not been replaced.
+	Skipped []*Skip `json:"skipped,omitempty"`
+	// Reclaimed is the total number of bytes reclaimed.
+	Reclaimed int64 `json:"reclaimed"`
+}
+
+// Options configures Run.
+type Options struct {
+	// Reflink enables replacing files with reflinks rather than hardlinks.
+	// Reflinks share contents (until either file is modified) but are otherwise independent files.
+	// They're only supported on Linux and only by some file systems (like btrfs and xfs).
+	Reflink bool
+	// DryRun enables verifying the files without replacing any of them.
+	DryRun bool
+}
+
+// ByDevice splits the copies of each of the provided groups by the device (i.e. file system) that they're on
+// as recorded in the scans, such that all copies of each of the returned groups are on the same device.
+// Groups with fewer than two copies left are dropped.
+// Copies whose files aren't all on the same known device cannot be linked and are returned separately.
+func ByDevice(groups []*plan.Group) ([]*plan.Group, []*plan.Copy) {
+	var res []*plan.Group
+	var unknown []*plan.Copy
+	for _, g := range groups {
+		var devs []uint64
+		byDev := make(map[uint64][]*plan.Copy)
+		for _, c := range g.Copies {
+			dev, ok := copyDevice(c)
+			if !ok {
+				unknown = append(unknown, c)
+				continue
+			}
+			if _, ok := byDev[dev]; !ok {
+				devs = append(devs, dev) // preserve order of copies
+			}
+			byDev[dev] = append(byDev[dev], c)
+		}
+		for _, dev := range devs {
+			if cs := byDev[dev]; len(cs) > 1 {
+				res = append(res, &plan.Group{Copies: cs})
+			}
+		}
+	}
+	return res, unknown
+}
+
+// copyDevice returns the device that all of the files of the provided copy are on (if known).
+func copyDevice(c *plan.Copy) (uint64, bool) {
+	var res uint64
+	for i, f := range c.Files {
+		if f.Device == 0 || i > 0 && f.Device != res {
+			return 0, false
+		}
+		res = f.Device
+	}
+	return res, res != 0
+}
+
+// Run replaces the files of the steps of the provided plan with links to their kept copies.
+// Before replacing a file, it's verified that both it and its kept copy are regular files of the size recorded in the plan,
+// that they're on the same device, and that their contents are identical byte for byte.
+// Steps that fail verification (or fail to be performed) are skipped and recorded in the returned result.
+// Files are replaced atomically by creating the link next to the file and then renaming it over the file.
+// The kept copy is never modified: Hardlinks share its inode (and thus its metadata),
+// while reflinks get the permissions and modification time of the file that they replace.
+func Run(p *plan.Plan, opts Options) (*Result, error) {
+	if p.Action != plan.Hardlink {
+		return nil, fmt.Errorf("cannot link files of plan with action %q", p.Action)
+	}
+	if opts.Reflink && !reflinkSupported {
+		return nil, fmt.Errorf("reflinks are not supported on this platform")
+	}
+	res := &Result{DryRun: opts.DryRun, Reflink: opts.Reflink, Entries: []*Entry{}}
+	for _, s := range p.Steps {
+		e, err := runStep(s, opts)
+		if err != nil {
+			res.Skipped = append(res.Skipped, &Skip{Path: s.Path, Reason: err.Error()})
+			continue
+		}
+		res.Entries = append(res.Entries, e)
+		res.Reclaimed += e.Reclaimed
+	}
+	return res, nil
+}
+
+func runStep(s *plan.Step, opts Options) (*Entry, error) {
+	info, err := stat(s.Path, s.Size)
+	if err != nil {
+		return nil, err
+	}
+	keepInfo, err := stat(s.Keep, s.Size)
+	if err != nil {
+		return nil, fmt.Errorf("kept copy %q: %v", s.Keep, err)
+	}
+	if os.SameFile(info, keepInfo) {
+		return nil, fmt.Errorf("already a hardlink of kept copy %q", s.Keep)
+	}
+	id, ok := scan.FileIDOf(info)
+	if keepID, keepOK := scan.FileIDOf(keepInfo); ok && keepOK && id.Device != keepID.Device {
+		return nil, fmt.Errorf("not on the same device as kept copy %q", s.Keep)
+	}
+	equal, err := equalContents(s.Path, s.Keep)
+	if err != nil {
+		return nil, err
+	}
+	if !equal {
+		return nil, fmt.Errorf("contents differ from kept copy %q", s.Keep)
+	}
+	e := &Entry{Path: s.Path, Keep: s.Keep, Size: s.Size}
+	if !ok || id.Links <= 1 {
+		e.Reclaimed = s.Size
+	}
+	if opts.DryRun {
+		return e, nil
+	}
+	tmpPath := filepath.Join(filepath.Dir(s.Path), "."+filepath.Base(s.Path)+".dupe-nukem")
+	if opts.Reflink {
+		err = reflink(s.Keep, tmpPath, info)
+	} else {
+		err = os.Link(s.Keep, tmpPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot link kept copy %q: %v", s.Keep, util.CleanIOError(err))
+	}
+	if err := os.Rename(tmpPath, s.Path); err != nil {
+		if err := os.Remove(tmpPath); err != nil {
+			log.Printf("error: cannot remove temporary file %q: %v\n", tmpPath, err) // cannot test
+		}
+		return nil, fmt.Errorf("cannot replace file: %v", util.CleanIOError(err)) // cannot test
+	}
+	return e, nil
+}
+
+// reflink creates a new file at the provided path as a clone of the file at the provided source path
+// and gives it the owner, permissions, and modification time of the provided file info.
+// Changing the owner fails unless it's the current user or the process is privileged,
+// in which case the file isn't replaced.
+// The new file is removed if any of this fails.
+func reflink(srcPath, path string, info os.FileInfo) (err error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := src.Close(); err != nil {
+			log.Printf("error: cannot close file %q: %v\n", srcPath, err) // cannot test
+		}
+	}()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if err := os.Remove(path); err != nil {
+				log.Printf("error: cannot remove temporary file %q: %v\n", path, err) // cannot test
+			}
+		}
+	}()
+	err = clone(f, src)
+	if err == nil {
+		if uid, gid, ok := ownerOf(info); ok {
+			err = f.Chown(uid, gid)
+		}
+	}
+	if err == nil {
+		// The mode is explicitly set (after changing the owner) as the one given on creation is subject to umask.
+		err = f.Chmod(info.Mode().Perm())
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+// stat checks that the file at the provided path is a regular file with the provided size and returns its info.
+func stat(path string, size int64) (os.FileInfo, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot stat file: %v", util.CleanIOError(err))
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("is a %v", util.FileModeName(info.Mode()))
+	}
+	if info.Size() != size {
+		return nil, fmt.Errorf("size has changed from %d to %d", size, info.Size())
+	}
+	return info, nil
+}
+
+// equalContents compares the contents of the files at the provided paths byte for byte.
+func equalContents(path1, path2 string) (bool, error) {
+	f1, err := os.Open(path1)
+	if err != nil {
+		return false, fmt.Errorf("cannot open file: %v", util.CleanIOError(err))
+	}
+	defer closeFile(f1)
+	f2, err := os.Open(path2)
+	if err != nil {
+		return false, fmt.Errorf("cannot open kept copy %q: %v", path2, util.CleanIOError(err))
+	}
+	defer closeFile(f2)
+	const bufSize = 64 * 1024
+	b1, b2 := make([]byte, bufSize), make([]byte, bufSize)
+	for {
+		n1, err1 := io.ReadFull(f1, b1)
+		n2, err2 := io.ReadFull(f2, b2)
+		if !bytes.Equal(b1[:n1], b2[:n2]) {
+			return false, nil
+		}
+		if err1 == io.EOF || err1 == io.ErrUnexpectedEOF {
+			// Both files ended at the same point as the read chunks are equal.
+			return err2 == io.EOF || err2 == io.ErrUnexpectedEOF, nil
+		}
+		if err1 != nil {
+			return false, fmt.Errorf("cannot read file: %v", err1) // cannot test
+		}
+		if err2 != nil {
+			return false, fmt.Errorf("cannot read kept copy %q: %v", path2, err2) // cannot test
+		}
+	}
+}
+
+func closeFile(f *os.File) {
+	if err := f.Close(); err != nil {
+		log.Printf("error: cannot close file %q: %v\n", f.Name(), err) // cannot test
+	}
+}
@@ -0,0 +1,54 @@
+package link
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The outcome of this test depends on whether the file system of the temporary directory supports reflinks.
+func Test__Run_replaces_file_with_reflink_or_leaves_it_untouched(t *testing.T) {
+	if !reflinkSupported {
+		t.Skip("reflinks are not supported on this architecture")
+	}
+	p := testPlan(t, t.TempDir(), "abc")
+	s := p.Steps[0]
+	modTime := time.Unix(1000, 0)
+	require.NoError(t, os.Chmod(s.Path, 0600))
+	require.NoError(t, os.Chtimes(s.Path, modTime, modTime))
+	before, err := os.Stat(s.Path)
+	require.NoError(t, err)
+
+	res, err := Run(p, Options{Reflink: true})
+	require.NoError(t, err)
+	assert.True(t, res.Reflink)
+	if len(res.Skipped) == 0 {
+		assert.Equal(t, []*Entry{{Path: s.Path, Keep: s.Keep, Size: 3, Reclaimed: 3}}, res.Entries)
+	} else {
+		assert.Empty(t, res.Entries)
+		assert.Equal(t, []*Skip{{Path: s.Path, Reason: fmt.Sprintf("cannot link kept copy %q: %v", s.Keep, errReflinkNotSupported)}}, res.Skipped)
+	}
+	// Either way, the file is a separate file with the original contents and metadata.
+	assertSameFile(t, false, s.Path, s.Keep)
+	bs, err := os.ReadFile(s.Path)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(bs))
+	info, err := os.Stat(s.Path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.Equal(t, modTime, info.ModTime())
+	uid, gid, ok := ownerOf(info)
+	require.True(t, ok)
+	wantUID, wantGID, _ := ownerOf(before)
+	assert.Equal(t, wantUID, uid)
+	assert.Equal(t, wantGID, gid)
+	// No temporary files are left behind.
+	entries, err := os.ReadDir(filepath.Dir(s.Path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,156 @@
+package link
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/plan"
+)
+
+func writeFile(t *testing.T, path, contents string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0644))
+}
+
+// testPlan creates the files "x/a" and "y/a" with the provided contents in the provided directory
+// and returns the plan of replacing the former with a link to the latter.
+func testPlan(t *testing.T, dir, contents string) *plan.Plan {
+	path, keep := filepath.Join(dir, "x", "a"), filepath.Join(dir, "y", "a")
+	writeFile(t, path, contents)
+	writeFile(t, keep, contents)
+	return &plan.Plan{
+		Action: plan.Hardlink,
+		Steps:  []*plan.Step{{Path: path, Keep: keep, Size: int64(len(contents))}},
+		Bytes:  int64(len(contents)),
+	}
+}
+
+func Test__ByDevice_splits_groups_by_device(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	fileCopy := func(path string, dev uint64) *plan.Copy {
+		return &plan.Copy{Path: path, Files: []*plan.File{{Path: path, Size: 1, Hash: 1, Device: dev}}}
+	}
+	a1, b1 := fileCopy(p("/a/1"), 1), fileCopy(p("/b/1"), 2)
+	a2, b2, c2, d2, e2 := fileCopy(p("/a/2"), 1), fileCopy(p("/b/2"), 2), fileCopy(p("/c/2"), 0), fileCopy(p("/d/2"), 2), fileCopy(p("/e/2"), 1)
+	mixed := &plan.Copy{Path: p("/f"), Files: []*plan.File{{Path: p("/f/x"), Device: 1}, {Path: p("/f/y"), Device: 2}}}
+	groups := []*plan.Group{
+		{Copies: []*plan.Copy{a1, b1}}, // no copies on the same device
+		{Copies: []*plan.Copy{a2, b2, c2, mixed, d2, e2}},
+	}
+
+	res, unknown := ByDevice(groups)
+	assert.Equal(t, []*plan.Group{{Copies: []*plan.Copy{a2, e2}}, {Copies: []*plan.Copy{b2, d2}}}, res)
+	assert.Equal(t, []*plan.Copy{c2, mixed}, unknown)
+}
+
+func Test__Run_dry_run_replaces_nothing(t *testing.T) {
+	p := testPlan(t, t.TempDir(), "abc")
+	s := p.Steps[0]
+
+	res, err := Run(p, Options{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, &Result{
+		DryRun:    true,
+		Entries:   []*Entry{{Path: s.Path, Keep: s.Keep, Size: 3, Reclaimed: 3}},
+		Reclaimed: 3,
+	}, res)
+	assertSameFile(t, false, s.Path, s.Keep)
+}
+
+func Test__Run_replaces_file_with_hardlink(t *testing.T) {
+	p := testPlan(t, t.TempDir(), "abc")
+	s := p.Steps[0]
+	modTime := time.Unix(1000, 0)
+	require.NoError(t, os.Chtimes(s.Keep, modTime, modTime))
+
+	res, err := Run(p, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, &Result{Entries: []*Entry{{Path: s.Path, Keep: s.Keep, Size: 3, Reclaimed: 3}}, Reclaimed: 3}, res)
+	assertSameFile(t, true, s.Path, s.Keep)
+	info, err := os.Stat(s.Keep)
+	require.NoError(t, err)
+	assert.Equal(t, modTime, info.ModTime())
+	// No temporary files are left behind.
+	entries, err := os.ReadDir(filepath.Dir(s.Path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// Running the plan again doesn't do anything.
+	res, err = Run(p, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, []*Skip{{Path: s.Path, Reason: fmt.Sprintf("already a hardlink of kept copy %q", s.Keep)}}, res.Skipped)
+}
+
+func Test__Run_skips_changed_files(t *testing.T) {
+	tests := []struct {
+		name       string
+		change     func(t *testing.T, s *plan.Step)
+		wantReason func(s *plan.Step) string
+	}{
+		{
+			name:       "file removed",
+			change:     func(t *testing.T, s *plan.Step) { require.NoError(t, os.Remove(s.Path)) },
+			wantReason: func(*plan.Step) string { return "cannot stat file: not found" },
+		},
+		{
+			name:       "file size changed",
+			change:     func(t *testing.T, s *plan.Step) { writeFile(t, s.Path, "abcd") },
+			wantReason: func(*plan.Step) string { return "size has changed from 3 to 4" },
+		},
+		{
+			name:       "file contents changed",
+			change:     func(t *testing.T, s *plan.Step) { writeFile(t, s.Path, "abd") },
+			wantReason: func(s *plan.Step) string { return fmt.Sprintf("contents differ from kept copy %q", s.Keep) },
+		},
+		{
+			name: "kept copy replaced by directory",
+			change: func(t *testing.T, s *plan.Step) {
+				require.NoError(t, os.Remove(s.Keep))
+				require.NoError(t, os.Mkdir(s.Keep, 0755))
+			},
+			wantReason: func(s *plan.Step) string { return fmt.Sprintf("kept copy %q: is a directory", s.Keep) },
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := testPlan(t, t.TempDir(), "abc")
+			s := p.Steps[0]
+			test.change(t, s)
+
+			res, err := Run(p, Options{})
+			require.NoError(t, err)
+			assert.Equal(t, &Result{Entries: []*Entry{}, Skipped: []*Skip{{Path: s.Path, Reason: test.wantReason(s)}}}, res)
+		})
+	}
+}
+
+func Test__Run_compares_contents_beyond_first_chunk(t *testing.T) {
+	contents := make([]byte, 3*64*1024+1)
+	p := testPlan(t, t.TempDir(), string(contents))
+	s := p.Steps[0]
+	contents[len(contents)-1] = 1
+	writeFile(t, s.Path, string(contents))
+
+	res, err := Run(p, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, []*Skip{{Path: s.Path, Reason: fmt.Sprintf("contents differ from kept copy %q", s.Keep)}}, res.Skipped)
+}
+
+func Test__Run_plan_with_other_action_fails(t *testing.T) {
+	_, err := Run(&plan.Plan{Action: plan.Remove}, Options{})
+	assert.EqualError(t, err, `cannot link files of plan with action "remove"`)
+}
+
+func assertSameFile(t *testing.T, want bool, path1, path2 string) {
+	info1, err := os.Stat(path1)
+	require.NoError(t, err)
+	info2, err := os.Stat(path2)
+	require.NoError(t, err)
+	assert.Equal(t, want, os.SameFile(info1, info2))
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package link
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test__Run_reclaims_nothing_for_file_with_other_hardlinks(t *testing.T) {
+	dir := t.TempDir()
+	p := testPlan(t, dir, "abc")
+	s := p.Steps[0]
+	require.NoError(t, os.Link(s.Path, filepath.Join(dir, "other")))
+
+	res, err := Run(p, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, &Result{Entries: []*Entry{{Path: s.Path, Keep: s.Keep, Size: 3}}}, res)
+	assertSameFile(t, true, s.Path, s.Keep)
+}
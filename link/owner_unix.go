@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package link
+
+import (
+	"os"
+	"syscall"
+)
+
+// ownerOf extracts the user and group IDs of the owner of a file from its info.
+// The boolean return value indicates whether this information is available.
+func ownerOf(info os.FileInfo) (int, int, bool) {
+	s, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false // cannot test
+	}
+	return int(s.Uid), int(s.Gid), true
+}
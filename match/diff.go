@@ -0,0 +1,74 @@
+package match
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/bisgardo/dupe-nukem/scan"
+)
+
+// DiffResult is the result of diffing a source scan against target scans (see Diff).
+type DiffResult struct {
+	// Source is the name of the root of the source scan.
+	Source string `json:"source"`
+	// Targets are the names of the roots of the target scans.
+	Targets []string `json:"targets"`
+	// Mode is the mode that files were matched in.
+	Mode Mode `json:"mode"`
+	// Files is the number of files of the source scan that were compared.
+	Files int `json:"files"`
+	// Missing lists the paths (relative to the source root) of the files of the source scan
+	// that aren't matched by any file of the target scans, sorted by path.
+	Missing []string `json:"missing"`
+	// MissingBytes is the total size of the missing files.
+	MissingBytes int64 `json:"missing_bytes"`
+	// Unhashed lists the paths (relative to the source root) of the files of the source scan
+	// that couldn't be compared because their hash isn't known, sorted by path.
+	Unhashed []string `json:"unhashed,omitempty"`
+}
+
+// Diff finds the files of the source root that aren't present in any of the target roots.
+// A file is present if any target root contains a file with the same contents (size and hash)
+// whose (normalized) path relative to the target root corresponds to the path of the file relative to the source root
+// as required by the configured mode.
+// Unlike with Match, empty files are included as files with no contents (such that only their paths are matched).
+// Files that couldn't be hashed (or were unstable) are reported separately.
+// Only the mode and name normalization fields of the provided options are used.
+func Diff(source *scan.Dir, targets []*scan.Dir, opts Options) (*DiffResult, error) {
+	if err := opts.validateMode(); err != nil {
+		return nil, err
+	}
+	cmp := newComparer(opts)
+	res := &DiffResult{Source: source.Name, Targets: []string{}, Mode: cmp.mode, Missing: []string{}}
+	present := make(map[locKey]bool)
+	for _, t := range targets {
+		res.Targets = append(res.Targets, t.Name)
+		for _, e := range cmp.entries(t, true) {
+			present[cmp.locKey(e)] = true
+		}
+	}
+	for _, e := range cmp.entries(source, true) {
+		res.Files++
+		if !present[cmp.locKey(e)] {
+			res.Missing = append(res.Missing, e.path)
+			res.MissingBytes += e.key.size
+		}
+	}
+	res.Unhashed = unhashed(source, "", nil)
+	sort.Strings(res.Missing)
+	sort.Strings(res.Unhashed)
+	return res, nil
+}
+
+// unhashed returns the paths of the non-empty files of the provided directory (recursively) whose hash isn't known.
+func unhashed(d *scan.Dir, path string, res []string) []string {
+	for _, f := range d.Files {
+		if _, ok := keyOf(f); !ok && f.Size != 0 {
+			res = append(res, filepath.Join(path, f.Name))
+		}
+	}
+	for _, s := range d.Dirs {
+		res = unhashed(s, filepath.Join(path, s.Name), res)
+	}
+	return res
+}
@@ -0,0 +1,75 @@
+package match
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/scan"
+)
+
+func Test__Diff_reports_files_missing_from_all_targets(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	source := &scan.Dir{
+		Name: p("/src"),
+		Dirs: []*scan.Dir{
+			{Name: "s", Files: []*scan.File{file("b", 20, 2), file("c", 30, 3)}, EmptyFiles: []string{"e"}},
+		},
+		Files: []*scan.File{file("a", 10, 1), {Name: "u", Size: 5, Unhashed: true}},
+	}
+	targets := []*scan.Dir{
+		{
+			Name:  p("/t1"),
+			Dirs:  []*scan.Dir{{Name: "s", Files: []*scan.File{file("x", 20, 2)}}}, // renamed
+			Files: []*scan.File{file("a", 10, 1)},
+		},
+		{
+			Name:       p("/t2"),
+			Dirs:       []*scan.Dir{{Name: "d", Files: []*scan.File{file("c", 30, 3)}}}, // moved
+			EmptyFiles: []string{"e"},                                                   // moved
+		},
+	}
+	tests := []struct {
+		mode             Mode
+		wantMissing      []string
+		wantMissingBytes int64
+	}{
+		{mode: ModeExact, wantMissing: []string{p("s/b"), p("s/c"), p("s/e")}, wantMissingBytes: 50},
+		{mode: ModeSameDir, wantMissing: []string{p("s/c"), p("s/e")}, wantMissingBytes: 30},
+		{mode: ModeSameName, wantMissing: []string{p("s/b")}, wantMissingBytes: 20},
+		{mode: ModeAnywhere, wantMissing: []string{}},
+	}
+	for _, test := range tests {
+		t.Run(string(test.mode), func(t *testing.T) {
+			res, err := Diff(source, targets, Options{Mode: test.mode})
+			require.NoError(t, err)
+			assert.Equal(t, &DiffResult{
+				Source:       p("/src"),
+				Targets:      []string{p("/t1"), p("/t2")},
+				Mode:         test.mode,
+				Files:        4,
+				Missing:      test.wantMissing,
+				MissingBytes: test.wantMissingBytes,
+				Unhashed:     []string{"u"},
+			}, res)
+		})
+	}
+}
+
+func Test__Diff_normalizes_names(t *testing.T) {
+	source := &scan.Dir{Name: "x", Files: []*scan.File{file("\u00c4", 10, 1)}}  // "Ä" (composed)
+	target := &scan.Dir{Name: "y", Files: []*scan.File{file("a\u0308", 10, 1)}} // "ä" (decomposed)
+	res, err := Diff(source, []*scan.Dir{target}, Options{Mode: ModeExact})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"\u00c4"}, res.Missing)
+	res, err = Diff(source, []*scan.Dir{target}, Options{Mode: ModeExact, IgnoreCase: true, NormalizeUnicode: true})
+	require.NoError(t, err)
+	assert.Empty(t, res.Missing)
+}
+
+func Test__Diff_invalid_mode_fails(t *testing.T) {
+	_, err := Diff(&scan.Dir{Name: "x"}, nil, Options{Mode: "x"})
+	assert.EqualError(t, err, `invalid mode "x" (valid modes are ["exact" "same-dir" "same-name" "anywhere"])`)
+}
@@ -0,0 +1,455 @@
+// Package match implements the search for directories of a source scan in the directories of target scans,
+// including directories that are only similar (like half-synced copies where one has a few extra or edited files).
+package match
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+
+	"github.com/bisgardo/dupe-nukem/scan"
+	"github.com/bisgardo/dupe-nukem/util"
+)
+
+// Options configures Match.
+type Options struct {
+	// MinScore is the minimum similarity score (between 0 and 1) of the candidates to report.
+	MinScore float64
+	// MaxCandidates is the maximum number of candidates to report for each source directory (0 for no limit).
+	MaxCandidates int
+	// Mode determines how the locations of files with the same contents must correspond for them to match
+	// (defaults to ModeAnywhere).
+	Mode Mode
+	// IgnoreCase makes names match regardless of case.
+	IgnoreCase bool
+	// NormalizeUnicode makes names match regardless of Unicode normalization form (like NFC and NFD).
+	NormalizeUnicode bool
+}
+
+// DiffKind is the kind of a difference between a source directory and a candidate target directory.
+type DiffKind string
+
+// Kinds of differences.
+const (
+	// Missing is the kind of a file of the source directory whose contents aren't present in the target directory.
+	Missing DiffKind = "missing"
+	// Extra is the kind of a file of the target directory whose contents aren't present in the source directory.
+	Extra DiffKind = "extra"
+	// Modified is the kind of a file that exists with the same relative path in both directories,
+	// but whose contents (in either of them) aren't present in the other.
+	Modified DiffKind = "modified"
+)
+
+// Difference is a file that differs between a source directory and a candidate target directory.
+type Difference struct {
+	Kind DiffKind `json:"kind"`
+	// Path of the file relative to the directories.
+	Path string `json:"path"`
+}
+
+// Candidate is a target directory that is similar to a source directory.
+type Candidate struct {
+	// Target is the path of the target directory (including the root name of its scan).
+	Target string `json:"target"`
+	// Score is the similarity of the directories as the number of shared bytes divided by the total number of bytes
+	// of the union of their contents (i.e. the weighted Jaccard index of the contents).
+	// The score 1 means that the directories contain the same files (though possibly under different names unless the mode requires otherwise).
+	// It's only given if the contents of both directories are fully known (see scan.Dir.ContentHash);
+	// otherwise, the score of directories whose known contents are the same is the largest value below 1.
+	Score float64 `json:"score"`
+	// SharedBytes is the total size of the contents that are present in both directories.
+	SharedBytes int64 `json:"shared_bytes"`
+	// SourceBytes and TargetBytes are the total sizes of the files in the directories.
+	SourceBytes int64 `json:"source_bytes"`
+	TargetBytes int64 `json:"target_bytes"`
+	// Differences lists the files that differ between the directories, sorted by path.
+	Differences []*Difference `json:"differences,omitempty"`
+}
+
+// DirMatch is a source directory and its candidate matches.
+type DirMatch struct {
+	// Source is the path of the source directory (including the root name of the source scan).
+	Source string `json:"source"`
+	// Candidates lists the similar target directories with the most similar first.
+	Candidates []*Candidate `json:"candidates"`
+	// Path of the directory to keep among the source and its identical candidates and the rule that chose it
+	// (only when annotated with keep rules; see plan.AnnotateMatch).
+	Keep     string `json:"keep,omitempty"`
+	KeepRule string `json:"keep_rule,omitempty"`
+}
+
+// Result is the result of matching a source scan against target scans (see Match).
+type Result struct {
+	// Source is the name of the root of the source scan.
+	Source string `json:"source"`
+	// Targets are the names of the roots of the target scans.
+	Targets []string `json:"targets"`
+	// Matches lists the source directories with at least one candidate (in the order of the source scan).
+	Matches []*DirMatch `json:"matches"`
+}
+
+// contentKey identifies the contents of a file.
+type contentKey struct {
+	size int64
+	hash uint64
+}
+
+// node is a directory of a scan.
+type node struct {
+	path   string
+	dir    *scan.Dir
+	parent *node
+	// Total size of the hashed files in the subtree.
+	bytes int64
+	// Number of files of each content in the subtree.
+	keys map[contentKey]int
+}
+
+// Match finds the directories of the target roots that are similar to each directory of the source root.
+// Every pair of source and target directories that share any contents is scored by the total size of their shared contents
+// relative to the total size of their combined contents (see Candidate.Score),
+// and the candidates with at least the configured minimum score are reported along with the files that differ.
+// Files are compared by their contents (size and hash) and, depending on the configured mode,
+// their (normalized) paths relative to the compared directories.
+// Empty files and files that couldn't be hashed (or were unstable) are ignored,
+// though directories that contain the latter (or aren't otherwise fully known) are never reported as identical.
+// The subdirectories of a source directory that has an identical candidate aren't reported as they're implied by it.
+// Directories that contain each other (like if the source root is also a target) are never matched.
+// The directory hashes of the roots are (re)computed using scan.ComputeDirHashes,
+// which allows identical directories to be looked up directly if only those are requested in ModeAnywhere.
+func Match(source *scan.Dir, targets []*scan.Dir, opts Options) (*Result, error) {
+	if opts.MinScore < 0 || opts.MinScore > 1 {
+		return nil, fmt.Errorf("minimum score %v is not between 0 and 1", opts.MinScore)
+	}
+	if opts.MaxCandidates < 0 {
+		return nil, fmt.Errorf("maximum number of candidates %d is negative", opts.MaxCandidates)
+	}
+	if err := opts.validateMode(); err != nil {
+		return nil, err
+	}
+	res := &Result{Source: source.Name, Targets: []string{}, Matches: []*DirMatch{}}
+	idx := &index{byKey: make(map[contentKey][]*node), byContentHash: make(map[uint64][]*node), cmp: newComparer(opts)}
+	for _, t := range targets {
+		res.Targets = append(res.Targets, t.Name)
+		scan.ComputeDirHashes(t)
+		var nodes []*node
+		newNode(t, t.Name, nil, func(n *node) {
+			nodes = append(nodes, n)
+		})
+		// The contents of the subtrees are only accumulated once all nodes have been visited.
+		for _, n := range nodes {
+			for k := range n.keys {
+				idx.byKey[k] = append(idx.byKey[k], n)
+			}
+			if h := n.dir.ContentHash; h != 0 {
+				idx.byContentHash[h] = append(idx.byContentHash[h], n)
+			}
+		}
+	}
+	scan.ComputeDirHashes(source)
+	var sources []*node
+	newNode(source, source.Name, nil, func(n *node) {
+		sources = append(sources, n)
+	})
+	identical := make(map[*node]bool)
+	for _, s := range sources {
+		if s.parent != nil && identical[s.parent] {
+			identical[s] = true // implied by the parent
+			continue
+		}
+		if s.bytes == 0 {
+			continue
+		}
+		cs := idx.candidates(s, opts)
+		if len(cs) == 0 {
+			continue
+		}
+		if cs[0].Score == 1 {
+			identical[s] = true
+		}
+		res.Matches = append(res.Matches, &DirMatch{Source: s.path, Candidates: cs})
+	}
+	return res, nil
+}
+
+// newNode constructs the node of the provided Dir (with the provided path) and its subdirectories,
+// calling the provided function on each of them in pre-order (before the contents of the subtree are accumulated).
+func newNode(d *scan.Dir, path string, parent *node, visit func(*node)) *node {
+	n := &node{path: path, dir: d, parent: parent, keys: make(map[contentKey]int)}
+	visit(n)
+	for _, f := range d.Files {
+		if k, ok := keyOf(f); ok {
+			n.keys[k]++
+			n.bytes += f.Size
+		}
+	}
+	for _, s := range d.Dirs {
+		sub := newNode(s, filepath.Join(path, s.Name), n, visit)
+		for k, c := range sub.keys {
+			n.keys[k] += c
+		}
+		n.bytes += sub.bytes
+	}
+	return n
+}
+
+// keyOf returns the content key of the provided file
+// or false if it's empty or its hash isn't known.
+func keyOf(f *scan.File) (contentKey, bool) {
+	if f.Size == 0 || f.Unhashed || f.Unstable {
+		return contentKey{}, false
+	}
+	return contentKey{size: f.Size, hash: f.Hash}, true
+}
+
+// index is the directories of the target scans indexed by their contents.
+type index struct {
+	// Directories containing files (recursively) with each content.
+	byKey map[contentKey][]*node
+	// Directories with each content hash.
+	byContentHash map[uint64][]*node
+	// Comparer of directories in the configured mode.
+	cmp comparer
+}
+
+// candidate is a Candidate along with its target directory.
+type candidate struct {
+	*Candidate
+	target *node
+}
+
+// candidates returns the target directories that are similar enough to the provided source directory
+// (sorted by score, then by shared bytes, then by path).
+// If only identical directories are requested (i.e. the minimum score is 1) in ModeAnywhere,
+// they're looked up by their content hash instead of scoring every directory that shares any contents.
+func (idx *index) candidates(s *node, opts Options) []*Candidate {
+	var cs []candidate
+	if opts.MinScore == 1 && idx.cmp.mode == ModeAnywhere {
+		cs = idx.identical(s)
+	} else {
+		cs = idx.similar(s, opts.MinScore)
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		ci, cj := cs[i], cs[j]
+		if ci.Score != cj.Score {
+			return ci.Score > cj.Score
+		}
+		if ci.SharedBytes != cj.SharedBytes {
+			return ci.SharedBytes > cj.SharedBytes
+		}
+		return ci.Target < cj.Target
+	})
+	if opts.MaxCandidates > 0 && len(cs) > opts.MaxCandidates {
+		cs = cs[:opts.MaxCandidates]
+	}
+	res := make([]*Candidate, len(cs))
+	ss := idx.cmp.entries(s.dir, false)
+	for i, c := range cs {
+		c.Differences = idx.cmp.differences(ss, idx.cmp.entries(c.target.dir, false))
+		res[i] = c.Candidate
+	}
+	return res
+}
+
+// identical returns the target directories with the same contents as the provided source directory.
+func (idx *index) identical(s *node) []candidate {
+	var res []candidate
+	for _, t := range idx.byContentHash[s.dir.ContentHash] {
+		// Compare sizes as a (cheap) safeguard against hash collisions.
+		if t.bytes != s.bytes || util.IsSubpath(t.path, s.path) || util.IsSubpath(s.path, t.path) {
+			continue
+		}
+		res = append(res, candidate{
+			Candidate: &Candidate{Target: t.path, Score: 1, SharedBytes: s.bytes, SourceBytes: s.bytes, TargetBytes: t.bytes},
+			target:    t,
+		})
+	}
+	return res
+}
+
+// similar returns the target directories that share any contents with the provided source directory
+// and have at least the provided similarity score.
+// The shared contents of all such directories are accumulated in a single pass over the contents of the source directory,
+// so only directories that actually share contents with it are ever considered.
+// The score is computed from the contents alone first and only directories that pass
+// are (in modes other than ModeAnywhere) rescored by matching their files individually.
+func (idx *index) similar(s *node, minScore float64) []candidate {
+	overlap := make(map[*node]int64)
+	for k, c := range s.keys {
+		for _, t := range idx.byKey[k] {
+			// Count contents with multiple copies as many times as the smallest number of copies.
+			if d := t.keys[k]; d < c {
+				overlap[t] += k.size * int64(d)
+			} else {
+				overlap[t] += k.size * int64(c)
+			}
+		}
+	}
+	var ss []*entry
+	var res []candidate
+	for t, shared := range overlap {
+		// As the locations of the files aren't taken into account, this is an upper bound of the score in any mode.
+		score := float64(shared) / float64(s.bytes+t.bytes-shared)
+		if score < minScore || util.IsSubpath(t.path, s.path) || util.IsSubpath(s.path, t.path) {
+			continue
+		}
+		if idx.cmp.mode != ModeAnywhere {
+			if ss == nil {
+				ss = idx.cmp.entries(s.dir, false)
+			}
+			shared = idx.cmp.sharedBytes(ss, idx.cmp.entries(t.dir, false))
+			if shared == 0 {
+				continue
+			}
+			score = float64(shared) / float64(s.bytes+t.bytes-shared)
+			if score < minScore {
+				continue
+			}
+		}
+		if score == 1 && (s.dir.ContentHash == 0 || t.dir.ContentHash == 0) {
+			// The directories may differ in contents that aren't known.
+			score = math.Nextafter(1, 0)
+		}
+		res = append(res, candidate{
+			Candidate: &Candidate{
+				Target:      t.path,
+				Score:       score,
+				SharedBytes: shared,
+				SourceBytes: s.bytes,
+				TargetBytes: t.bytes,
+			},
+			target: t,
+		})
+	}
+	return res
+}
+
+// entry is a file of a directory.
+type entry struct {
+	// Path relative to the directory.
+	path string
+	// Normalized path relative to the directory.
+	norm string
+	key  contentKey
+}
+
+// locKey identifies the contents of a file along with the part of its location that must match in the mode of comparison.
+type locKey struct {
+	loc string
+	key contentKey
+}
+
+// comparer compares directories file by file in a given mode.
+type comparer struct {
+	mode      Mode
+	normalize func(string) string
+}
+
+func newComparer(opts Options) comparer {
+	return comparer{mode: opts.mode(), normalize: opts.normalizer()}
+}
+
+// entries returns the files of the provided directory with known contents (recursively).
+// Empty files are included only if withEmpty is set.
+func (c comparer) entries(d *scan.Dir, withEmpty bool) []*entry {
+	return c.appendEntries(d, "", withEmpty, nil)
+}
+
+func (c comparer) appendEntries(d *scan.Dir, path string, withEmpty bool, res []*entry) []*entry {
+	for _, f := range d.Files {
+		if k, ok := keyOf(f); ok {
+			res = append(res, c.newEntry(filepath.Join(path, f.Name), k))
+		}
+	}
+	if withEmpty {
+		for _, n := range d.EmptyFiles {
+			res = append(res, c.newEntry(filepath.Join(path, n), contentKey{}))
+		}
+	}
+	for _, s := range d.Dirs {
+		res = c.appendEntries(s, filepath.Join(path, s.Name), withEmpty, res)
+	}
+	return res
+}
+
+func (c comparer) newEntry(path string, k contentKey) *entry {
+	return &entry{path: path, norm: c.normalize(path), key: k}
+}
+
+// locKey returns the key that the provided entry must share with an entry of another directory for them to match.
+func (c comparer) locKey(e *entry) locKey {
+	return locKey{loc: location(e.norm, c.mode), key: e.key}
+}
+
+// sharedBytes returns the total size of the files of the provided source entries that match the provided target entries.
+func (c comparer) sharedBytes(ss, ts []*entry) int64 {
+	var res int64
+	for _, e := range ss {
+		res += e.key.size
+	}
+	for _, e := range c.unmatched(ss, ts) {
+		res -= e.key.size
+	}
+	return res
+}
+
+// differences returns the files of the provided source and target entries that aren't matched by any file of the other.
+// Files with the same relative path and contents are paired up first
+// such that it's the copies in other locations that are reported if a directory contains more copies than the other.
+// Unmatched files with the same (normalized) relative path in both directories are reported as modified.
+func (c comparer) differences(ss, ts []*entry) []*Difference {
+	sUnmatched, tUnmatched := c.unmatched(ss, ts), c.unmatched(ts, ss)
+	tPaths := make(map[string]bool)
+	for _, e := range tUnmatched {
+		tPaths[e.norm] = true
+	}
+	var res []*Difference
+	sPaths := make(map[string]bool)
+	for _, e := range sUnmatched {
+		sPaths[e.norm] = true
+		if tPaths[e.norm] {
+			res = append(res, &Difference{Kind: Modified, Path: e.path})
+		} else {
+			res = append(res, &Difference{Kind: Missing, Path: e.path})
+		}
+	}
+	for _, e := range tUnmatched {
+		if !sPaths[e.norm] {
+			res = append(res, &Difference{Kind: Extra, Path: e.path})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Path < res[j].Path
+	})
+	return res
+}
+
+// unmatched returns the entries of es that aren't matched by any entry of os
+// (after pairing up entries with the same normalized path and contents).
+func (c comparer) unmatched(es, os []*entry) []*entry {
+	byPath := make(map[locKey]int)
+	counts := make(map[locKey]int)
+	for _, o := range os {
+		byPath[locKey{loc: o.norm, key: o.key}]++
+		counts[c.locKey(o)]++
+	}
+	var rest []*entry
+	for _, e := range es {
+		if k := (locKey{loc: e.norm, key: e.key}); byPath[k] > 0 {
+			byPath[k]--
+			counts[c.locKey(e)]--
+		} else {
+			rest = append(rest, e)
+		}
+	}
+	var res []*entry
+	for _, e := range rest {
+		if k := c.locKey(e); counts[k] > 0 {
+			counts[k]--
+		} else {
+			res = append(res, e)
+		}
+	}
+	return res
+}
@@ -0,0 +1,284 @@
+package match
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/scan"
+)
+
+func file(name string, size int64, hash uint64) *scan.File {
+	return scan.NewFile(name, size, 0, hash)
+}
+
+func Test__Match_scores_similar_directories(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	source := &scan.Dir{
+		Name:  p("/src"),
+		Files: []*scan.File{file("a", 10, 1), file("b", 20, 2), file("c", 30, 3), file("d", 40, 4)},
+	}
+	target := &scan.Dir{
+		Name: p("/dst"),
+		Dirs: []*scan.Dir{
+			// Half-synced copy: "b" was edited, "c" was renamed, "d" is missing, and "e" was added.
+			{Name: "copy", Files: []*scan.File{file("a", 10, 1), file("b", 20, 20), file("c2", 30, 3), file("e", 5, 5)}},
+			{Name: "other", Files: []*scan.File{file("x", 10, 1)}},
+		},
+	}
+	res, err := Match(source, []*scan.Dir{target}, Options{MinScore: 0.25})
+	require.NoError(t, err)
+	assert.Equal(t, &Result{
+		Source:  p("/src"),
+		Targets: []string{p("/dst")},
+		Matches: []*DirMatch{
+			{
+				Source: p("/src"),
+				Candidates: []*Candidate{
+					{
+						Target:      p("/dst/copy"),
+						Score:       40.0 / (100 + 65 - 40),
+						SharedBytes: 40,
+						SourceBytes: 100,
+						TargetBytes: 65,
+						Differences: []*Difference{
+							{Kind: Modified, Path: "b"},
+							{Kind: Missing, Path: "d"},
+							{Kind: Extra, Path: "e"},
+						},
+					},
+					{
+						Target:      p("/dst"),
+						Score:       40.0 / (100 + 75 - 40),
+						SharedBytes: 40,
+						SourceBytes: 100,
+						TargetBytes: 75,
+						Differences: []*Difference{
+							{Kind: Missing, Path: "b"},
+							{Kind: Extra, Path: p("copy/b")},
+							{Kind: Extra, Path: p("copy/e")},
+							{Kind: Missing, Path: "d"},
+							{Kind: Extra, Path: p("other/x")},
+						},
+					},
+				},
+			},
+		},
+	}, res)
+}
+
+func Test__Match_limits_candidates(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	source := &scan.Dir{Name: p("/src"), Files: []*scan.File{file("a", 10, 1), file("b", 10, 2)}}
+	target := &scan.Dir{
+		Name: p("/dst"),
+		Dirs: []*scan.Dir{
+			{Name: "d1", Files: []*scan.File{file("a", 10, 1)}},
+			{Name: "d2", Files: []*scan.File{file("a", 10, 1), file("b", 10, 2)}},
+		},
+	}
+	tests := []struct {
+		opts        Options
+		wantTargets []string
+	}{
+		{opts: Options{}, wantTargets: []string{p("/dst/d2"), p("/dst"), p("/dst/d1")}},
+		{opts: Options{MaxCandidates: 2}, wantTargets: []string{p("/dst/d2"), p("/dst")}},
+		{opts: Options{MinScore: 0.6}, wantTargets: []string{p("/dst/d2"), p("/dst")}},
+		{opts: Options{MinScore: 1}, wantTargets: []string{p("/dst/d2")}},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%+v", test.opts), func(t *testing.T) {
+			res, err := Match(source, []*scan.Dir{target}, test.opts)
+			require.NoError(t, err)
+			require.Len(t, res.Matches, 1)
+			var targets []string
+			for _, c := range res.Matches[0].Candidates {
+				targets = append(targets, c.Target)
+			}
+			assert.Equal(t, test.wantTargets, targets)
+		})
+	}
+}
+
+func Test__Match_skips_subdirectories_of_identical_directories(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	subtree := func(name string) *scan.Dir {
+		return &scan.Dir{Name: name, Dirs: []*scan.Dir{{Name: "s", Files: []*scan.File{file("a", 10, 1)}}}, Files: []*scan.File{file("b", 10, 2)}}
+	}
+	source := &scan.Dir{Name: p("/src"), Dirs: []*scan.Dir{subtree("d")}, Files: []*scan.File{file("c", 10, 3)}}
+	target := &scan.Dir{Name: p("/dst"), Dirs: []*scan.Dir{subtree("e")}}
+	res, err := Match(source, []*scan.Dir{target}, Options{MinScore: 0.6})
+	require.NoError(t, err)
+	var sources []string
+	for _, m := range res.Matches {
+		sources = append(sources, m.Source)
+	}
+	assert.Equal(t, []string{p("/src"), p("/src/d")}, sources) // "d/s" is implied by "d"
+	// The target root has the same contents as its only subdirectory.
+	assert.Equal(t, []*Candidate{
+		{Target: p("/dst"), Score: 1, SharedBytes: 20, SourceBytes: 20, TargetBytes: 20},
+		{Target: p("/dst/e"), Score: 1, SharedBytes: 20, SourceBytes: 20, TargetBytes: 20},
+	}, res.Matches[1].Candidates)
+}
+
+func Test__Match_does_not_match_nested_directories(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	root := &scan.Dir{Name: p("/x"), Dirs: []*scan.Dir{
+		{Name: "d", Files: []*scan.File{file("a", 10, 1)}},
+		{Name: "e", Files: []*scan.File{file("a", 10, 1)}},
+	}}
+	res, err := Match(root, []*scan.Dir{root}, Options{MinScore: 1})
+	require.NoError(t, err)
+	require.Len(t, res.Matches, 2)
+	assert.Equal(t, p("/x/d"), res.Matches[0].Source)
+	assert.Equal(t, []*Candidate{{Target: p("/x/e"), Score: 1, SharedBytes: 10, SourceBytes: 10, TargetBytes: 10}}, res.Matches[0].Candidates)
+	assert.Equal(t, p("/x/e"), res.Matches[1].Source)
+	assert.Equal(t, p("/x/d"), res.Matches[1].Candidates[0].Target)
+}
+
+func Test__Match_ignores_empty_files(t *testing.T) {
+	source := &scan.Dir{Name: "x", Files: []*scan.File{file("a", 10, 1)}, EmptyFiles: []string{"e"}}
+	target := &scan.Dir{Name: "y", Files: []*scan.File{file("a", 10, 1)}}
+	res, err := Match(source, []*scan.Dir{target}, Options{MinScore: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []*DirMatch{
+		{Source: "x", Candidates: []*Candidate{{Target: "y", Score: 1, SharedBytes: 10, SourceBytes: 10, TargetBytes: 10}}},
+	}, res.Matches)
+}
+
+func Test__Match_never_reports_incomplete_directories_as_identical(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(d *scan.Dir)
+	}{
+		{name: "skipped file", modify: func(d *scan.Dir) { d.SkippedFiles = []string{"secret.tmp"} }},
+		{name: "inaccessible file", modify: func(d *scan.Dir) { d.InaccessibleFiles = []*scan.Inaccessible{{Name: "f"}} }},
+		{name: "unhashed file", modify: func(d *scan.Dir) { d.Files = append(d.Files, &scan.File{Name: "u", Size: 5, Unhashed: true}) }},
+		{name: "incomplete subdirectory", modify: func(d *scan.Dir) { d.Dirs = []*scan.Dir{{Name: "s", SkippedDirs: []string{"t"}}} }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := &scan.Dir{Name: "x", Files: []*scan.File{file("a", 10, 1)}}
+			target := &scan.Dir{Name: "y", Files: []*scan.File{file("a", 10, 1)}}
+			test.modify(target)
+
+			// Identical directories are looked up by content hash.
+			res, err := Match(source, []*scan.Dir{target}, Options{MinScore: 1})
+			require.NoError(t, err)
+			assert.Empty(t, res.Matches)
+
+			// Similar directories are scored.
+			res, err = Match(source, []*scan.Dir{target}, Options{MinScore: 0.5})
+			require.NoError(t, err)
+			require.Len(t, res.Matches, 1)
+			require.Len(t, res.Matches[0].Candidates, 1)
+			c := res.Matches[0].Candidates[0]
+			assert.Less(t, c.Score, 1.0)
+			assert.Greater(t, c.Score, 0.99)
+		})
+	}
+}
+
+func Test__Match_modes_require_corresponding_locations(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	source := &scan.Dir{
+		Name:  p("/src"),
+		Dirs:  []*scan.Dir{{Name: "s", Files: []*scan.File{file("b", 20, 2), file("c", 40, 4)}}},
+		Files: []*scan.File{file("a", 50, 1)},
+	}
+	target := &scan.Dir{
+		Name: p("/dst"),
+		Dirs: []*scan.Dir{
+			{Name: "s", Files: []*scan.File{file("d", 40, 4)}}, // renamed
+			{Name: "t", Files: []*scan.File{file("b", 20, 2)}}, // moved
+		},
+		Files: []*scan.File{file("a", 50, 1)},
+	}
+	tests := []struct {
+		mode          Mode
+		wantShared    int64
+		wantDiffPaths []string
+	}{
+		{mode: ModeExact, wantShared: 50, wantDiffPaths: []string{p("s/b"), p("s/c"), p("s/d"), p("t/b")}},
+		{mode: ModeSameDir, wantShared: 90, wantDiffPaths: []string{p("s/b"), p("t/b")}},
+		{mode: ModeSameName, wantShared: 70, wantDiffPaths: []string{p("s/c"), p("s/d")}},
+		{mode: ModeAnywhere, wantShared: 110},
+		{mode: "", wantShared: 110},
+	}
+	for _, test := range tests {
+		t.Run(string(test.mode), func(t *testing.T) {
+			res, err := Match(source, []*scan.Dir{target}, Options{Mode: test.mode, MaxCandidates: 1})
+			require.NoError(t, err)
+			require.NotEmpty(t, res.Matches)
+			m := res.Matches[0]
+			assert.Equal(t, p("/src"), m.Source)
+			require.Len(t, m.Candidates, 1)
+			c := m.Candidates[0]
+			assert.Equal(t, p("/dst"), c.Target)
+			assert.Equal(t, test.wantShared, c.SharedBytes)
+			assert.Equal(t, float64(test.wantShared)/float64(110+110-test.wantShared), c.Score)
+			var diffPaths []string
+			for _, d := range c.Differences {
+				diffPaths = append(diffPaths, d.Path)
+			}
+			assert.Equal(t, test.wantDiffPaths, diffPaths)
+		})
+	}
+}
+
+func Test__Match_normalizes_names(t *testing.T) {
+	source := &scan.Dir{Name: "x", Files: []*scan.File{file("\u00c4", 10, 1), file("b", 10, 3)}} // "Ä" (composed)
+	same := &scan.Dir{Name: "y", Files: []*scan.File{file("a\u0308", 10, 1), file("b", 10, 3)}}  // "ä" (decomposed)
+	edited := &scan.Dir{Name: "z", Files: []*scan.File{file("a\u0308", 10, 2), file("b", 10, 3)}}
+	tests := []struct {
+		ignoreCase       bool
+		normalizeUnicode bool
+		wantEqual        bool
+	}{
+		{},
+		{ignoreCase: true},
+		{normalizeUnicode: true},
+		{ignoreCase: true, normalizeUnicode: true, wantEqual: true},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%+v", test), func(t *testing.T) {
+			opts := Options{MinScore: 1, Mode: ModeExact, IgnoreCase: test.ignoreCase, NormalizeUnicode: test.normalizeUnicode}
+			res, err := Match(source, []*scan.Dir{same, edited}, opts)
+			require.NoError(t, err)
+			if !test.wantEqual {
+				assert.Empty(t, res.Matches)
+				return
+			}
+			require.Len(t, res.Matches, 1)
+			assert.Equal(t, []*Candidate{{Target: "y", Score: 1, SharedBytes: 20, SourceBytes: 20, TargetBytes: 20}}, res.Matches[0].Candidates)
+
+			// Names that are equal after normalization are reported as modified if their contents differ.
+			opts.MinScore = 0.3
+			res, err = Match(source, []*scan.Dir{edited}, opts)
+			require.NoError(t, err)
+			require.Len(t, res.Matches, 1)
+			assert.Equal(t, []*Difference{{Kind: Modified, Path: "\u00c4"}}, res.Matches[0].Candidates[0].Differences)
+		})
+	}
+}
+
+func Test__Match_invalid_options_fails(t *testing.T) {
+	tests := []struct {
+		opts    Options
+		wantErr string
+	}{
+		{opts: Options{MinScore: -0.1}, wantErr: "minimum score -0.1 is not between 0 and 1"},
+		{opts: Options{MinScore: 1.5}, wantErr: "minimum score 1.5 is not between 0 and 1"},
+		{opts: Options{MaxCandidates: -1}, wantErr: "maximum number of candidates -1 is negative"},
+		{opts: Options{Mode: "x"}, wantErr: `invalid mode "x" (valid modes are ["exact" "same-dir" "same-name" "anywhere"])`},
+	}
+	for _, test := range tests {
+		t.Run(test.wantErr, func(t *testing.T) {
+			_, err := Match(&scan.Dir{Name: "x"}, nil, test.opts)
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package match
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Mode determines which files are considered the same when comparing directories:
+// Files must always have the same contents (size and hash),
+// and the mode determines how their locations (relative to the compared directories) must correspond.
+type Mode string
+
+// Modes of matching files.
+const (
+	// ModeExact matches files with the same contents and relative path.
+	ModeExact Mode = "exact"
+	// ModeSameDir matches files with the same contents in the same relative directory (i.e. regardless of name).
+	ModeSameDir Mode = "same-dir"
+	// ModeSameName matches files with the same contents and name in any directory (i.e. regardless of structure).
+	ModeSameName Mode = "same-name"
+	// ModeAnywhere matches files with the same contents regardless of name and directory.
+	// This is the default mode.
+	ModeAnywhere Mode = "anywhere"
+)
+
+// Modes lists the valid modes.
+var Modes = []Mode{ModeExact, ModeSameDir, ModeSameName, ModeAnywhere}
+
+// mode returns the configured mode (defaulting to ModeAnywhere).
+func (o Options) mode() Mode {
+	if o.Mode == "" {
+		return ModeAnywhere
+	}
+	return o.Mode
+}
+
+func (o Options) validateMode() error {
+	m := o.mode()
+	for _, v := range Modes {
+		if m == v {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid mode %q (valid modes are %q)", m, Modes)
+}
+
+// normalizer returns the function for normalizing names (and relative paths) before they're compared.
+// Names are compared case-insensitively (using Unicode case folding) if IgnoreCase is set
+// and with Unicode normalization (NFC) if NormalizeUnicode is set.
+// The latter makes names with composed and decomposed characters equal
+// (like names created on macOS which uses NFD and copied to Linux where NFC is the norm).
+func (o Options) normalizer() func(string) string {
+	fold := cases.Fold()
+	return func(name string) string {
+		if o.IgnoreCase {
+			name = fold.String(name)
+		}
+		if o.NormalizeUnicode {
+			name = norm.NFC.String(name)
+		}
+		return name
+	}
+}
+
+// location returns the part of the provided (normalized) relative path of a file
+// that must be equal for files to match in the provided mode.
+func location(path string, mode Mode) string {
+	switch mode {
+	case ModeExact:
+		return path
+	case ModeSameDir:
+		return filepath.Dir(path)
+	case ModeSameName:
+		return filepath.Base(path)
+	}
+	return ""
+}
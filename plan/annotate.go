@@ -0,0 +1,55 @@
+package plan
+
+import (
+	"github.com/bisgardo/dupe-nukem/dupes"
+	"github.com/bisgardo/dupe-nukem/match"
+	"github.com/bisgardo/dupe-nukem/scan"
+)
+
+// AnnotateDupes annotates each group of the provided result of dupes.Find
+// with the copy that the provided rules choose to keep (and the rule that decided it).
+// The files of the groups are looked up in the provided scan roots (see FromDupes).
+func AnnotateDupes(res *dupes.Result, roots []*scan.Dir, rules Rules) error {
+	if err := rules.validate(); err != nil {
+		return err
+	}
+	gs, err := FromDupes(res, roots)
+	if err != nil {
+		return err
+	}
+	for i, g := range gs {
+		if len(g.Copies) < 2 {
+			continue // nothing to choose between
+		}
+		k, rule := rules.Choose(g.Copies)
+		res.Groups[i].Keep = k.Path
+		res.Groups[i].KeepRule = rule
+	}
+	return nil
+}
+
+// AnnotateMatch annotates each source directory of the provided result of match.Match that has identical candidates
+// with the directory (the source or one of the candidates) that the provided rules choose to keep
+// (and the rule that decided it).
+// The files of the directories are looked up in the provided scan roots (see FromMatch).
+func AnnotateMatch(res *match.Result, roots []*scan.Dir, rules Rules) error {
+	if err := rules.validate(); err != nil {
+		return err
+	}
+	for _, m := range res.Matches {
+		g, err := matchGroup(m, roots)
+		if err != nil {
+			return err
+		}
+		if g == nil {
+			continue
+		}
+		if _, err := checkGroups([]*Group{g}); err != nil {
+			return err
+		}
+		k, rule := rules.Choose(g.Copies)
+		m.Keep = k.Path
+		m.KeepRule = rule
+	}
+	return nil
+}
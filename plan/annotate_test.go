@@ -0,0 +1,43 @@
+package plan
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/dupes"
+	"github.com/bisgardo/dupe-nukem/match"
+)
+
+func Test__AnnotateDupes_annotates_kept_copies(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	res := &dupes.Result{Groups: []*dupes.Group{
+		{Size: 1, Hash: 1, Paths: []string{p("/x/a"), p("/x/d/a")}},
+		{Dir: true, Size: 3, Files: 2, Paths: []string{p("/x/d"), p("/y/d")}},
+	}}
+	require.NoError(t, AnnotateDupes(res, testRoots(), Rules{{Kind: RulePrefer, Dir: p("/y")}, {Kind: RuleNewest}}))
+	assert.Equal(t, p("/x/a"), res.Groups[0].Keep)
+	assert.Equal(t, "newest", res.Groups[0].KeepRule)
+	assert.Equal(t, p("/y/d"), res.Groups[1].Keep)
+	assert.Equal(t, "prefer "+p("/y"), res.Groups[1].KeepRule)
+}
+
+func Test__AnnotateMatch_annotates_kept_directories(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	res := &match.Result{Matches: []*match.DirMatch{
+		{Source: p("/x/d"), Candidates: []*match.Candidate{{Target: p("/y"), Score: 1}}},
+		{Source: p("/x"), Candidates: []*match.Candidate{{Target: p("/y"), Score: 0.5}}},
+	}}
+	require.NoError(t, AnnotateMatch(res, testRoots(), Rules{{Kind: RuleShortest}}))
+	assert.Equal(t, p("/y"), res.Matches[0].Keep)
+	assert.Equal(t, "shortest", res.Matches[0].KeepRule)
+	assert.Empty(t, res.Matches[1].Keep) // no identical candidates
+	assert.Empty(t, res.Matches[1].KeepRule)
+}
+
+func Test__AnnotateDupes_invalid_rules_fails(t *testing.T) {
+	err := AnnotateDupes(&dupes.Result{}, nil, Rules{{Kind: RulePrefer}})
+	assert.EqualError(t, err, `rule "prefer" requires a directory`)
+}
@@ -0,0 +1,306 @@
+package plan
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bisgardo/dupe-nukem/dupes"
+	"github.com/bisgardo/dupe-nukem/match"
+	"github.com/bisgardo/dupe-nukem/scan"
+	"github.com/bisgardo/dupe-nukem/util"
+)
+
+// FromDupes constructs the groups of the provided result of dupes.Find.
+// The files of the groups are looked up in the provided scan roots (which must include the roots of the result)
+// to get their current metadata.
+// Hardlinks aren't included as acting on them wouldn't reclaim any space.
+// Copies that are reached through a followed symlink or are the same files as another copy are left out
+// (see viaSymlink and distinctCopies), so the groups correspond to the ones of the result by position
+// but may have fewer than two copies.
+func FromDupes(res *dupes.Result, roots []*scan.Dir) ([]*Group, error) {
+	var gs []*Group
+	for _, g := range res.Groups {
+		var cs []*Copy
+		for _, p := range g.Paths {
+			var c *Copy
+			var err error
+			if g.Dir {
+				c, err = dirCopy(roots, p)
+			} else {
+				c, err = fileCopy(roots, p)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if c == nil {
+				continue
+			}
+			if !g.Dir && (c.Files[0].Size != g.Size || c.Files[0].Hash != g.Hash) {
+				return nil, fmt.Errorf("file %q has different contents in scan than in duplicates", p)
+			}
+			cs = append(cs, c)
+		}
+		gs = append(gs, &Group{Copies: distinctCopies(cs)})
+	}
+	return checkGroups(gs)
+}
+
+// FromMatch constructs the groups of the identical candidates (i.e. with score 1) of the provided result of match.Match.
+// Each group consists of a source directory and its identical candidates
+// (excluding candidates that are nested inside another candidate of the group,
+// are reached through a followed symlink, or are the same files as another copy).
+// The files of the directories are looked up in the provided scan roots
+// (which must include the source and target roots of the result).
+// As matches don't depend on the names of files (by default), files are paired up by contents.
+func FromMatch(res *match.Result, roots []*scan.Dir) ([]*Group, error) {
+	var gs []*Group
+	for _, m := range res.Matches {
+		g, err := matchGroup(m, roots)
+		if err != nil {
+			return nil, err
+		}
+		if g != nil {
+			gs = append(gs, g)
+		}
+	}
+	return checkGroups(gs)
+}
+
+// matchGroup constructs the group of the provided source directory and its identical candidates
+// or returns nil if it doesn't have at least two distinct copies.
+func matchGroup(m *match.DirMatch, roots []*scan.Dir) (*Group, error) {
+	var paths []string
+	for _, c := range m.Candidates {
+		if c.Score == 1 {
+			paths = append(paths, c.Target)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	c, err := dirCopy(roots, m.Source)
+	if err != nil {
+		return nil, err
+	}
+	var cs []*Copy
+	if c != nil {
+		cs = append(cs, c)
+	}
+next:
+	for _, p := range paths {
+		for _, c := range cs {
+			if util.IsSubpath(c.Path, p) || util.IsSubpath(p, c.Path) {
+				continue next
+			}
+		}
+		c, err := dirCopy(roots, p)
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			cs = append(cs, c)
+		}
+	}
+	cs = distinctCopies(cs)
+	if len(cs) < 2 {
+		return nil, nil
+	}
+	return &Group{Copies: cs}, nil
+}
+
+// distinctCopies returns the provided copies without the ones whose files are all the same files
+// as the ones of a previous copy according to the device and inode numbers recorded in the scans
+// (like a directory that was scanned under two paths through a bind mount).
+func distinctCopies(cs []*Copy) []*Copy {
+	var res []*Copy
+next:
+	for _, c := range cs {
+		for _, d := range res {
+			if sameFiles(c, d) {
+				continue next
+			}
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+// sameFiles returns whether the provided copies are known to consist of the same files.
+func sameFiles(c, d *Copy) bool {
+	if len(c.Files) == 0 || len(c.Files) != len(d.Files) {
+		return false
+	}
+	ids := make(map[[2]uint64]bool, len(d.Files))
+	for _, f := range d.Files {
+		ids[[2]uint64{f.Device, f.Inode}] = true
+	}
+	for _, f := range c.Files {
+		if f.Inode == 0 || !ids[[2]uint64{f.Device, f.Inode}] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkGroups checks that the files of the copies of each of the provided groups correspond to each other.
+// This is expected to always be the case unless the scans aren't the ones that the groups were found in.
+func checkGroups(gs []*Group) ([]*Group, error) {
+	for _, g := range gs {
+		if len(g.Copies) == 0 {
+			continue
+		}
+		c := g.Copies[0]
+		for _, d := range g.Copies[1:] {
+			if len(c.Files) != len(d.Files) {
+				return nil, fmt.Errorf("copies %q and %q have different contents in scans", c.Path, d.Path)
+			}
+			for i, f := range c.Files {
+				if g := d.Files[i]; f.Size != g.Size || f.Hash != g.Hash {
+					return nil, fmt.Errorf("copies %q and %q have different contents in scans", c.Path, d.Path)
+				}
+			}
+		}
+	}
+	return gs, nil
+}
+
+// fileCopy looks up the file with the provided path in the provided roots and returns it as a copy.
+// Returns nil if the file is reached through a followed symlink (see viaSymlink).
+func fileCopy(roots []*scan.Dir, path string) (*Copy, error) {
+	root, rel, err := resolve(roots, path)
+	if err != nil {
+		return nil, err
+	}
+	f := scan.SafeFindFileByPath(root, rel)
+	if f == nil {
+		return nil, fmt.Errorf("file %q not found in scan of %q", path, root.Name)
+	}
+	if f.Unhashed || f.Unstable {
+		return nil, fmt.Errorf("file %q has no known hash in scan of %q", path, root.Name)
+	}
+	if viaSymlink(root, rel) {
+		return nil, nil
+	}
+	return &Copy{
+		Path:   path,
+		Files:  []*File{{Path: path, Size: f.Size, Hash: f.Hash, ModTime: f.ModTime, Device: f.Device, Inode: f.Inode}},
+		Others: otherFiles(root, rel, 1),
+	}, nil
+}
+
+// dirCopy looks up the directory with the provided path in the provided roots
+// and returns it as a copy of its (recursively contained) files with known hashes.
+// Empty files are skipped as acting on them wouldn't reclaim any space.
+// Returns nil if the directory is reached through a followed symlink (see viaSymlink)
+// or contains any followed symlinks, as the files of such directories are (partly) aliases of other files.
+func dirCopy(roots []*scan.Dir, path string) (*Copy, error) {
+	root, rel, err := resolve(roots, path)
+	if err != nil {
+		return nil, err
+	}
+	d := scan.SafeFindDirByPath(root, rel)
+	if d == nil {
+		return nil, fmt.Errorf("directory %q not found in scan of %q", path, root.Name)
+	}
+	if viaSymlink(root, rel) || hasFollowedSymlink(d) {
+		return nil, nil
+	}
+	fs := dirFiles(d, path, nil)
+	res := &Copy{Path: path, Files: fs, Others: otherFiles(root, rel, len(fs))}
+	sort.Slice(res.Files, func(i, j int) bool {
+		fi, fj := res.Files[i], res.Files[j]
+		if fi.Size != fj.Size {
+			return fi.Size < fj.Size
+		}
+		if fi.Hash != fj.Hash {
+			return fi.Hash < fj.Hash
+		}
+		return fi.Path < fj.Path
+	})
+	return res, nil
+}
+
+func dirFiles(d *scan.Dir, path string, res []*File) []*File {
+	for _, f := range d.Files {
+		if f.Size != 0 && !f.Unhashed && !f.Unstable {
+			res = append(res, &File{Path: filepath.Join(path, f.Name), Size: f.Size, Hash: f.Hash, ModTime: f.ModTime, Device: f.Device, Inode: f.Inode})
+		}
+	}
+	for _, s := range d.Dirs {
+		res = dirFiles(s, filepath.Join(path, s.Name), res)
+	}
+	return res
+}
+
+// viaSymlink returns whether the provided path (relative to the provided root)
+// is reached through a symlink that was followed when scanning,
+// i.e. whether the file or directory at the path or any of the directories containing it is a symlink.
+func viaSymlink(root *scan.Dir, rel string) bool {
+	d := root
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		if name == "" || name == "." {
+			continue
+		}
+		if isSymlink(d, name) {
+			return true
+		}
+		d = scan.SafeFindDir(d, name)
+	}
+	return false
+}
+
+// hasFollowedSymlink returns whether the provided directory (recursively) contains a symlink that was followed when scanning,
+// i.e. a symlink whose target was also listed as a file or subdirectory.
+func hasFollowedSymlink(d *scan.Dir) bool {
+	for _, l := range d.Symlinks {
+		if scan.SafeFindDir(d, l.Name) != nil || scan.SafeFindFile(d, l.Name) != nil {
+			return true
+		}
+	}
+	for _, s := range d.Dirs {
+		if hasFollowedSymlink(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSymlink returns whether the provided directory has a symlink with the provided name.
+func isSymlink(d *scan.Dir, name string) bool {
+	if d == nil {
+		return false
+	}
+	for _, l := range d.Symlinks {
+		if l.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// otherFiles returns the number of files (recursively) in the parent directory of the provided path (relative to the provided root)
+// that aren't part of the copy at the path (which has the provided number of files).
+// The root itself has no parent directory within the scan and thus no other files.
+func otherFiles(root *scan.Dir, rel string, files int) int {
+	if rel == "." {
+		return 0
+	}
+	return len(dirFiles(scan.SafeFindDirByPath(root, filepath.Dir(rel)), "", nil)) - files
+}
+
+// resolve returns the root (of the provided ones) that contains the provided path
+// along with the path relative to it.
+func resolve(roots []*scan.Dir, path string) (*scan.Dir, string, error) {
+	for _, r := range roots {
+		if util.IsSubpath(r.Name, path) {
+			rel, err := filepath.Rel(r.Name, path)
+			if err != nil {
+				return nil, "", err // cannot happen
+			}
+			return r, rel, nil
+		}
+	}
+	return nil, "", fmt.Errorf("path %q is not inside the root of any scan", path)
+}
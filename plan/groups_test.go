@@ -0,0 +1,183 @@
+package plan
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/dupes"
+	"github.com/bisgardo/dupe-nukem/match"
+	"github.com/bisgardo/dupe-nukem/scan"
+)
+
+func testRoots() []*scan.Dir {
+	p := filepath.FromSlash // because Windows...
+	return []*scan.Dir{
+		{
+			Name: p("/x"),
+			Dirs: []*scan.Dir{
+				{Name: "d", Files: []*scan.File{scan.NewFile("b", 2, 20, 2), scan.NewFile("a", 1, 10, 1)}, EmptyFiles: []string{"e"}},
+			},
+			Files: []*scan.File{scan.NewFile("a", 1, 30, 1)},
+		},
+		{
+			Name: p("/y"),
+			Dirs: []*scan.Dir{
+				{Name: "d", Files: []*scan.File{scan.NewFile("a2", 1, 40, 1), scan.NewFile("b", 2, 50, 2)}},
+			},
+		},
+	}
+}
+
+func Test__FromDupes_looks_up_copies_in_scans(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	res := &dupes.Result{Groups: []*dupes.Group{
+		{Size: 1, Hash: 1, Paths: []string{p("/x/a"), p("/x/d/a")}},
+		{Dir: true, Size: 3, Files: 2, Paths: []string{p("/x/d"), p("/y/d")}},
+	}}
+	gs, err := FromDupes(res, testRoots())
+	require.NoError(t, err)
+	assert.Equal(t, []*Group{
+		{Copies: []*Copy{
+			{Path: p("/x/a"), Files: []*File{{Path: p("/x/a"), Size: 1, Hash: 1, ModTime: 30}}, Others: 2},
+			{Path: p("/x/d/a"), Files: []*File{{Path: p("/x/d/a"), Size: 1, Hash: 1, ModTime: 10}}, Others: 1},
+		}},
+		{Copies: []*Copy{
+			{
+				Path:   p("/x/d"),
+				Files:  []*File{{Path: p("/x/d/a"), Size: 1, Hash: 1, ModTime: 10}, {Path: p("/x/d/b"), Size: 2, Hash: 2, ModTime: 20}},
+				Others: 1, // "/x/a"
+			},
+			{
+				Path:  p("/y/d"),
+				Files: []*File{{Path: p("/y/d/a2"), Size: 1, Hash: 1, ModTime: 40}, {Path: p("/y/d/b"), Size: 2, Hash: 2, ModTime: 50}},
+			},
+		}},
+	}, gs)
+}
+
+func Test__FromMatch_groups_identical_candidates(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	res := &match.Result{Matches: []*match.DirMatch{
+		{Source: p("/x/d"), Candidates: []*match.Candidate{
+			{Target: p("/y"), Score: 1},
+			{Target: p("/y/d"), Score: 1}, // nested inside previous candidate
+		}},
+		{Source: p("/x"), Candidates: []*match.Candidate{{Target: p("/y"), Score: 0.5}}},
+	}}
+	gs, err := FromMatch(res, testRoots())
+	require.NoError(t, err)
+	require.Len(t, gs, 1)
+	require.Len(t, gs[0].Copies, 2)
+	assert.Equal(t, p("/x/d"), gs[0].Copies[0].Path)
+	assert.Equal(t, 1, gs[0].Copies[0].Others)
+	assert.Equal(t, p("/y"), gs[0].Copies[1].Path)
+	assert.Zero(t, gs[0].Copies[1].Others) // root of scan
+	assert.Equal(t, []*File{{Path: p("/y/d/a2"), Size: 1, Hash: 1, ModTime: 40}, {Path: p("/y/d/b"), Size: 2, Hash: 2, ModTime: 50}}, gs[0].Copies[1].Files)
+}
+
+// symlinkedRoots returns scan roots in which "/y/l" is a followed symlink to "/x/d" (as in "/y/l -> ../x/d")
+// and "/z/d" is a bind mount of "/x/d" (i.e. has the same files).
+func symlinkedRoots() []*scan.Dir {
+	p := filepath.FromSlash // because Windows...
+	files := func() []*scan.File {
+		return []*scan.File{
+			{Name: "a", Size: 1, ModTime: 10, Hash: 1, Device: 1, Inode: 10},
+			{Name: "b", Size: 2, ModTime: 20, Hash: 2, Device: 1, Inode: 11},
+		}
+	}
+	return []*scan.Dir{
+		{Name: p("/x"), Dirs: []*scan.Dir{{Name: "d", Files: files()}}},
+		{
+			Name:     p("/y"),
+			Dirs:     []*scan.Dir{{Name: "l", Files: files()}, {Name: "s", Symlinks: []*scan.Symlink{{Name: "b", Target: "../l/b"}}, Files: files()[1:]}},
+			Symlinks: []*scan.Symlink{{Name: "l", Target: p("../x/d")}},
+		},
+		{Name: p("/z"), Dirs: []*scan.Dir{{Name: "d", Files: files()}}},
+	}
+}
+
+func Test__FromDupes_leaves_out_symlinked_and_same_copies(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	res := &dupes.Result{Groups: []*dupes.Group{
+		{Size: 1, Hash: 1, Paths: []string{p("/x/d/a"), p("/y/l/a"), p("/z/d/a")}},
+		{Dir: true, Size: 3, Files: 2, Paths: []string{p("/x/d"), p("/y/l"), p("/z/d")}},
+		{Size: 2, Hash: 2, Paths: []string{p("/y/l/b"), p("/y/s/b")}},
+	}}
+	gs, err := FromDupes(res, symlinkedRoots())
+	require.NoError(t, err)
+	require.Len(t, gs, 3)
+	for _, g := range gs[:2] {
+		require.Len(t, g.Copies, 1)
+		assert.Equal(t, p("/x/d"), filepath.Dir(g.Copies[0].Files[0].Path))
+	}
+	assert.Empty(t, gs[2].Copies)
+
+	// Nothing is planned for groups without distinct copies.
+	pl, err := Build(gs, Options{Action: Remove})
+	require.NoError(t, err)
+	assert.Empty(t, pl.Steps)
+
+	// Nor are they annotated.
+	require.NoError(t, AnnotateDupes(res, symlinkedRoots(), nil))
+	for _, g := range res.Groups {
+		assert.Empty(t, g.Keep)
+	}
+}
+
+func Test__FromMatch_leaves_out_symlinked_and_same_candidates(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	res := &match.Result{Matches: []*match.DirMatch{
+		{Source: p("/x/d"), Candidates: []*match.Candidate{{Target: p("/y/l"), Score: 1}, {Target: p("/z/d"), Score: 1}}},
+		{Source: p("/y/l"), Candidates: []*match.Candidate{{Target: p("/x/d"), Score: 1}}},
+		// "/y/s" contains a followed symlink.
+		{Source: p("/y/s"), Candidates: []*match.Candidate{{Target: p("/x/d"), Score: 1}}},
+	}}
+	gs, err := FromMatch(res, symlinkedRoots())
+	require.NoError(t, err)
+	assert.Empty(t, gs)
+}
+
+func Test__FromDupes_inconsistent_scans_fails(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	tests := []struct {
+		name    string
+		group   *dupes.Group
+		wantErr string
+	}{
+		{
+			name:    "path outside roots",
+			group:   &dupes.Group{Size: 1, Hash: 1, Paths: []string{p("/z/a")}},
+			wantErr: fmt.Sprintf("path %q is not inside the root of any scan", p("/z/a")),
+		},
+		{
+			name:    "missing file",
+			group:   &dupes.Group{Size: 1, Hash: 1, Paths: []string{p("/x/b")}},
+			wantErr: fmt.Sprintf("file %q not found in scan of %q", p("/x/b"), p("/x")),
+		},
+		{
+			name:    "missing directory",
+			group:   &dupes.Group{Dir: true, Paths: []string{p("/x/e")}},
+			wantErr: fmt.Sprintf("directory %q not found in scan of %q", p("/x/e"), p("/x")),
+		},
+		{
+			name:    "different file",
+			group:   &dupes.Group{Size: 2, Hash: 2, Paths: []string{p("/x/a")}},
+			wantErr: fmt.Sprintf("file %q has different contents in scan than in duplicates", p("/x/a")),
+		},
+		{
+			name:    "different directories",
+			group:   &dupes.Group{Dir: true, Paths: []string{p("/x/d"), p("/x")}},
+			wantErr: fmt.Sprintf("copies %q and %q have different contents in scans", p("/x/d"), p("/x")),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := FromDupes(&dupes.Result{Groups: []*dupes.Group{test.group}}, testRoots())
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
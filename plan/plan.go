@@ -0,0 +1,219 @@
+// Package plan implements the construction of reviewable plans for acting on duplicates
+// (as found by dupes.Find or match.Match), like removing all but one copy of each of them.
+// Plans are only written out (as JSON or shell scripts) for the user to review and execute;
+// nothing in this package modifies any files.
+package plan
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Action is what to do with the copies of duplicates that aren't kept.
+type Action string
+
+// Actions on copies that aren't kept.
+const (
+	// Remove removes the copies.
+	Remove Action = "remove"
+	// Hardlink replaces the copies with hardlinks to the kept copy.
+	Hardlink Action = "hardlink"
+	// Symlink replaces the copies with symlinks to the kept copy.
+	Symlink Action = "symlink"
+	// Quarantine moves the copies into a quarantine directory (preserving their paths within it).
+	Quarantine Action = "quarantine"
+)
+
+// Actions lists the valid actions.
+var Actions = []Action{Remove, Hardlink, Symlink, Quarantine}
+
+// File is a file of a copy.
+type File struct {
+	Path    string
+	Size    int64
+	Hash    uint64
+	ModTime int64
+	// Device is the ID of the device (i.e. file system) containing the file as recorded in the scan (0 if unknown).
+	Device uint64
+	// Inode is the inode number of the file on the device as recorded in the scan (0 if unknown).
+	Inode uint64
+}
+
+// sameFile returns whether the files are known to be the same file (i.e. hardlinks of each other).
+func (f *File) sameFile(g *File) bool {
+	return f.Inode != 0 && f.Device == g.Device && f.Inode == g.Inode
+}
+
+// Copy is a copy of duplicated contents: Either a single file or a directory of files.
+type Copy struct {
+	// Path of the file or directory.
+	Path string
+	// Files of the copy, sorted by contents (size and hash) and then path.
+	// The files of the copies of a group correspond to each other by position.
+	Files []*File
+	// Others is the number of other files (recursively) in the directory containing the copy (see RuleComplete).
+	Others int
+}
+
+// oldestModTime returns the oldest modification time of the files of the copy.
+func (c *Copy) oldestModTime() int64 {
+	var res int64
+	for i, f := range c.Files {
+		if i == 0 || f.ModTime < res {
+			res = f.ModTime
+		}
+	}
+	return res
+}
+
+// newestModTime returns the newest modification time of the files of the copy.
+func (c *Copy) newestModTime() int64 {
+	var res int64
+	for i, f := range c.Files {
+		if i == 0 || f.ModTime > res {
+			res = f.ModTime
+		}
+	}
+	return res
+}
+
+// Group is a group of copies with identical contents.
+type Group struct {
+	Copies []*Copy
+}
+
+// Step is the action to perform on a single file.
+type Step struct {
+	// Path of the file to act on.
+	Path string `json:"path"`
+	// Keep is the path of the copy that's kept in place of the file.
+	Keep string `json:"keep"`
+	// KeepRule is the rule that decided which copy to keep.
+	KeepRule string `json:"keep_rule"`
+	// Target is the path that the file is moved to (only for Quarantine).
+	Target string `json:"target,omitempty"`
+	// Size and hash of the file (and the kept copy) when they were scanned.
+	// The step must only be performed if both of the files still have this size and hash.
+	Size int64  `json:"size"`
+	Hash uint64 `json:"hash"`
+	// Device and inode numbers of the file and the kept copy when they were scanned (0 if unknown).
+	// Steps are never planned for files that are the same as their kept copy.
+	Device     uint64 `json:"dev,omitempty"`
+	Inode      uint64 `json:"ino,omitempty"`
+	KeepDevice uint64 `json:"keep_dev,omitempty"`
+	KeepInode  uint64 `json:"keep_ino,omitempty"`
+}
+
+// Plan is a list of actions to perform on duplicate files.
+type Plan struct {
+	Action Action `json:"action"`
+	// Quarantine is the directory that files are moved into (only for Quarantine).
+	Quarantine string `json:"quarantine,omitempty"`
+	// Steps lists the files to act on.
+	Steps []*Step `json:"steps"`
+	// Bytes is the total size of the files to act on.
+	Bytes int64 `json:"bytes"`
+}
+
+// Options configures Build.
+type Options struct {
+	Action Action
+	// Quarantine is the directory to move files into (required for and only used by Quarantine).
+	Quarantine string
+	// Rules for choosing the copy to keep (defaults to DefaultRules).
+	Rules Rules
+}
+
+// Build constructs the plan of performing the configured action on all copies but one of each of the provided groups.
+// The copy to keep is chosen using the configured rules, and copies protected by them are never acted on.
+// Groups are processed in order and a file that's acted on in one group is never kept in a later one (or vice versa),
+// such that groups that overlap (like matches of the same directory) cannot cause all copies of a file to be acted on.
+// Files that are known to be the same file as their kept copy (see File.Inode) are never acted on either.
+func Build(groups []*Group, opts Options) (*Plan, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	res := &Plan{Action: opts.Action, Quarantine: opts.Quarantine, Steps: []*Step{}}
+	kept := make(map[string]bool)
+	acted := make(map[string]bool)
+	for _, g := range groups {
+		var copies []*Copy
+		for _, c := range g.Copies {
+			if !actedOn(c, acted) {
+				copies = append(copies, c)
+			}
+		}
+		if len(copies) < 2 {
+			continue
+		}
+		k, rule := opts.Rules.Choose(copies)
+		for _, f := range k.Files {
+			kept[f.Path] = true
+		}
+		for _, c := range copies {
+			if c == k || opts.Rules.protected(c) {
+				continue
+			}
+			for i, f := range c.Files {
+				kf := k.Files[i]
+				if kept[f.Path] || f.sameFile(kf) {
+					continue
+				}
+				acted[f.Path] = true
+				s := &Step{
+					Path:       f.Path,
+					Keep:       kf.Path,
+					KeepRule:   rule,
+					Size:       f.Size,
+					Hash:       f.Hash,
+					Device:     f.Device,
+					Inode:      f.Inode,
+					KeepDevice: kf.Device,
+					KeepInode:  kf.Inode,
+				}
+				if opts.Action == Quarantine {
+					s.Target = QuarantinePath(opts.Quarantine, f.Path)
+				}
+				res.Steps = append(res.Steps, s)
+				res.Bytes += f.Size
+			}
+		}
+	}
+	return res, nil
+}
+
+func (o Options) validate() error {
+	valid := false
+	for _, a := range Actions {
+		if o.Action == a {
+			valid = true
+		}
+	}
+	if !valid {
+		return fmt.Errorf("invalid action %q (valid actions are %q)", o.Action, Actions)
+	}
+	if o.Action == Quarantine && o.Quarantine == "" {
+		return fmt.Errorf("no quarantine directory provided")
+	}
+	if o.Action != Quarantine && o.Quarantine != "" {
+		return fmt.Errorf("quarantine directory is only used by action %q", Quarantine)
+	}
+	return o.Rules.validate()
+}
+
+// actedOn returns whether any of the files of the provided copy are already acted on.
+func actedOn(c *Copy, acted map[string]bool) bool {
+	for _, f := range c.Files {
+		if acted[f.Path] {
+			return true
+		}
+	}
+	return false
+}
+
+// QuarantinePath returns the path in the provided quarantine directory to move the file at the provided path to.
+// The (absolute) path of the file is preserved inside the quarantine directory (without any volume name).
+func QuarantinePath(dir, path string) string {
+	return filepath.Join(dir, strings.TrimPrefix(path, filepath.VolumeName(path)))
+}
@@ -0,0 +1,147 @@
+package plan
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fileCopyOf(path string, size int64, hash uint64, modTime int64) *Copy {
+	return &Copy{Path: path, Files: []*File{{Path: path, Size: size, Hash: hash, ModTime: modTime}}}
+}
+
+func Test__Build_keeps_copy_chosen_by_rules(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	group := &Group{Copies: []*Copy{
+		fileCopyOf(p("/x/long/a"), 10, 1, 300),
+		fileCopyOf(p("/y/b"), 10, 1, 200),
+		fileCopyOf(p("/archive/a"), 10, 1, 400),
+		fileCopyOf(p("/z/a"), 10, 1, 200),
+	}}
+	tests := []struct {
+		name         string
+		rules        Rules
+		wantKeep     string
+		wantKeepRule string
+	}{
+		{name: "oldest by default", rules: nil, wantKeep: p("/y/b"), wantKeepRule: "path"}, // tie with "/z/a" is broken by path
+		{name: "oldest", rules: Rules{{Kind: RuleOldest}}, wantKeep: p("/y/b"), wantKeepRule: "path"},
+		{name: "newest", rules: Rules{{Kind: RuleNewest}}, wantKeep: p("/archive/a"), wantKeepRule: "newest"},
+		{name: "shortest", rules: Rules{{Kind: RuleShortest}}, wantKeep: p("/y/b"), wantKeepRule: "path"},
+		{name: "preferred", rules: Rules{{Kind: RulePrefer, Dir: p("/archive")}}, wantKeep: p("/archive/a"), wantKeepRule: "prefer " + p("/archive")},
+		{
+			name:         "first preferred",
+			rules:        Rules{{Kind: RulePrefer, Dir: p("/w")}, {Kind: RulePrefer, Dir: p("/x/")}, {Kind: RulePrefer, Dir: p("/archive")}},
+			wantKeep:     p("/x/long/a"),
+			wantKeepRule: "prefer " + p("/x/"),
+		},
+		{
+			name:         "prefix is directory",
+			rules:        Rules{{Kind: RulePrefer, Dir: p("/x/lo")}, {Kind: RuleShortest}},
+			wantKeep:     p("/y/b"),
+			wantKeepRule: "path",
+		},
+		{
+			name:         "later rule breaks tie",
+			rules:        Rules{{Kind: RuleOldest}, {Kind: RulePrefer, Dir: p("/z")}},
+			wantKeep:     p("/z/a"),
+			wantKeepRule: "prefer " + p("/z"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := Build([]*Group{group}, Options{Action: Remove, Rules: test.rules})
+			require.NoError(t, err)
+			require.Len(t, res.Steps, 3)
+			for _, s := range res.Steps {
+				assert.Equal(t, test.wantKeep, s.Keep)
+				assert.Equal(t, test.wantKeepRule, s.KeepRule)
+				assert.NotEqual(t, test.wantKeep, s.Path)
+			}
+			assert.EqualValues(t, 30, res.Bytes)
+		})
+	}
+}
+
+func Test__Build_pairs_files_of_directory_copies(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	group := &Group{Copies: []*Copy{
+		{Path: p("/x/d"), Files: []*File{{Path: p("/x/d/a"), Size: 1, Hash: 1, ModTime: 5}, {Path: p("/x/d/s/b"), Size: 2, Hash: 2, ModTime: 5}}},
+		{Path: p("/y/d"), Files: []*File{{Path: p("/y/d/a2"), Size: 1, Hash: 1, ModTime: 9}, {Path: p("/y/d/b"), Size: 2, Hash: 2, ModTime: 1}}},
+	}}
+	res, err := Build([]*Group{group}, Options{Action: Quarantine, Quarantine: p("/q")})
+	require.NoError(t, err)
+	// The oldest file of "/y/d" is older than any file of "/x/d".
+	assert.Equal(t, &Plan{
+		Action:     Quarantine,
+		Quarantine: p("/q"),
+		Steps: []*Step{
+			{Path: p("/x/d/a"), Keep: p("/y/d/a2"), KeepRule: "oldest", Target: p("/q/x/d/a"), Size: 1, Hash: 1},
+			{Path: p("/x/d/s/b"), Keep: p("/y/d/b"), KeepRule: "oldest", Target: p("/q/x/d/s/b"), Size: 2, Hash: 2},
+		},
+		Bytes: 3,
+	}, res)
+}
+
+func Test__Build_never_acts_on_kept_files(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	groups := []*Group{
+		{Copies: []*Copy{fileCopyOf(p("/x/a"), 10, 1, 1), fileCopyOf(p("/y/a"), 10, 1, 2)}},
+		// Overlapping group (like from matches of different source directories against the same target):
+		// "/y/a" has already been acted on and "/x/a" is kept, so nothing is left to do.
+		{Copies: []*Copy{fileCopyOf(p("/y/a"), 10, 1, 2), fileCopyOf(p("/z/a"), 10, 1, 0)}},
+		{Copies: []*Copy{fileCopyOf(p("/w/a"), 10, 1, 0), fileCopyOf(p("/x/a"), 10, 1, 1)}},
+	}
+	res, err := Build(groups, Options{Action: Remove})
+	require.NoError(t, err)
+	assert.Equal(t, []*Step{{Path: p("/y/a"), Keep: p("/x/a"), KeepRule: "oldest", Size: 10, Hash: 1}}, res.Steps)
+}
+
+func Test__Build_never_acts_on_same_file_as_kept_copy(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	group := &Group{Copies: []*Copy{
+		{Path: p("/x/d"), Files: []*File{{Path: p("/x/d/a"), Size: 1, Hash: 1, ModTime: 1, Device: 1, Inode: 10}, {Path: p("/x/d/b"), Size: 2, Hash: 2, ModTime: 1, Device: 1, Inode: 11}}},
+		{Path: p("/y/d"), Files: []*File{{Path: p("/y/d/a"), Size: 1, Hash: 1, ModTime: 2, Device: 1, Inode: 10}, {Path: p("/y/d/b"), Size: 2, Hash: 2, ModTime: 2, Device: 1, Inode: 12}}},
+	}}
+	res, err := Build([]*Group{group}, Options{Action: Remove})
+	require.NoError(t, err)
+	// "/y/d/a" is a hardlink of "/x/d/a", so only "/y/d/b" is acted on.
+	assert.Equal(t, []*Step{
+		{Path: p("/y/d/b"), Keep: p("/x/d/b"), KeepRule: "oldest", Size: 2, Hash: 2, Device: 1, Inode: 12, KeepDevice: 1, KeepInode: 11},
+	}, res.Steps)
+}
+
+func Test__Build_never_acts_on_protected_copies(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	group := &Group{Copies: []*Copy{
+		fileCopyOf(p("/ro/a"), 10, 1, 3),
+		fileCopyOf(p("/ro/b"), 10, 1, 2),
+		fileCopyOf(p("/x/a"), 10, 1, 1),
+		fileCopyOf(p("/y/a"), 10, 1, 4),
+	}}
+	// The protecting rule applies even though it comes last (and doesn't affect which copy is kept).
+	res, err := Build([]*Group{group}, Options{Action: Remove, Rules: Rules{{Kind: RuleOldest}, {Kind: RuleProtect, Dir: p("/ro")}}})
+	require.NoError(t, err)
+	assert.Equal(t, []*Step{{Path: p("/y/a"), Keep: p("/x/a"), KeepRule: "oldest", Size: 10, Hash: 1}}, res.Steps)
+}
+
+func Test__Build_invalid_options_fails(t *testing.T) {
+	tests := []struct {
+		opts    Options
+		wantErr string
+	}{
+		{opts: Options{}, wantErr: `invalid action "" (valid actions are ["remove" "hardlink" "symlink" "quarantine"])`},
+		{opts: Options{Action: "x"}, wantErr: `invalid action "x" (valid actions are ["remove" "hardlink" "symlink" "quarantine"])`},
+		{opts: Options{Action: Quarantine}, wantErr: "no quarantine directory provided"},
+		{opts: Options{Action: Remove, Quarantine: "q"}, wantErr: `quarantine directory is only used by action "quarantine"`},
+		{opts: Options{Action: Remove, Rules: Rules{{Kind: "x"}}}, wantErr: `invalid rule "x" (valid rules are ["prefer" "protect" "oldest" "newest" "shortest" "complete"])`},
+	}
+	for _, test := range tests {
+		t.Run(test.wantErr, func(t *testing.T) {
+			_, err := Build(nil, test.opts)
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
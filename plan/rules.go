@@ -0,0 +1,208 @@
+package plan
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bisgardo/dupe-nukem/util"
+)
+
+// RuleKind is the kind of a rule for choosing the copy to keep among the copies of duplicates.
+type RuleKind string
+
+// Kinds of rules.
+const (
+	// RulePrefer prefers copies inside a directory.
+	RulePrefer RuleKind = "prefer"
+	// RuleProtect never acts on copies inside a directory (like read-only or otherwise precious roots).
+	// Unlike the other rules, it doesn't affect which copy is kept and applies regardless of its position.
+	RuleProtect RuleKind = "protect"
+	// RuleOldest prefers the copy with the oldest modification time
+	// (for directories, the oldest modification time of any of their files).
+	RuleOldest RuleKind = "oldest"
+	// RuleNewest prefers the copy with the newest modification time
+	// (for directories, the newest modification time of any of their files).
+	RuleNewest RuleKind = "newest"
+	// RuleShortest prefers the copy with the shortest path.
+	RuleShortest RuleKind = "shortest"
+	// RuleComplete prefers the copy whose directory is otherwise most complete,
+	// i.e. whose parent directory contains the most other files.
+	// This avoids breaking up larger collections of files to keep stray copies.
+	RuleComplete RuleKind = "complete"
+)
+
+// ruleKinds lists the valid kinds of rules.
+var ruleKinds = []RuleKind{RulePrefer, RuleProtect, RuleOldest, RuleNewest, RuleShortest, RuleComplete}
+
+// Rule is a rule for choosing the copy to keep among the copies of duplicates.
+type Rule struct {
+	Kind RuleKind
+	// Dir is the directory of the rule (only for RulePrefer and RuleProtect).
+	Dir string
+}
+
+// String returns the rule as it would be written in a rules file.
+func (r Rule) String() string {
+	if r.Dir == "" {
+		return string(r.Kind)
+	}
+	return string(r.Kind) + " " + r.Dir
+}
+
+// Rules is an ordered list of rules.
+// Copies are ranked by the first rule that distinguishes them, then by the next one, and so on.
+// Any remaining ties are broken by the paths of the copies such that the choice is deterministic.
+type Rules []Rule
+
+// DefaultRules are the rules used if none are provided.
+var DefaultRules = Rules{{Kind: RuleOldest}}
+
+// pathRule is the name of the final tie-breaking rule (by path) when reporting which rule decided a choice.
+const pathRule = "path"
+
+// ParseRule parses a rule of the form '<kind>' or '<kind> <dir>' (for the kinds that take a directory).
+func ParseRule(s string) (Rule, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), " ", 2)
+	r := Rule{Kind: RuleKind(parts[0])}
+	if len(parts) == 2 {
+		r.Dir = strings.TrimSpace(parts[1])
+	}
+	if err := r.validate(); err != nil {
+		return Rule{}, err
+	}
+	if r.Dir != "" {
+		r.Dir = filepath.Clean(r.Dir)
+	}
+	return r, nil
+}
+
+func (r Rule) validate() error {
+	switch r.Kind {
+	case RulePrefer, RuleProtect:
+		if r.Dir == "" {
+			return fmt.Errorf("rule %q requires a directory", r.Kind)
+		}
+		return nil
+	case RuleOldest, RuleNewest, RuleShortest, RuleComplete:
+		if r.Dir != "" {
+			return fmt.Errorf("rule %q doesn't take a directory", r.Kind)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid rule %q (valid rules are %q)", r.Kind, ruleKinds)
+}
+
+// ParseRules parses a rules file with one rule (see ParseRule) on each line.
+// Empty lines and lines starting with '#' are ignored.
+func ParseRules(r io.Reader) (Rules, error) {
+	var res Rules
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := ParseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		res = append(res, rule)
+	}
+	return res, s.Err()
+}
+
+func (rs Rules) validate() error {
+	for _, r := range rs {
+		if err := r.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Choose returns the copy to keep among the provided ones along with the rule that decided the choice
+// (or "path" if the copies were only distinguished by their paths).
+func (rs Rules) Choose(copies []*Copy) (*Copy, string) {
+	if len(rs) == 0 {
+		rs = DefaultRules
+	}
+	cs := make([]*Copy, len(copies))
+	copy(cs, copies)
+	sort.SliceStable(cs, func(i, j int) bool {
+		if c, _ := rs.compare(cs[i], cs[j]); c != 0 {
+			return c < 0
+		}
+		return cs[i].Path < cs[j].Path
+	})
+	if len(cs) < 2 {
+		return cs[0], pathRule
+	}
+	if c, r := rs.compare(cs[0], cs[1]); c != 0 {
+		return cs[0], r.String()
+	}
+	return cs[0], pathRule
+}
+
+// compare returns a negative number if copy a is preferred over copy b, a positive number if b is preferred over a,
+// and 0 if no rule distinguishes them.
+// The rule that distinguishes them is returned as well.
+func (rs Rules) compare(a, b *Copy) (int, Rule) {
+	for _, r := range rs {
+		if c := r.compare(a, b); c != 0 {
+			return c, r
+		}
+	}
+	return 0, Rule{}
+}
+
+func (r Rule) compare(a, b *Copy) int {
+	switch r.Kind {
+	case RulePrefer:
+		return compareBool(util.IsSubpath(r.Dir, a.Path), util.IsSubpath(r.Dir, b.Path))
+	case RuleOldest:
+		return compareInt(a.oldestModTime(), b.oldestModTime())
+	case RuleNewest:
+		return compareInt(b.newestModTime(), a.newestModTime())
+	case RuleShortest:
+		return compareInt(int64(len(a.Path)), int64(len(b.Path)))
+	case RuleComplete:
+		return compareInt(int64(b.Others), int64(a.Others))
+	}
+	return 0
+}
+
+// protected returns whether the provided copy must not be acted on.
+func (rs Rules) protected(c *Copy) bool {
+	for _, r := range rs {
+		if r.Kind == RuleProtect && util.IsSubpath(r.Dir, c.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareBool returns -1 if only a is true, 1 if only b is true, and 0 otherwise.
+func compareBool(a, b bool) int {
+	switch {
+	case a && !b:
+		return -1
+	case b && !a:
+		return 1
+	}
+	return 0
+}
+
+// compareInt returns -1 if a is smaller than b, 1 if a is larger, and 0 if they're equal.
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,98 @@
+package plan
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test__ParseRules_parses_rules_file(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	f := strings.Join([]string{
+		"# Never touch the read-only backup.",
+		"protect " + p("/mnt/backup/"),
+		"",
+		"  prefer " + p("/archive") + "  ",
+		"complete",
+		"oldest",
+		"newest",
+		"shortest",
+	}, "\n")
+	rules, err := ParseRules(strings.NewReader(f))
+	require.NoError(t, err)
+	assert.Equal(t, Rules{
+		{Kind: RuleProtect, Dir: p("/mnt/backup")},
+		{Kind: RulePrefer, Dir: p("/archive")},
+		{Kind: RuleComplete},
+		{Kind: RuleOldest},
+		{Kind: RuleNewest},
+		{Kind: RuleShortest},
+	}, rules)
+	assert.Equal(t, "prefer "+p("/archive"), rules[1].String())
+	assert.Equal(t, "complete", rules[2].String())
+}
+
+func Test__ParseRules_invalid_rule_fails(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr string
+	}{
+		{input: "oldest\nyoungest", wantErr: `line 2: invalid rule "youngest" (valid rules are ["prefer" "protect" "oldest" "newest" "shortest" "complete"])`},
+		{input: "prefer", wantErr: `line 1: rule "prefer" requires a directory`},
+		{input: "\nprotect ", wantErr: `line 2: rule "protect" requires a directory`},
+		{input: "oldest x", wantErr: `line 1: rule "oldest" doesn't take a directory`},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			_, err := ParseRules(strings.NewReader(test.input))
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
+
+func Test__Rules_Choose_prefers_complete_directory(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	stray := fileCopyOf(p("/misc/a"), 10, 1, 1)
+	collection := fileCopyOf(p("/photos/2019/a"), 10, 1, 2)
+	collection.Others = 99
+	k, rule := Rules{{Kind: RuleComplete}, {Kind: RuleOldest}}.Choose([]*Copy{stray, collection})
+	assert.Equal(t, collection, k)
+	assert.Equal(t, "complete", rule)
+	k, rule = Rules{{Kind: RuleOldest}, {Kind: RuleComplete}}.Choose([]*Copy{stray, collection})
+	assert.Equal(t, stray, k)
+	assert.Equal(t, "oldest", rule)
+}
+
+func Test__Rules_Choose_compares_directories_by_oldest_and_newest_files(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	dirCopyOf := func(path string, modTimes ...int64) *Copy {
+		c := &Copy{Path: path}
+		for i, m := range modTimes {
+			c.Files = append(c.Files, &File{Path: filepath.Join(path, fmt.Sprint(i)), Size: 10, Hash: uint64(i), ModTime: m})
+		}
+		return c
+	}
+	// "x" has both the oldest and the newest file; "y" is entirely in between.
+	x, y := dirCopyOf(p("/x"), 1, 4), dirCopyOf(p("/y"), 2, 3)
+	k, rule := Rules{{Kind: RuleOldest}}.Choose([]*Copy{y, x})
+	assert.Equal(t, x, k)
+	assert.Equal(t, "oldest", rule)
+	k, rule = Rules{{Kind: RuleNewest}}.Choose([]*Copy{y, x})
+	assert.Equal(t, x, k)
+	assert.Equal(t, "newest", rule)
+}
+
+func Test__Rules_Choose_is_independent_of_order_of_copies(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	a, b := fileCopyOf(p("/x/a"), 10, 1, 1), fileCopyOf(p("/y/a"), 10, 1, 1)
+	k1, rule1 := Rules(nil).Choose([]*Copy{a, b})
+	k2, rule2 := Rules(nil).Choose([]*Copy{b, a})
+	assert.Equal(t, a, k1)
+	assert.Equal(t, a, k2)
+	assert.Equal(t, "path", rule1)
+	assert.Equal(t, "path", rule2)
+}
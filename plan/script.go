@@ -0,0 +1,84 @@
+package plan
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// scriptHeader is the preamble of the shell script of a plan.
+// The function 'check' verifies that a file is still a regular file with the provided size and hash
+// (as computed by the 'hash' command of dupe-nukem).
+// The function 'distinct' verifies that two paths aren't the same file
+// (by comparing their device and inode numbers using GNU or BSD stat),
+// like when one of them is reached through a symlinked directory.
+const scriptHeader = `#!/bin/sh
+# Review this script carefully before running it!
+# Each file is only acted on if both it and the copy that is kept in its place
+# still have the size and hash that they had when they were scanned
+# and they aren't the same file (i.e. have different device and inode numbers).
+# Files that fail this check are skipped (and reported on stderr).
+# Set DUPE_NUKEM to the path of the dupe-nukem executable if it isn't on PATH.
+set -u
+dupe_nukem="${DUPE_NUKEM:-dupe-nukem}"
+
+check() {
+	if [ -f "$1" ] && [ ! -L "$1" ] && [ $(wc -c < "$1") -eq "$2" ] && [ "$("$dupe_nukem" hash --file "$1")" = "$3" ]; then
+		return 0
+	fi
+	echo "skipping: $1 has changed since it was scanned" >&2
+	return 1
+}
+
+fileid() {
+	stat -c %d:%i -- "$1" 2>/dev/null || stat -f %d:%i -- "$1" 2>/dev/null
+}
+
+distinct() {
+	id1="$(fileid "$1")"
+	id2="$(fileid "$2")"
+	if [ -n "$id1" ] && [ -n "$id2" ] && [ "$id1" != "$id2" ]; then
+		return 0
+	fi
+	echo "skipping: $1 may be the same file as $2" >&2
+	return 1
+}
+`
+
+// WriteScript writes the plan as a shell script.
+// Every command of the script is guarded by checks that the file to act on and the kept copy are unchanged
+// and are different files such that a stale plan cannot destroy the last copy of anything.
+func (p *Plan) WriteScript(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(scriptHeader)
+	fmt.Fprintf(bw, "\n# Plan: %s %d file(s) (%d byte(s)).\n", p.Action, len(p.Steps), p.Bytes)
+	for _, s := range p.Steps {
+		fmt.Fprintf(bw, "check %s %d %d && check %s %d %d && distinct %s %s && %s\n",
+			shellQuote(s.Path), s.Size, s.Hash,
+			shellQuote(s.Keep), s.Size, s.Hash,
+			shellQuote(s.Path), shellQuote(s.Keep),
+			p.command(s),
+		)
+	}
+	return bw.Flush()
+}
+
+// command returns the shell command performing the provided step.
+func (p *Plan) command(s *Step) string {
+	switch p.Action {
+	case Hardlink:
+		return fmt.Sprintf("ln -f -- %s %s", shellQuote(s.Keep), shellQuote(s.Path))
+	case Symlink:
+		return fmt.Sprintf("ln -sf -- %s %s", shellQuote(s.Keep), shellQuote(s.Path))
+	case Quarantine:
+		return fmt.Sprintf("mkdir -p -- %s && mv -- %s %s", shellQuote(filepath.Dir(s.Target)), shellQuote(s.Path), shellQuote(s.Target))
+	}
+	return fmt.Sprintf("rm -- %s", shellQuote(s.Path))
+}
+
+// shellQuote quotes the provided string for use as a single word in a shell command.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,35 @@
+package plan
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test__WriteScript_guards_commands(t *testing.T) {
+	tests := []struct {
+		action     Action
+		target     string
+		wantSuffix string
+	}{
+		{action: Remove, wantSuffix: `rm -- '/x/it'\''s'`},
+		{action: Hardlink, wantSuffix: `ln -f -- '/y/a' '/x/it'\''s'`},
+		{action: Symlink, wantSuffix: `ln -sf -- '/y/a' '/x/it'\''s'`},
+		{action: Quarantine, target: "/q/x/b", wantSuffix: "mkdir -p -- '" + filepath.FromSlash("/q/x") + `' && mv -- '/x/it'\''s' '/q/x/b'`},
+	}
+	for _, test := range tests {
+		t.Run(string(test.action), func(t *testing.T) {
+			p := &Plan{Action: test.action, Steps: []*Step{{Path: "/x/it's", Keep: "/y/a", Target: test.target, Size: 10, Hash: 42}}, Bytes: 10}
+			var sb strings.Builder
+			require.NoError(t, p.WriteScript(&sb))
+			script := sb.String()
+			assert.True(t, strings.HasPrefix(script, "#!/bin/sh\n"))
+			lines := strings.Split(strings.TrimSuffix(script, "\n"), "\n")
+			assert.Equal(t, "# Plan: "+string(test.action)+" 1 file(s) (10 byte(s)).", lines[len(lines)-2])
+			assert.Equal(t, `check '/x/it'\''s' 10 42 && check '/y/a' 10 42 && distinct '/x/it'\''s' '/y/a' && `+test.wantSuffix, lines[len(lines)-1])
+		})
+	}
+}
@@ -0,0 +1,210 @@
+// Package quarantine implements the execution of reviewed plans (see package plan)
+// by moving the files to act on into a quarantine directory instead of deleting them.
+// Every file is re-verified against the plan right before it's moved,
+// and the moves are recorded in a manifest that allows them to be undone using Restore.
+// As each move is recorded as soon as it's done (see Options.Record and WriteEntry),
+// the manifest remains usable even if the run is interrupted.
+package quarantine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/bisgardo/dupe-nukem/hash"
+	"github.com/bisgardo/dupe-nukem/plan"
+	"github.com/bisgardo/dupe-nukem/util"
+)
+
+// Entry is a file that has been (or would be) moved into quarantine.
+type Entry struct {
+	// Path that the file was moved from.
+	Path string `json:"path"`
+	// Target is the path in the quarantine directory that the file was moved to.
+	Target string `json:"target"`
+	// Keep is the path of the copy that was kept in place of the file.
+	Keep string `json:"keep"`
+	// Size and hash of the file as verified before moving it.
+	Size int64  `json:"size"`
+	Hash uint64 `json:"hash"`
+}
+
+// Skip is a file that was skipped because it (or its kept copy) couldn't be verified or moved.
+type Skip struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// Manifest records the files that have been moved (by Run) or moved back (by Restore).
+// The manifest written by Run is the input for undoing the moves with Restore.
+type Manifest struct {
+	// DryRun is true if no files have actually been moved.
+	DryRun bool `json:"dry_run"`
+	// Entries lists the files that have been moved.
+	Entries []*Entry `json:"entries"`
+	// Skipped lists the files that have not been moved.
+	Skipped []*Skip `json:"skipped,omitempty"`
+	// Bytes is the total size of the files that have been moved.
+	Bytes int64 `json:"bytes"`
+}
+
+// Options configures Run.
+type Options struct {
+	// Dir is the quarantine directory to move files into.
+	// If empty, the targets of the steps of the plan are used (which requires the plan to have action plan.Quarantine).
+	Dir string
+	// DryRun enables verifying the files without moving any of them.
+	DryRun bool
+	// Record is called (if not nil) with the entry of each file right after it's been moved
+	// and before moving the next one (i.e. never on dry runs).
+	// If it fails, the file is moved back and Run fails.
+	Record func(e *Entry) error
+}
+
+// Run moves the files of the steps of the provided plan into quarantine.
+// Before moving a file, it's verified that both it and its kept copy are still regular files
+// with the size and hash recorded in the plan, and that nothing exists at its target path.
+// Steps that fail verification (or fail to be moved) are skipped and recorded in the returned manifest.
+// Files are moved by renaming, so the quarantine directory must be on the same filesystem as the files.
+// Each move is passed to Options.Record as soon as it's done.
+func Run(p *plan.Plan, opts Options) (*Manifest, error) {
+	if opts.Dir == "" && p.Action != plan.Quarantine {
+		return nil, fmt.Errorf("no quarantine directory provided for plan with action %q", p.Action)
+	}
+	res := &Manifest{DryRun: opts.DryRun, Entries: []*Entry{}}
+	for _, s := range p.Steps {
+		target := s.Target
+		if opts.Dir != "" {
+			target = plan.QuarantinePath(opts.Dir, s.Path)
+		}
+		if err := runStep(s, target, opts.DryRun); err != nil {
+			res.Skipped = append(res.Skipped, &Skip{Path: s.Path, Reason: err.Error()})
+			continue
+		}
+		e := &Entry{Path: s.Path, Target: target, Keep: s.Keep, Size: s.Size, Hash: s.Hash}
+		if !opts.DryRun && opts.Record != nil {
+			if err := opts.Record(e); err != nil {
+				if moveErr := os.Rename(target, s.Path); moveErr != nil {
+					return nil, fmt.Errorf("cannot record move of %q to %q (and cannot move it back: %v): %v", s.Path, target, util.CleanIOError(moveErr), err) // cannot test
+				}
+				return nil, fmt.Errorf("cannot record move of %q: %v", s.Path, err)
+			}
+		}
+		res.Entries = append(res.Entries, e)
+		res.Bytes += s.Size
+	}
+	return res, nil
+}
+
+func runStep(s *plan.Step, target string, dryRun bool) error {
+	if target == "" {
+		return fmt.Errorf("no target path")
+	}
+	if s.Keep == s.Path {
+		return fmt.Errorf("file is its own kept copy")
+	}
+	info, err := verify(s.Path, s.Size, s.Hash)
+	if err != nil {
+		return err
+	}
+	keepInfo, err := verify(s.Keep, s.Size, s.Hash)
+	if err != nil {
+		return fmt.Errorf("kept copy %q: %v", s.Keep, err)
+	}
+	// The paths may still refer to the same file through hardlinks or symlinked directories.
+	if os.SameFile(info, keepInfo) {
+		return fmt.Errorf("is the same file as kept copy %q", s.Keep)
+	}
+	return move(s.Path, target, dryRun)
+}
+
+// WriteEntry writes the provided entry to the provided writer as a single line of JSON.
+// A manifest file is a sequence of such lines that may be read back using ReadManifest.
+func WriteEntry(w io.Writer, e *Entry) error {
+	return json.NewEncoder(w).Encode(e)
+}
+
+// ReadManifest reads the entries written using WriteEntry from the provided reader into a manifest.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	res := &Manifest{Entries: []*Entry{}}
+	dec := json.NewDecoder(r)
+	for {
+		var e Entry
+		if err := dec.Decode(&e); err == io.EOF {
+			return res, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid entry %d: %v", len(res.Entries)+1, util.CleanJSONError(err))
+		}
+		if e.Path == "" || e.Target == "" {
+			return nil, fmt.Errorf("invalid entry %d: missing path or target", len(res.Entries)+1)
+		}
+		res.Entries = append(res.Entries, &e)
+		res.Bytes += e.Size
+	}
+}
+
+// Restore moves the files of the entries of the provided manifest (as returned by Run) back to where they were moved from.
+// Before moving a file back, it's verified that it still has the recorded size and hash
+// and that nothing exists at its original path.
+// The returned manifest records the files that have been moved back.
+func Restore(m *Manifest, dryRun bool) *Manifest {
+	res := &Manifest{DryRun: dryRun, Entries: []*Entry{}}
+	for _, e := range m.Entries {
+		_, err := verify(e.Target, e.Size, e.Hash)
+		if err == nil {
+			err = move(e.Target, e.Path, dryRun)
+		}
+		if err != nil {
+			res.Skipped = append(res.Skipped, &Skip{Path: e.Target, Reason: err.Error()})
+			continue
+		}
+		res.Entries = append(res.Entries, &Entry{Path: e.Target, Target: e.Path, Keep: e.Keep, Size: e.Size, Hash: e.Hash})
+		res.Bytes += e.Size
+	}
+	return res
+}
+
+// verify checks that the file at the provided path is a regular file with the provided size and hash
+// and returns its info.
+func verify(path string, size int64, h uint64) (os.FileInfo, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot stat file: %v", util.CleanIOError(err))
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("is a %v", util.FileModeName(info.Mode()))
+	}
+	if info.Size() != size {
+		return nil, fmt.Errorf("size has changed from %d to %d", size, info.Size())
+	}
+	fh, err := hash.File(path)
+	if err != nil {
+		return nil, err
+	}
+	if fh != h {
+		return nil, fmt.Errorf("hash has changed from %d to %d", h, fh)
+	}
+	return info, nil
+}
+
+// move renames the file at the provided path to the provided target path (creating its parent directories)
+// unless something already exists at the target path.
+func move(path, target string, dryRun bool) error {
+	if _, err := os.Lstat(target); err == nil {
+		return fmt.Errorf("target %q already exists", target)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("cannot stat target %q: %v", target, util.CleanIOError(err))
+	}
+	if dryRun {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("cannot create directory of target %q: %v", target, util.CleanIOError(err))
+	}
+	if err := os.Rename(path, target); err != nil {
+		return fmt.Errorf("cannot move file to %q: %v", target, util.CleanIOError(err))
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package quarantine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/hash"
+	"github.com/bisgardo/dupe-nukem/plan"
+)
+
+func writeFile(t *testing.T, path, contents string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0644))
+}
+
+func readFile(t *testing.T, path string) string {
+	bs, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(bs)
+}
+
+// testPlan creates the files "x/a" and "y/a" with the provided contents in the provided directory
+// and returns the plan of moving the former into quarantine directory "q".
+func testPlan(t *testing.T, dir, contents string) *plan.Plan {
+	path, keep := filepath.Join(dir, "x", "a"), filepath.Join(dir, "y", "a")
+	writeFile(t, path, contents)
+	writeFile(t, keep, contents)
+	q := filepath.Join(dir, "q")
+	return &plan.Plan{
+		Action:     plan.Quarantine,
+		Quarantine: q,
+		Steps: []*plan.Step{
+			{Path: path, Keep: keep, Target: plan.QuarantinePath(q, path), Size: int64(len(contents)), Hash: hash.Bytes([]byte(contents))},
+		},
+		Bytes: int64(len(contents)),
+	}
+}
+
+func Test__Run_dry_run_moves_nothing(t *testing.T) {
+	dir := t.TempDir()
+	p := testPlan(t, dir, "abc")
+	s := p.Steps[0]
+
+	m, err := Run(p, Options{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, &Manifest{
+		DryRun:  true,
+		Entries: []*Entry{{Path: s.Path, Target: s.Target, Keep: s.Keep, Size: 3, Hash: s.Hash}},
+		Bytes:   3,
+	}, m)
+	assert.FileExists(t, s.Path)
+	assert.NoDirExists(t, filepath.Join(dir, "q"))
+}
+
+func Test__Run_moves_file_into_quarantine_and_Restore_moves_it_back(t *testing.T) {
+	dir := t.TempDir()
+	p := testPlan(t, dir, "abc")
+	s := p.Steps[0]
+
+	m, err := Run(p, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, &Manifest{
+		Entries: []*Entry{{Path: s.Path, Target: s.Target, Keep: s.Keep, Size: 3, Hash: s.Hash}},
+		Bytes:   3,
+	}, m)
+	assert.NoFileExists(t, s.Path)
+	assert.Equal(t, "abc", readFile(t, s.Target))
+	assert.Equal(t, "abc", readFile(t, s.Keep))
+
+	r := Restore(m, false)
+	assert.Equal(t, &Manifest{
+		Entries: []*Entry{{Path: s.Target, Target: s.Path, Keep: s.Keep, Size: 3, Hash: s.Hash}},
+		Bytes:   3,
+	}, r)
+	assert.NoFileExists(t, s.Target)
+	assert.Equal(t, "abc", readFile(t, s.Path))
+}
+
+func Test__Run_uses_provided_quarantine_directory(t *testing.T) {
+	dir := t.TempDir()
+	p := testPlan(t, dir, "abc")
+	p.Action = plan.Remove
+	p.Quarantine = ""
+	p.Steps[0].Target = ""
+	q := filepath.Join(dir, "q2")
+
+	m, err := Run(p, Options{Dir: q})
+	require.NoError(t, err)
+	require.Len(t, m.Entries, 1)
+	assert.Equal(t, plan.QuarantinePath(q, p.Steps[0].Path), m.Entries[0].Target)
+	assert.FileExists(t, m.Entries[0].Target)
+}
+
+func Test__Run_without_quarantine_directory_fails(t *testing.T) {
+	_, err := Run(&plan.Plan{Action: plan.Remove}, Options{})
+	assert.EqualError(t, err, `no quarantine directory provided for plan with action "remove"`)
+}
+
+func Test__Run_skips_changed_files(t *testing.T) {
+	tests := []struct {
+		name       string
+		change     func(t *testing.T, s *plan.Step)
+		wantReason func(s *plan.Step) string
+	}{
+		{
+			name:       "file removed",
+			change:     func(t *testing.T, s *plan.Step) { require.NoError(t, os.Remove(s.Path)) },
+			wantReason: func(*plan.Step) string { return "cannot stat file: not found" },
+		},
+		{
+			name: "file replaced by directory",
+			change: func(t *testing.T, s *plan.Step) {
+				require.NoError(t, os.Remove(s.Path))
+				require.NoError(t, os.Mkdir(s.Path, 0755))
+			},
+			wantReason: func(*plan.Step) string { return "is a directory" },
+		},
+		{
+			name:       "file size changed",
+			change:     func(t *testing.T, s *plan.Step) { writeFile(t, s.Path, "abcd") },
+			wantReason: func(*plan.Step) string { return "size has changed from 3 to 4" },
+		},
+		{
+			name:   "file contents changed",
+			change: func(t *testing.T, s *plan.Step) { writeFile(t, s.Path, "xyz") },
+			wantReason: func(s *plan.Step) string {
+				return fmt.Sprintf("hash has changed from %d to %d", s.Hash, hash.Bytes([]byte("xyz")))
+			},
+		},
+		{
+			name:       "kept copy removed",
+			change:     func(t *testing.T, s *plan.Step) { require.NoError(t, os.Remove(s.Keep)) },
+			wantReason: func(s *plan.Step) string { return fmt.Sprintf("kept copy %q: cannot stat file: not found", s.Keep) },
+		},
+		{
+			name:   "kept copy contents changed",
+			change: func(t *testing.T, s *plan.Step) { writeFile(t, s.Keep, "xyz") },
+			wantReason: func(s *plan.Step) string {
+				return fmt.Sprintf("kept copy %q: hash has changed from %d to %d", s.Keep, s.Hash, hash.Bytes([]byte("xyz")))
+			},
+		},
+		{
+			name: "kept copy is same file",
+			change: func(t *testing.T, s *plan.Step) {
+				require.NoError(t, os.Remove(s.Keep))
+				require.NoError(t, os.Link(s.Path, s.Keep))
+			},
+			wantReason: func(s *plan.Step) string { return fmt.Sprintf("is the same file as kept copy %q", s.Keep) },
+		},
+		{
+			name:       "target exists",
+			change:     func(t *testing.T, s *plan.Step) { writeFile(t, s.Target, "") },
+			wantReason: func(s *plan.Step) string { return fmt.Sprintf("target %q already exists", s.Target) },
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := testPlan(t, t.TempDir(), "abc")
+			s := p.Steps[0]
+			test.change(t, s)
+
+			m, err := Run(p, Options{})
+			require.NoError(t, err)
+			assert.Equal(t, &Manifest{Entries: []*Entry{}, Skipped: []*Skip{{Path: s.Path, Reason: test.wantReason(s)}}}, m)
+		})
+	}
+}
+
+func Test__Run_records_each_move_when_done(t *testing.T) {
+	p := testPlan(t, t.TempDir(), "abc")
+	s := p.Steps[0]
+	var recorded []*Entry
+	record := func(e *Entry) error {
+		// The file has already been moved.
+		assert.NoFileExists(t, e.Path)
+		assert.FileExists(t, e.Target)
+		recorded = append(recorded, e)
+		return nil
+	}
+
+	_, err := Run(p, Options{DryRun: true, Record: record})
+	require.NoError(t, err)
+	assert.Empty(t, recorded)
+
+	m, err := Run(p, Options{Record: record})
+	require.NoError(t, err)
+	assert.Equal(t, m.Entries, recorded)
+	assert.Equal(t, []*Entry{{Path: s.Path, Target: s.Target, Keep: s.Keep, Size: 3, Hash: s.Hash}}, recorded)
+}
+
+func Test__Run_moves_file_back_if_recording_fails(t *testing.T) {
+	p := testPlan(t, t.TempDir(), "abc")
+	s := p.Steps[0]
+
+	_, err := Run(p, Options{Record: func(*Entry) error { return fmt.Errorf("disk full") }})
+	assert.EqualError(t, err, fmt.Sprintf("cannot record move of %q: disk full", s.Path))
+	assert.Equal(t, "abc", readFile(t, s.Path))
+	assert.NoFileExists(t, s.Target)
+}
+
+func Test__ReadManifest_reads_written_entries(t *testing.T) {
+	entries := []*Entry{
+		{Path: "a", Target: "q/a", Keep: "b", Size: 3, Hash: 1},
+		{Path: "c", Target: "q/c", Keep: "d", Size: 4, Hash: 2},
+	}
+	var buf bytes.Buffer
+	for _, e := range entries {
+		require.NoError(t, WriteEntry(&buf, e))
+	}
+	assert.Equal(t, 2, strings.Count(buf.String(), "\n")) // one line per entry
+
+	m, err := ReadManifest(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, &Manifest{Entries: entries, Bytes: 7}, m)
+}
+
+func Test__ReadManifest_invalid_entry_fails(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr string
+	}{
+		{input: `{"path": "a", "target": "q/a"} x`, wantErr: "invalid entry 2: invalid JSON: invalid character 'x' looking for beginning of value"},
+		{input: `{"path": "a"}`, wantErr: "invalid entry 1: missing path or target"},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			_, err := ReadManifest(strings.NewReader(test.input))
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
+
+func Test__Restore_skips_changed_files(t *testing.T) {
+	dir := t.TempDir()
+	p := testPlan(t, dir, "abc")
+	s := p.Steps[0]
+	m, err := Run(p, Options{})
+	require.NoError(t, err)
+	writeFile(t, s.Path, "new")
+
+	r := Restore(m, false)
+	assert.Equal(t, &Manifest{Entries: []*Entry{}, Skipped: []*Skip{{Path: s.Target, Reason: fmt.Sprintf("target %q already exists", s.Path)}}}, r)
+	assert.Equal(t, "abc", readFile(t, s.Target))
+	assert.Equal(t, "new", readFile(t, s.Path))
+}
@@ -0,0 +1,92 @@
+package scan
+
+import (
+	"encoding/binary"
+
+	"github.com/bisgardo/dupe-nukem/hash"
+)
+
+// ComputeDirHashes computes the Hash and ContentHash of the provided Dir and all of its subdirectories
+// (overwriting any existing values).
+// The hashes are derived bottom-up from those of the files and subdirectories,
+// so two directories have the same hash if (and only if, disregarding collisions) their subtrees are identical.
+// This enables identical subtrees to be found with a single lookup instead of walking both trees.
+// The computation is deterministic, so it's safe to (re)compute the hashes of results that already include them
+// (or that were produced before they were introduced).
+// Both hashes are 0 for directories whose contents aren't fully known (see dirHash),
+// and thus also for all directories containing them.
+func ComputeDirHashes(d *Dir) {
+	for _, s := range d.Dirs {
+		ComputeDirHashes(s)
+	}
+	d.Hash = dirHash(d)
+	d.ContentHash = 0
+	if d.Hash == 0 {
+		return
+	}
+	for _, f := range d.Files {
+		if f.Size > 0 && !f.Unhashed && !f.Unstable {
+			d.ContentHash += fileContentHash(f)
+		}
+	}
+	for _, s := range d.Dirs {
+		d.ContentHash += s.ContentHash
+	}
+}
+
+// dirHash computes the hash of the sorted names and hashes of the files, symlinks, special files,
+// and subdirectories of the provided Dir.
+// The hashes of the subdirectories must already have been computed.
+// Returns 0 if the contents of the directory aren't fully known,
+// i.e. if any files or subdirectories were skipped, filtered, inaccessible, or not (reliably) hashed.
+func dirHash(d *Dir) uint64 {
+	if len(d.SkippedFiles) > 0 || len(d.SkippedDirs) > 0 || len(d.SkippedMounts) > 0 || len(d.FilteredFiles) > 0 ||
+		len(d.InaccessibleFiles) > 0 || len(d.InaccessibleDirs) > 0 {
+		return 0
+	}
+	h := hash.New()
+	write := func(kind byte, name string, n uint64, s string) {
+		// Prefix variable-length values with their length to avoid ambiguities.
+		var buf [1 + 8 + 8 + 8]byte
+		buf[0] = kind
+		binary.LittleEndian.PutUint64(buf[1:9], uint64(len(name)))
+		binary.LittleEndian.PutUint64(buf[9:17], n)
+		binary.LittleEndian.PutUint64(buf[17:], uint64(len(s)))
+		_, _ = h.Write(buf[:])
+		_, _ = h.Write([]byte(name))
+		_, _ = h.Write([]byte(s))
+	}
+	for _, f := range d.Files {
+		if f.Unhashed || f.Unstable {
+			return 0
+		}
+		write('f', f.Name, uint64(f.Size), "")
+		write('h', "", f.Hash, "")
+	}
+	for _, name := range d.EmptyFiles {
+		write('e', name, 0, "")
+	}
+	for _, l := range d.Symlinks {
+		write('l', l.Name, 0, l.Target)
+	}
+	for _, f := range d.SpecialFiles {
+		write('s', f.Name, 0, f.Type)
+	}
+	for _, s := range d.Dirs {
+		if s.Hash == 0 {
+			return 0
+		}
+		write('d', s.Name, s.Hash, "")
+	}
+	return h.Sum64()
+}
+
+// fileContentHash computes the hash of the size and hash of the provided file.
+// The content hash of a directory is the sum of those of all its files
+// which makes it independent of the names of the files as well as of the structure of the subdirectories.
+func fileContentHash(f *File) uint64 {
+	var buf [16]byte
+	binary.LittleEndian.PutUint64(buf[:8], uint64(f.Size))
+	binary.LittleEndian.PutUint64(buf[8:], f.Hash)
+	return hash.Bytes(buf[:])
+}
@@ -0,0 +1,100 @@
+package scan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testTree(name string) *Dir {
+	return &Dir{
+		Name: name,
+		Dirs: []*Dir{
+			{Name: "s", Files: []*File{{Name: "a", Size: 1, Hash: 1}}, EmptyFiles: []string{"e"}},
+		},
+		Files:    []*File{{Name: "b", Size: 2, Hash: 2}, {Name: "c", Size: 3, Hash: 3}},
+		Symlinks: []*Symlink{{Name: "l", Target: "b"}},
+	}
+}
+
+func Test__ComputeDirHashes_of_identical_trees_are_equal(t *testing.T) {
+	d1, d2 := testTree("x"), testTree("y") // name of the root itself doesn't matter
+	ComputeDirHashes(d1)
+	ComputeDirHashes(d2)
+	assert.NotZero(t, d1.Hash)
+	assert.NotZero(t, d1.ContentHash)
+	assert.Equal(t, d1.Hash, d2.Hash)
+	assert.Equal(t, d1.ContentHash, d2.ContentHash)
+	assert.NotEqual(t, d1.Hash, d1.Dirs[0].Hash)
+	assert.NotEqual(t, d1.ContentHash, d1.Dirs[0].ContentHash)
+
+	// Recomputing the hashes doesn't change them.
+	h, c := d1.Hash, d1.ContentHash
+	ComputeDirHashes(d1)
+	assert.Equal(t, h, d1.Hash)
+	assert.Equal(t, c, d1.ContentHash)
+}
+
+func Test__ComputeDirHashes_content_hash_ignores_names_and_structure(t *testing.T) {
+	orig := testTree("x")
+	ComputeDirHashes(orig)
+
+	tests := []struct {
+		name        string
+		modify      func(d *Dir)
+		wantHash    bool // whether Hash is expected to be unchanged
+		wantContent bool // whether ContentHash is expected to be unchanged
+	}{
+		{name: "renamed file", modify: func(d *Dir) { d.Files[0].Name = "b2" }, wantContent: true},
+		{name: "renamed subdirectory", modify: func(d *Dir) { d.Dirs[0].Name = "s2" }, wantContent: true},
+		{name: "renamed empty file", modify: func(d *Dir) { d.Dirs[0].EmptyFiles[0] = "e2" }, wantContent: true},
+		{name: "changed symlink", modify: func(d *Dir) { d.Symlinks[0].Target = "c" }, wantContent: true},
+		{
+			name: "file moved into subdirectory",
+			modify: func(d *Dir) {
+				d.Dirs[0].Files = append(d.Dirs[0].Files, d.Files[0])
+				d.Files = d.Files[1:]
+			},
+			wantContent: true,
+		},
+		{name: "modified file", modify: func(d *Dir) { d.Dirs[0].Files[0].Hash = 10 }},
+		{name: "resized file", modify: func(d *Dir) { d.Files[1].Size = 30 }},
+		{name: "added file", modify: func(d *Dir) { d.Files = append(d.Files, &File{Name: "d", Size: 4, Hash: 4}) }},
+		{name: "modification time", modify: func(d *Dir) { d.Files[0].ModTime = 100 }, wantHash: true, wantContent: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := testTree("x")
+			test.modify(d)
+			ComputeDirHashes(d)
+			assert.Equal(t, test.wantHash, d.Hash == orig.Hash)
+			assert.Equal(t, test.wantContent, d.ContentHash == orig.ContentHash)
+		})
+	}
+}
+
+func Test__ComputeDirHashes_of_incomplete_directory_is_unknown(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(d *Dir)
+	}{
+		{name: "skipped file", modify: func(d *Dir) { d.SkippedFiles = []string{"f"} }},
+		{name: "skipped dir", modify: func(d *Dir) { d.SkippedDirs = []string{"f"} }},
+		{name: "skipped mount", modify: func(d *Dir) { d.SkippedMounts = []string{"f"} }},
+		{name: "filtered file", modify: func(d *Dir) { d.FilteredFiles = []string{"f"} }},
+		{name: "inaccessible file", modify: func(d *Dir) { d.InaccessibleFiles = []*Inaccessible{{Name: "f"}} }},
+		{name: "inaccessible dir", modify: func(d *Dir) { d.InaccessibleDirs = []*Inaccessible{{Name: "f"}} }},
+		{name: "unhashed file", modify: func(d *Dir) { d.Files[0].Unhashed = true }},
+		{name: "unstable file", modify: func(d *Dir) { d.Files[0].Unstable = true }},
+		{name: "incomplete subdirectory", modify: func(d *Dir) { d.Dirs[0].SkippedFiles = []string{"f"} }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := testTree("x")
+			test.modify(d)
+			ComputeDirHashes(d)
+			assert.Zero(t, d.Hash)
+			assert.Zero(t, d.ContentHash)
+		})
+	}
+}
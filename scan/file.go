@@ -1,5 +1,11 @@
 package scan
 
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
 // Dir represents a directory as a name and lists of contained files and subdirectories.
 // All of these lists must be sorted to enable binary search.
 type Dir struct {
@@ -16,6 +22,32 @@ type Dir struct {
 	SkippedFiles []string `json:"skipped_files,omitempty"`
 	// Sorted list of subdirectories of the directory that were skipped when scanning.
 	SkippedDirs []string `json:"skipped_dirs,omitempty"`
+	// Sorted list of subdirectories of the directory that were skipped when scanning
+	// because they're mount points of other file systems.
+	SkippedMounts []string `json:"skipped_mounts,omitempty"`
+	// Sorted list of files in the directory that weren't included when scanning
+	// (because they didn't match the include patterns or were filtered out by size or modification time).
+	FilteredFiles []string `json:"filtered_files,omitempty"`
+	// Sorted list of symlinks in the directory.
+	// If symlinks were followed when scanning, then the scanned targets are also listed as files or subdirectories.
+	Symlinks []*Symlink `json:"symlinks,omitempty"`
+	// Sorted list of special files (named pipes, sockets, devices, etc.) in the directory.
+	SpecialFiles []*SpecialFile `json:"special_files,omitempty"`
+	// Sorted list of files in the directory that couldn't be accessed when scanning.
+	InaccessibleFiles []*Inaccessible `json:"inaccessible_files,omitempty"`
+	// Sorted list of subdirectories of the directory that couldn't be read when scanning.
+	InaccessibleDirs []*Inaccessible `json:"inaccessible_dirs,omitempty"`
+	// Hash of the names and contents of the files and subdirectories of the directory (see ComputeDirHashes).
+	// Directories have the same hash if their subtrees are identical.
+	// The value 0 means that the hash is unknown,
+	// either because the contents of the directory aren't fully known or because it wasn't computed.
+	Hash uint64 `json:"hash,omitempty"`
+	// Hash of the contents of the (non-empty and hashed) files in the subtree of the directory
+	// regardless of their names and the directory structure (see ComputeDirHashes).
+	// Directories have the same content hash if their subtrees contain the same files in any structure.
+	// The value 0 means that the subtree doesn't contain any such files, that its contents aren't fully known
+	// (like for Hash), or that the hash wasn't computed.
+	ContentHash uint64 `json:"content_hash,omitempty"`
 }
 
 // NewDir constructs a Dir.
@@ -61,14 +93,74 @@ func (d *Dir) AppendSkippedDir(dirName string) {
 	d.SkippedDirs = append(d.SkippedDirs, dirName)
 }
 
+// AppendSkippedMount appends the dir name to the list of subdirectories that were skipped by scan
+// for being mount points of other file systems.
+// The usage pattern must ensure that this doesn't break the ordering constraint
+// as the function doesn't ensure nor check this.
+func (d *Dir) AppendSkippedMount(dirName string) {
+	d.SkippedMounts = append(d.SkippedMounts, dirName)
+}
+
+// AppendFilteredFile appends the file name to the list of files that weren't included by scan.
+// The usage pattern must ensure that this doesn't break the ordering constraint
+// as the function doesn't ensure nor check this.
+func (d *Dir) AppendFilteredFile(name string) {
+	d.FilteredFiles = append(d.FilteredFiles, name)
+}
+
+// AppendSymlink appends a Symlink to the list of symlinks.
+// The usage pattern must ensure that this doesn't break the ordering constraint
+// as the function doesn't ensure nor check this.
+func (d *Dir) AppendSymlink(s *Symlink) {
+	d.Symlinks = append(d.Symlinks, s)
+}
+
+// AppendSpecialFile appends a SpecialFile to the list of special files.
+// The usage pattern must ensure that this doesn't break the ordering constraint
+// as the function doesn't ensure nor check this.
+func (d *Dir) AppendSpecialFile(f *SpecialFile) {
+	d.SpecialFiles = append(d.SpecialFiles, f)
+}
+
+// AppendInaccessibleFile appends an Inaccessible to the list of inaccessible files.
+// The usage pattern must ensure that this doesn't break the ordering constraint
+// as the function doesn't ensure nor check this.
+func (d *Dir) AppendInaccessibleFile(f *Inaccessible) {
+	d.InaccessibleFiles = append(d.InaccessibleFiles, f)
+}
+
+// AppendInaccessibleDir appends an Inaccessible to the list of inaccessible subdirectories.
+// The usage pattern must ensure that this doesn't break the ordering constraint
+// as the function doesn't ensure nor check this.
+func (d *Dir) AppendInaccessibleDir(s *Inaccessible) {
+	d.InaccessibleDirs = append(d.InaccessibleDirs, s)
+}
+
 // TODO: Add function for validating (or ensuring?) that the lists are indeed ordered correctly.
 
 // File represents a file as a name, size, modification time, and fnv hash.
 type File struct {
-	Name    string `json:"name"`
-	Size    int64  `json:"size"`
-	ModTime int64  `json:"ts"`
-	Hash    uint64 `json:"hash"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Modification time as a Unix timestamp (i.e. in whole seconds).
+	ModTime int64 `json:"ts"`
+	// Sub-second part of the modification time in nanoseconds.
+	// This is stored separately from ModTime for backwards compatibility with results that don't include it.
+	// The value 0 means that the sub-second part is unknown
+	// (which is also the case for file systems that only store whole seconds).
+	ModTimeNanos int64  `json:"ts_nanos,omitempty"`
+	Hash         uint64 `json:"hash"`
+	// Whether the file couldn't be hashed (in which case Hash is 0 and should be disregarded).
+	Unhashed bool `json:"unhashed,omitempty"`
+	// Whether the file kept changing while being hashed
+	// (in which case Hash may not correspond to the contents of any version of the file and should be disregarded).
+	Unstable bool `json:"unstable,omitempty"`
+	// Device and inode numbers identifying the file on the file system (0 if unavailable, like on Windows).
+	// Files with the same device and inode numbers are hardlinks to the same contents.
+	Device uint64 `json:"dev,omitempty"`
+	Inode  uint64 `json:"ino,omitempty"`
+	// Number of hardlinks to the file's contents, including ones outside the scanned tree (0 if unavailable).
+	Links uint64 `json:"nlink,omitempty"`
 }
 
 // NewFile constructs a File.
@@ -84,6 +176,98 @@ func NewFile(name string, size int64, modTime int64, hash uint64) *File {
 	}
 }
 
+// NewUnhashedFile constructs a File that couldn't be hashed.
+func NewUnhashedFile(name string, size int64, modTime int64) *File {
+	f := NewFile(name, size, modTime, 0)
+	f.Unhashed = true
+	return f
+}
+
+// ModTimeMatches returns whether the modification time of the file matches that of the provided one
+// within the provided tolerance (see ModTimesMatch).
+func (f *File) ModTimeMatches(g *File, tolerance time.Duration) bool {
+	return ModTimesMatch(f.ModTime, f.ModTimeNanos, g.ModTime, g.ModTimeNanos, tolerance)
+}
+
+// ModTimesMatch returns whether the provided modification times (as Unix timestamps and sub-second nanoseconds)
+// differ by no more than the provided tolerance.
+// If the sub-second part of either time is unknown (i.e. 0),
+// then the times are only compared with the precision of whole seconds.
+// A tolerance of a couple of seconds is useful when comparing against file systems with coarse precision
+// (like FAT which only stores timestamps with 2-second precision).
+func ModTimesMatch(sec1, nanos1, sec2, nanos2 int64, tolerance time.Duration) bool {
+	var d time.Duration
+	if nanos1 == 0 || nanos2 == 0 {
+		d = time.Duration(sec1-sec2) * time.Second
+	} else {
+		d = time.Duration(sec1-sec2)*time.Second + time.Duration(nanos1-nanos2)
+	}
+	if d < 0 {
+		d = -d
+	}
+	return d <= tolerance
+}
+
+// IsHardlinkOf returns whether the file is known to be a hardlink to the same contents as the provided one,
+// i.e. if they have the same device and inode numbers.
+// Such files are duplicates that don't occupy any extra space.
+func (f *File) IsHardlinkOf(g *File) bool {
+	return f.Inode != 0 && f.Device == g.Device && f.Inode == g.Inode
+}
+
+// Symlink represents a symbolic link as a name and the target path that it points to.
+type Symlink struct {
+	Name string `json:"name"`
+	// Target path of the symlink exactly as stored in the link (i.e. possibly relative to the directory of the link).
+	Target string `json:"target"`
+	// Whether the target (after following any further symlinks) doesn't exist or is inaccessible.
+	Broken bool `json:"broken,omitempty"`
+}
+
+// NewSymlink constructs a Symlink.
+func NewSymlink(name string, target string, broken bool) *Symlink {
+	if name == "" {
+		panic("symlink name cannot be empty")
+	}
+	return &Symlink{
+		Name:   name,
+		Target: target,
+		Broken: broken,
+	}
+}
+
+// SpecialFile represents a file that isn't a regular file, directory, nor symlink
+// (i.e. a named pipe, socket, device, etc.) as a name and the name of its type.
+type SpecialFile struct {
+	Name string `json:"name"`
+	// Name of the file's type as given by util.FileModeName.
+	Type string `json:"type"`
+}
+
+// NewSpecialFile constructs a SpecialFile.
+func NewSpecialFile(name string, typ string) *SpecialFile {
+	if name == "" {
+		panic("special file name cannot be empty")
+	}
+	return &SpecialFile{Name: name, Type: typ}
+}
+
+// Inaccessible represents a file or directory that couldn't be accessed
+// as a name and the error that prevented it.
+type Inaccessible struct {
+	Name string `json:"name"`
+	// Error message (cleaned with util.CleanIOError).
+	Error string `json:"error"`
+}
+
+// NewInaccessible constructs an Inaccessible.
+func NewInaccessible(name string, err string) *Inaccessible {
+	if name == "" {
+		panic("inaccessible file or directory name cannot be empty")
+	}
+	return &Inaccessible{Name: name, Error: err}
+}
+
 // SafeFindDir looks for a Dir with the given name in the subdirectory list of the given Dir.
 // Returns nil if the Dir is nil or doesn't have a subdirectory with that name.
 func SafeFindDir(d *Dir, name string) *Dir {
@@ -113,3 +297,24 @@ func SafeFindFile(d *Dir, name string) *File {
 	}
 	return nil
 }
+
+// SafeFindFileByPath looks for a File with the given path (relative to the given Dir)
+// by looking up each of the directories of the path with SafeFindDir and then the file with SafeFindFile.
+// Returns nil if the Dir is nil or doesn't contain a file with that path.
+func SafeFindFileByPath(d *Dir, path string) *File {
+	dir, name := filepath.Split(path)
+	return SafeFindFile(SafeFindDirByPath(d, dir), name)
+}
+
+// SafeFindDirByPath looks for a Dir with the given path (relative to the given Dir)
+// by looking up each of the directories of the path with SafeFindDir.
+// The empty path (or ".") identifies the given Dir itself.
+// Returns nil if the Dir is nil or doesn't contain a subdirectory with that path.
+func SafeFindDirByPath(d *Dir, path string) *Dir {
+	for _, name := range strings.Split(path, string(filepath.Separator)) {
+		if name != "" && name != "." {
+			d = SafeFindDir(d, name)
+		}
+	}
+	return d
+}
@@ -2,7 +2,9 @@ package scan
 
 import (
 	"fmt"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -15,6 +17,52 @@ func Test__safeFindFile_nil_returns_nil(t *testing.T) {
 	assert.Nil(t, SafeFindFile(nil, "x"))
 }
 
+func Test__IsHardlinkOf(t *testing.T) {
+	tests := []struct {
+		name string
+		f, g *File
+		want bool
+	}{
+		{name: "same identity", f: &File{Device: 1, Inode: 2}, g: &File{Device: 1, Inode: 2}, want: true},
+		{name: "different inode", f: &File{Device: 1, Inode: 2}, g: &File{Device: 1, Inode: 3}},
+		{name: "different device", f: &File{Device: 1, Inode: 2}, g: &File{Device: 2, Inode: 2}},
+		{name: "unknown identity", f: &File{}, g: &File{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, test.f.IsHardlinkOf(test.g))
+		})
+	}
+}
+
+func Test__ModTimesMatch(t *testing.T) {
+	tests := []struct {
+		name                       string
+		sec1, nanos1, sec2, nanos2 int64
+		tolerance                  time.Duration
+		want                       bool
+	}{
+		{name: "equal seconds", sec1: 10, sec2: 10, want: true},
+		{name: "different seconds", sec1: 10, sec2: 11},
+		{name: "equal nanos", sec1: 10, nanos1: 5, sec2: 10, nanos2: 5, want: true},
+		{name: "different nanos", sec1: 10, nanos1: 5, sec2: 10, nanos2: 6},
+		{name: "unknown nanos of first", sec1: 10, sec2: 10, nanos2: 6, want: true},
+		{name: "unknown nanos of second", sec1: 10, nanos1: 5, sec2: 10, want: true},
+		{name: "nanos within tolerance", sec1: 10, nanos1: 900000000, sec2: 11, nanos2: 100000000, tolerance: 200 * time.Millisecond, want: true},
+		{name: "nanos outside tolerance", sec1: 10, nanos1: 900000000, sec2: 11, nanos2: 100000001, tolerance: 200 * time.Millisecond},
+		{name: "seconds within tolerance", sec1: 12, sec2: 10, tolerance: 2 * time.Second, want: true},
+		{name: "seconds outside tolerance", sec1: 13, sec2: 10, tolerance: 2 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := &File{ModTime: test.sec1, ModTimeNanos: test.nanos1}
+			g := &File{ModTime: test.sec2, ModTimeNanos: test.nanos2}
+			assert.Equal(t, test.want, f.ModTimeMatches(g, test.tolerance))
+			assert.Equal(t, test.want, g.ModTimeMatches(f, test.tolerance))
+		})
+	}
+}
+
 //goland:noinspection GoSnakeCaseUsage
 var (
 	testDir_x = &Dir{
@@ -105,3 +153,19 @@ func Test__safeFindFile_finds_file(t *testing.T) {
 		})
 	}
 }
+
+func Test__SafeFindByPath(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	assert.True(t, SafeFindDirByPath(testDir_x, "") == testDir_x)
+	assert.True(t, SafeFindDirByPath(testDir_x, ".") == testDir_x)
+	assert.True(t, SafeFindDirByPath(testDir_x, p("y/z")) == testDir_z)
+	assert.True(t, SafeFindDirByPath(testDir_x, p("r/t")) == testDir_r.Dirs[1])
+	assert.Nil(t, SafeFindDirByPath(testDir_x, p("y/x")))
+	assert.Nil(t, SafeFindDirByPath(nil, "y"))
+
+	assert.True(t, SafeFindFileByPath(testDir_x, "a") == testDir_x.Files[0])
+	assert.True(t, SafeFindFileByPath(testDir_x, p("y/z/b")) == testDir_z.Files[1])
+	assert.True(t, SafeFindFileByPath(testDir_x, p("r/t/c")) == testDir_r.Dirs[1].Files[0])
+	assert.Nil(t, SafeFindFileByPath(testDir_x, p("y/z")))
+	assert.Nil(t, SafeFindFileByPath(testDir_x, p("z/a")))
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package scan
+
+import (
+	"os"
+	"syscall"
+)
+
+// FileIDOf extracts the device and inode numbers and the number of hardlinks of a file from its info.
+// The boolean return value indicates whether this information is available.
+func FileIDOf(info os.FileInfo) (FileID, bool) {
+	s, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return FileID{}, false // cannot test
+	}
+	// The types of the fields vary between platforms.
+	//goland:noinspection GoRedundantConversion
+	return FileID{
+		Device: uint64(s.Dev),
+		Inode:  uint64(s.Ino),
+		Links:  uint64(s.Nlink),
+	}, true
+}
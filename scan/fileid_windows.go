@@ -0,0 +1,11 @@
+package scan
+
+import (
+	"os"
+)
+
+// FileIDOf extracts the device and inode numbers and the number of hardlinks of a file from its info.
+// This information isn't available from os.FileInfo on Windows, so the boolean return value is always false.
+func FileIDOf(os.FileInfo) (FileID, bool) {
+	return FileID{}, false
+}
@@ -0,0 +1,38 @@
+package scan
+
+import (
+	"path/filepath"
+
+	"github.com/bisgardo/dupe-nukem/util"
+)
+
+// PathMapping maps paths in one directory to the corresponding paths in another one.
+// This is used for relating data scanned at one location (like a mount point on some host)
+// to the same data seen at another location.
+type PathMapping struct {
+	// From is the (clean) path of the directory to map paths from.
+	From string
+	// To is the (clean) path of the directory to map paths to.
+	To string
+}
+
+// PathMap is a list of path mappings.
+type PathMap []PathMapping
+
+// Map maps the provided (clean) path using the most specific mapping that applies to it,
+// i.e. the one with the longest From path that is equal to or contains the path.
+// The boolean return value indicates whether any mapping applied.
+// If not, the path is returned unchanged.
+func (m PathMap) Map(path string) (string, bool) {
+	var res *PathMapping
+	for i := range m {
+		p := &m[i]
+		if util.IsSubpath(p.From, path) && (res == nil || len(p.From) > len(res.From)) {
+			res = p
+		}
+	}
+	if res == nil {
+		return path, false
+	}
+	return filepath.Join(res.To, path[len(res.From):]), true
+}
@@ -0,0 +1,50 @@
+package scan
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test__PathMap_Map(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	m := PathMap{
+		{From: p("/mnt/usb1"), To: p("/media/backup")},
+		{From: p("/mnt/usb1/photos"), To: p("/photos")},
+		{From: p("/home"), To: p("/")},
+	}
+	tests := []struct {
+		path   string
+		want   string
+		wantOK bool
+	}{
+		{path: "/mnt/usb1", want: "/media/backup", wantOK: true},
+		{path: "/mnt/usb1/docs/x", want: "/media/backup/docs/x", wantOK: true},
+		{path: "/mnt/usb1/photos/2020", want: "/photos/2020", wantOK: true}, // most specific mapping
+		{path: "/mnt/usb10", want: "/mnt/usb10"},                            // not a subpath
+		{path: "/mnt", want: "/mnt"},
+		{path: "/home/x", want: "/x", wantOK: true},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			res, ok := m.Map(p(test.path))
+			assert.Equal(t, p(test.want), res)
+			assert.Equal(t, test.wantOK, ok)
+		})
+	}
+}
+
+func Test__PathMap_Map_from_root(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	m := PathMap{{From: p("/"), To: p("/mnt/x")}}
+	res, ok := m.Map(p("/a/b"))
+	assert.True(t, ok)
+	assert.Equal(t, p("/mnt/x/a/b"), res)
+}
+
+func Test__empty_PathMap_maps_nothing(t *testing.T) {
+	res, ok := PathMap(nil).Map("x")
+	assert.False(t, ok)
+	assert.Equal(t, "x", res)
+}
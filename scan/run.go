@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -14,7 +15,14 @@ import (
 
 // ShouldSkipPath is a function for determining whether a given path
 // should be skipped when walking a file tree.
-type ShouldSkipPath func(dir, name string) bool
+// The provided info is obtained without following symlinks (i.e. like os.Lstat).
+type ShouldSkipPath func(dir, name string, info os.FileInfo) bool
+
+// ShouldIncludeFile is a function for determining whether a given (non-directory) file
+// that isn't skipped should be included when walking a file tree.
+// Files that aren't included are only listed by name as filtered.
+// The provided info is obtained without following symlinks (i.e. like os.Lstat).
+type ShouldIncludeFile func(dir, name string, info os.FileInfo) bool
 
 // Result is the result of calling [Run].
 type Result struct {
@@ -26,8 +34,89 @@ type Result struct {
 	TypeVersion int `json:"schema_version"`
 	// Root is the scanned directory data as a recursive data structure.
 	Root *Dir `json:"root"`
+	// Errors lists the errors that were encountered (and recovered from) while scanning, in the order they happened.
+	Errors []*ErrorRecord `json:"errors,omitempty"`
+	// Metadata describes the context in which the scan was performed.
+	// It's missing from results that were produced before it was introduced.
+	Metadata *Metadata `json:"metadata,omitempty"`
+	// Stats summarizes how the hashes of the scanned files were obtained.
+	// It's missing from results that were produced before it was introduced.
+	Stats *Stats `json:"stats,omitempty"`
+	// Verification is the result of verifying the hashes against a previous scan (see Verify).
+	// This isn't done by Run and has to be set by the caller.
+	Verification *Verification `json:"verification,omitempty"`
+}
+
+// Stats summarizes how the hashes of the files of a scan were obtained,
+// and in particular how effective the caches were.
+type Stats struct {
+	// FilesHashed is the number of files whose contents were hashed.
+	FilesHashed int `json:"files_hashed"`
+	// BytesHashed is the total size of the files whose contents were hashed.
+	BytesHashed int64 `json:"bytes_hashed"`
+	// BytesReused is the total size of the files whose hash was reused
+	// (i.e. obtained from a cache or another hardlink to the same contents).
+	BytesReused int64 `json:"bytes_reused"`
+	// CacheHits is the number of files whose hash was found in the caches (of Options.Caches).
+	CacheHits int `json:"cache_hits"`
+	// CacheMisses is the number of files that weren't present in the caches.
+	CacheMisses int `json:"cache_misses"`
+	// CacheStale is the number of files that were present in the caches,
+	// but whose entry couldn't be used because the size or modification time had changed
+	// (or the entry was marked as unhashed or unstable or had hash value 0).
+	CacheStale int `json:"cache_stale"`
+	// CacheMissing is the number of files in the caches that no longer exist
+	// (among the directories that were scanned).
+	CacheMissing int `json:"cache_missing"`
+	// HashCacheHits is the number of files whose hash was found in the hash cache (of Options.HashCache).
+	HashCacheHits int `json:"hash_cache_hits,omitempty"`
+	// HardlinkHits is the number of files whose hash was reused from another hardlink to the same contents.
+	HardlinkHits int `json:"hardlink_hits,omitempty"`
+	// FilesUnstable is the number of files that kept changing while being hashed.
+	FilesUnstable int `json:"files_unstable,omitempty"`
+}
+
+// Metadata describes the context in which a scan was performed.
+// As the root path may be meaningless on its own (for instance if the scanned disk is later mounted elsewhere),
+// it includes the identity of the scanned volume (if it could be determined).
+type Metadata struct {
+	// ToolVersion is the version of dupe-nukem that performed the scan.
+	// This isn't known by Run and has to be set by the caller.
+	ToolVersion string `json:"tool_version,omitempty"`
+	// Hostname is the name of the host on which the scan was performed (empty if it couldn't be determined).
+	Hostname string `json:"hostname,omitempty"`
+	// StartTime is the time at which the scan started.
+	StartTime time.Time `json:"start_time"`
+	// EndTime is the time at which the scan ended.
+	EndTime time.Time `json:"end_time"`
+	// Volume identifies the file system containing the root directory (nil if it couldn't be determined).
+	Volume *Volume `json:"volume,omitempty"`
+}
+
+// ErrorRecord is a record of an error that was encountered (and recovered from) while scanning.
+type ErrorRecord struct {
+	// Path of the file or directory on which the operation failed.
+	Path string `json:"path"`
+	// Operation that failed (one of the Op* constants).
+	Op string `json:"op"`
+	// Error message (cleaned with util.CleanIOError).
+	Error string `json:"error"`
 }
 
+// Operations that may fail while scanning.
+const (
+	// OpStat is the operation of reading the metadata of a file or directory.
+	OpStat = "stat"
+	// OpList is the operation of listing the contents of a directory.
+	OpList = "list"
+	// OpReadlink is the operation of reading the target of a symlink.
+	OpReadlink = "readlink"
+	// OpResolve is the operation of resolving the target of a symlink that is being followed.
+	OpResolve = "resolve"
+	// OpHash is the operation of hashing the contents of a file.
+	OpHash = "hash"
+)
+
 // CurrentResultTypeVersion is the currently expected value of [Result.TypeVersion].
 // It identifies the exact semantics of serialized values of [Result] (and thus also [Dir] and [File]).
 // Any given build of dupe-nukem can decode any [Result] whose version matches its own value of this constant.
@@ -37,7 +126,7 @@ type Result struct {
 const CurrentResultTypeVersion = 1
 
 // NoSkip doesn't skip any files.
-func NoSkip(string, string) bool {
+func NoSkip(string, string, os.FileInfo) bool {
 	return false
 }
 
@@ -46,39 +135,221 @@ var _ ShouldSkipPath = NoSkip // declare that NoSkip conforms to ShouldSkipPath
 // SkipNameSet constructs a ShouldSkipPath which returns true
 // if the base name matches any of the names in the provided set.
 func SkipNameSet(names map[string]struct{}) ShouldSkipPath {
-	return func(dir, name string) bool {
+	return func(dir, name string, info os.FileInfo) bool {
 		_, ok := names[name]
 		return ok
 	}
 }
 
-// Run runs the "scan" command with all arguments provided.
+// IncludeAll includes all files.
+func IncludeAll(string, string, os.FileInfo) bool {
+	return true
+}
+
+var _ ShouldIncludeFile = IncludeAll // declare that IncludeAll conforms to ShouldIncludeFile
+
+// IncludeNamePatterns constructs a ShouldIncludeFile which returns true
+// if the base name matches any of the provided patterns.
+// The patterns use the syntax of filepath.Match and are assumed to be valid.
+func IncludeNamePatterns(patterns []string) ShouldIncludeFile {
+	return func(dir, name string, info os.FileInfo) bool {
+		for _, p := range patterns {
+			if ok, _ := filepath.Match(p, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SkipAny constructs a ShouldSkipPath which returns true
+// if any of the provided functions return true.
+func SkipAny(fs ...ShouldSkipPath) ShouldSkipPath {
+	return func(dir, name string, info os.FileInfo) bool {
+		for _, f := range fs {
+			if f(dir, name, info) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// FileFilter is a function for determining whether a given (non-skipped, included) file
+// should be filtered out based on its metadata (like size or modification time).
+// It returns the reason for filtering out the file or the empty string if the file should be kept.
+// Files that are filtered out are only listed by name as filtered (like files that aren't included).
+type FileFilter func(info os.FileInfo) string
+
+// FilterFileSize constructs a FileFilter which filters out regular files
+// that are smaller than minSize or (if maxSize is non-negative) larger than maxSize.
+// Other kinds of files are never filtered out.
+func FilterFileSize(minSize, maxSize int64) FileFilter {
+	return func(info os.FileInfo) string {
+		if !info.Mode().IsRegular() {
+			return ""
+		}
+		size := info.Size()
+		if size < minSize {
+			return fmt.Sprintf("size %d is smaller than min size %d", size, minSize)
+		}
+		if maxSize >= 0 && size > maxSize {
+			return fmt.Sprintf("size %d is larger than max size %d", size, maxSize)
+		}
+		return ""
+	}
+}
+
+// FilterFileModTime constructs a FileFilter which filters out regular files
+// that were modified no later than newerThan or no earlier than olderThan.
+// A zero time disables the corresponding bound.
+// Other kinds of files are never filtered out.
+func FilterFileModTime(newerThan, olderThan time.Time) FileFilter {
+	return func(info os.FileInfo) string {
+		if !info.Mode().IsRegular() {
+			return ""
+		}
+		t := info.ModTime()
+		if !newerThan.IsZero() && !t.After(newerThan) {
+			return fmt.Sprintf("modification time %v is not after %v", t, newerThan)
+		}
+		if !olderThan.IsZero() && !t.Before(olderThan) {
+			return fmt.Sprintf("modification time %v is not before %v", t, olderThan)
+		}
+		return ""
+	}
+}
+
+// FilterAny constructs a FileFilter which returns the reason of the first of the provided filters
+// that filters out the file.
+func FilterAny(fs ...FileFilter) FileFilter {
+	return func(info os.FileInfo) string {
+		for _, f := range fs {
+			if reason := f(info); reason != "" {
+				return reason
+			}
+		}
+		return ""
+	}
+}
+
+// HashCache is a cache of file hashes that (unlike the caches of Options.Caches) isn't tied to the directory structure.
+// This allows hashes to be reused for files that have been moved or renamed.
+type HashCache interface {
+	// Lookup returns the cached hash of the file identified by the provided key.
+	// The boolean return value indicates whether the hash was found.
+	Lookup(key FileKey) (uint64, bool)
+	// Store stores the hash of the file identified by the provided key.
+	Store(key FileKey, hash uint64)
+}
+
+// FileKey identifies a particular version of a file for the purpose of looking up its hash in a HashCache.
+type FileKey struct {
+	// Path of the file as it was walked (i.e. not necessarily the path that it has in the scan result).
+	Path string
+	// Device and inode numbers of the file (0 if unavailable).
+	Device uint64
+	Inode  uint64
+	// Size of the file.
+	Size int64
+	// Modification time of the file as a Unix timestamp and the sub-second part in nanoseconds.
+	ModTime      int64
+	ModTimeNanos int64
+}
+
+// Options holds the parameters of RunWithOptions besides the root directory.
+// The zero value scans everything without using any cache.
+type Options struct {
+	// ShouldSkip determines which files and directories to skip.
+	// If nil, nothing is skipped.
+	ShouldSkip ShouldSkipPath
+	// ShouldInclude determines which of the non-skipped files to include.
+	// If nil, all files are included.
+	ShouldInclude ShouldIncludeFile
+	// FileFilter determines which of the included files to filter out (and why).
+	// If nil, no files are filtered out.
+	FileFilter FileFilter
+	// Caches are the roots of previous scan results to use as caches for hashes.
+	// Each cache must cover the root directory, one of its ancestors, or one of its subdirectories.
+	// For any given directory, the cache of the closest directory that contains it is used.
+	// If empty, all hashes are computed.
+	Caches []*Dir
+	// HashCache is a cache of hashes to fall back to for files that aren't found in any of the caches.
+	// It's updated with the hashes of all the files that are scanned.
+	// If nil, no such cache is used.
+	HashCache HashCache
+	// HashRetries is the number of times to rehash a file whose size or modification time changed while it was being hashed.
+	// A file that still changes after the last retry is marked as unstable.
+	HashRetries int
+	// ModTimeTolerance is the maximum difference between the modification time of a file
+	// and the one of its entry in a cache (of Caches) for the entry to be used.
+	// See ModTimesMatch for details.
+	ModTimeTolerance time.Duration
+	// FollowSymlinks determines whether the targets of symlinks are scanned in place of the symlinks
+	// (in addition to recording the symlinks themselves).
+	// Symlinks to directories that would result in a cycle are never followed.
+	FollowSymlinks bool
+	// OneFileSystem determines whether to skip directories that are on another file system than the root
+	// (i.e. mount points), including the targets of followed symlinks.
+	// This is not supported on Windows.
+	OneFileSystem bool
+}
+
+// Run runs the "scan" command with the provided skip function and cache (which may be nil)
+// (see RunWithOptions).
+func Run(root string, shouldSkip ShouldSkipPath, cache *Dir) (*Result, error) {
+	var caches []*Dir
+	if cache != nil {
+		caches = []*Dir{cache}
+	}
+	return RunWithOptions(root, Options{ShouldSkip: shouldSkip, Caches: caches})
+}
+
+// RunWithOptions runs the "scan" command with all arguments provided.
 // If the root is a symlink, then this link is traversed recursively.
 // The root name of the scan result keeps the name of the original symlink.
 // The following sanity checks are performed:
-// - If a cache is provided, its root must have the same name as the provided root (after following any symlinks).
+// - The root of each provided cache is the provided root (after following any symlinks), an ancestor, or a subdirectory.
+// - No two provided caches have the same root.
 // - The root is an existing directory.
-func Run(root string, shouldSkip ShouldSkipPath, cache *Dir) (*Result, error) {
+// - If scanning a single file system, the device of the root can be determined.
+func RunWithOptions(root string, opts Options) (*Result, error) {
 	rootPath, err := resolveRoot(root)
 	if err != nil {
 		return nil, errors.Wrapf(util.CleanIOError(err), "invalid root directory %q", root)
 	}
-	if cache != nil && cache.Name != rootPath {
-		// While there's no technical reason for this requirement,
-		// it seems reasonable that differing root names would signal a mistake in most cases.
-		// For now, we keep it simple and just require the paths to match (one can always edit the file manually).
-		// In the future you could imagine this being relaxed in ways like:
-		// - Allow remapping the name.
-		// - Allow caches that only cover some subdirectory.
-		//   Could even allow multiple such files (using the one of the closest parent).
-		// - Bypass the check entirely.
-		return nil, fmt.Errorf("cache of directory %q cannot be used with root directory %q", cache.Name, rootPath)
-	}
-	res, err := run(rootPath, shouldSkip, cache)
-	return &Result{
-		TypeVersion: CurrentResultTypeVersion,
-		Root:        res,
-	}, errors.Wrapf(err, "cannot scan root directory %q", rootPath) // cannot test
+	var rootDevice uint64
+	if opts.OneFileSystem {
+		rootDevice, err = deviceOf(rootPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot determine file system of root directory %q", rootPath)
+		}
+	}
+	if opts.ShouldSkip == nil {
+		opts.ShouldSkip = NoSkip
+	}
+	if opts.ShouldInclude == nil {
+		opts.ShouldInclude = IncludeAll
+	}
+	if opts.FileFilter == nil {
+		opts.FileFilter = FilterAny()
+	}
+	for i, cache := range opts.Caches {
+		if !util.IsSubpath(cache.Name, rootPath) && !util.IsSubpath(rootPath, cache.Name) {
+			// While there's no technical reason for this requirement,
+			// it seems reasonable that unrelated root names would signal a mistake in most cases.
+			// The caller may remap the name using a PathMap if the data has moved.
+			// In the future you could imagine this being relaxed by bypassing the check entirely.
+			return nil, fmt.Errorf("cache of directory %q cannot be used with root directory %q", cache.Name, rootPath)
+		}
+		for _, c := range opts.Caches[:i] {
+			if c.Name == cache.Name {
+				return nil, fmt.Errorf("multiple caches of directory %q", cache.Name)
+			}
+		}
+	}
+	res, err := run(rootPath, rootDevice, opts)
+	return res, errors.Wrapf(err, "cannot scan root directory %q", rootPath) // cannot test
 }
 
 func resolveRoot(path string) (string, error) {
@@ -96,6 +367,19 @@ func resolveRoot(path string) (string, error) {
 	return filepath.Abs(p)
 }
 
+// deviceOf returns the ID of the device (i.e. file system) containing the file at the provided path.
+func deviceOf(path string) (uint64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, util.CleanIOError(err) // cannot test
+	}
+	id, ok := FileIDOf(info)
+	if !ok {
+		return 0, fmt.Errorf("not supported on this platform")
+	}
+	return id.Device, nil
+}
+
 func validateRoot(path string) error {
 	i, err := os.Lstat(path)
 	if err != nil {
@@ -109,38 +393,210 @@ func validateRoot(path string) error {
 
 // run runs the "scan" command without any sanity checks.
 // In particular, the root path must not have a trailing slash as that would cause the file walk to panic.
-func run(rootPath string, shouldSkip ShouldSkipPath, cache *Dir) (*Dir, error) {
-	if shouldSkip(filepath.Dir(rootPath), filepath.Base(rootPath)) {
-		log.Printf("not skipping root directory %q", rootPath)
+// The root device is only used if the options specify that a single file system is to be scanned.
+func run(rootPath string, rootDevice uint64, opts Options) (*Result, error) {
+	meta := &Metadata{
+		Hostname:  hostname(),
+		StartTime: time.Now(),
+		Volume:    detectVolume(rootPath),
 	}
+	root := NewDir(rootPath)
+	w := &walker{
+		opts:        opts,
+		rootDevice:  rootDevice,
+		caches:      make(map[string]*Dir),
+		linkedFiles: make(map[fileKey]*File),
+	}
+	rootCache := w.indexCaches(rootPath)
+	err := w.walk(rootPath, rootPath, root, nil, rootCache, nil)
+	ComputeDirHashes(root)
+	meta.EndTime = time.Now()
+	return &Result{
+		TypeVersion: CurrentResultTypeVersion,
+		Root:        root,
+		Errors:      w.errors,
+		Metadata:    meta,
+		Stats:       &w.stats,
+	}, err
+}
+
+// hostname returns the name of the host or the empty string if it cannot be determined.
+func hostname() string {
+	h, err := os.Hostname()
+	if err != nil {
+		return "" // cannot test
+	}
+	return h
+}
+
+// walker holds the state of a scan that is shared between the (possibly nested) walks that it consists of.
+type walker struct {
+	opts       Options
+	rootDevice uint64
+	errors     []*ErrorRecord
+	// Caches indexed by the path of their root.
+	caches map[string]*Dir
+	stats  Stats
+	// Files with multiple hardlinks that have already been scanned, indexed by their identity.
+	// This allows the contents of hardlinked files to only be hashed once.
+	linkedFiles map[fileKey]*File
+}
+
+// FileID is the identity of a file on the file system along with its number of hardlinks.
+type FileID struct {
+	Device uint64
+	Inode  uint64
+	Links  uint64
+}
+
+// fileKey is the part of FileID that uniquely identifies a file.
+type fileKey struct {
+	device uint64
+	inode  uint64
+}
+
+func (id FileID) key() fileKey {
+	return fileKey{device: id.Device, inode: id.Inode}
+}
+
+// indexCaches indexes the caches of the options by the path of their root
+// and returns the Dir of the provided root path in the closest cache that contains it
+// (or nil if no cache does).
+func (w *walker) indexCaches(rootPath string) *Dir {
+	var closest *Dir
+	for _, c := range w.opts.Caches {
+		w.caches[c.Name] = c
+		if util.IsSubpath(c.Name, rootPath) && (closest == nil || len(c.Name) > len(closest.Name)) {
+			closest = c
+		}
+	}
+	if closest == nil {
+		return nil
+	}
+	return findSubdir(closest, rootPath)
+}
+
+// findSubdir returns the Dir of the provided path in the provided root Dir (or nil if it isn't found).
+// The path must be the root name or a path inside of it.
+func findSubdir(root *Dir, path string) *Dir {
+	return SafeFindDirByPath(root, path[len(root.Name):])
+}
 
-	type walkContext struct {
-		prev     *walkContext
-		curDir   *Dir
-		pathLen  int
-		cacheDir *Dir
+// cacheDir returns the cache of the directory with the provided name and (logical) path
+// which is located in a directory with the provided cache:
+// If there's a cache of exactly that path, then that one is used as it's the closest one.
+// Otherwise, the directory is looked up in the cache of the parent.
+func (w *walker) cacheDir(path string, parentCacheDir *Dir, name string) *Dir {
+	if c, ok := w.caches[path]; ok {
+		return c
 	}
+	return SafeFindDir(parentCacheDir, name)
+}
+
+// recordError records the provided error in the list of errors.
+func (w *walker) recordError(path, op string, err error) {
+	w.errors = append(w.errors, &ErrorRecord{Path: path, Op: op, Error: err.Error()})
+}
+
+// walk walks the file tree rooted at the provided path and adds its contents to the provided Dir.
+// The path must not contain any symlinks.
+// The logical path is the path that the walked directory appears at in the scan result,
+// i.e. the path of the followed symlink if the walk is the result of following one
+// (and otherwise the same as the walk path).
+// If the walk is the result of following a symlink to a directory,
+// then the Dir is appended to the provided parent Dir once the walk has verified that the directory is accessible
+// (otherwise it's recorded as inaccessible in the parent).
+// In that case, followedFrom lists the paths of the directories containing the symlinks that were followed to get there
+// (outermost first).
+// This is used for detecting cycles.
+func (w *walker) walk(walkPath string, logicalPath string, walkDir *Dir, parentDir *Dir, cacheDir *Dir, followedFrom []string) error {
+	opts := w.opts
+	shouldSkip, shouldInclude, fileFilter := opts.ShouldSkip, opts.ShouldInclude, opts.FileFilter
 
-	res := NewDir(rootPath)
 	head := &walkContext{
-		prev:     nil,
-		curDir:   res,
-		pathLen:  len(rootPath),
-		cacheDir: cache,
-	}
-	return res, filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
-		// Propagate error and skip root.
-		if err != nil || path == rootPath {
+		prev:    nil,
+		curDir:  walkDir,
+		pathLen: len(walkPath),
+		// The cache is only assigned once the directory is known to be readable
+		// as none of its cached files should be considered missing otherwise.
+		cacheDir: nil,
+	}
+	err := filepath.Walk(walkPath, func(path string, info os.FileInfo, err error) error {
+		if path == walkPath {
+			if parentDir == nil && info != nil && shouldSkip(filepath.Dir(walkPath), filepath.Base(walkPath), info) {
+				log.Printf("not skipping root directory %q", walkPath)
+			}
+			if err == nil {
+				head.cacheDir = cacheDir
+				if parentDir != nil {
+					parentDir.AppendDir(walkDir) // the walk of the parent visits in lexical order
+				}
+				return nil
+			}
+		}
+
+		var name, parentPath string
+		if path != walkPath {
+			parentPath = filepath.Dir(path)
+
+			// Detect that the walk has returned up the stack, as we aren't given any information about that.
+			// Checking just the length of the path works because directories are guaranteed to be visited
+			// before the files that they contain.
+			for head.pathLen != len(parentPath) {
+				w.countMissingCacheFiles(head)
+				head = head.prev
+			}
+
+			name = filepath.Base(path)
+			head.see(name)
+			// The info is nil if the file couldn't be "stat'ed" (in which case there's also an error).
+			if info != nil && shouldSkip(parentPath, name, info) {
+				log.Printf("skipping %v %q based on skip list\n", util.FileModeName(info.Mode()), path)
+				if info.IsDir() {
+					head.curDir.AppendSkippedDir(name)
+					return filepath.SkipDir
+				}
+				head.curDir.AppendSkippedFile(name)
+				return nil
+			}
+			if info != nil && info.IsDir() && !w.onRootFileSystem(info) {
+				log.Printf("skipping mount point %q\n", path)
+				head.curDir.AppendSkippedMount(name) // Walk visits in lexical order
+				return filepath.SkipDir
+			}
+		}
+
+		if err != nil {
 			modeName := util.FileInfoModeName(info)
 			err := util.CleanIOError(err)
+			// If the info is available, then the error is from listing the directory's contents
+			// (files don't result in errors at this point).
+			op := OpStat
+			if info != nil {
+				op = OpList
+			}
 			if errors.Is(err, util.ErrNotFound) {
 				// TODO: Can maybe test on Windows (with too long path)?
 				log.Printf("error: %v %q not found\n", modeName, path) // cannot test
+				w.recordError(path, op, err)
 				return nil
 			}
 			if errors.Is(err, util.ErrAccessDenied) {
 				log.Printf("skipping inaccessible %v %q\n", modeName, path)
-				// TODO: Should return 'filepath.SkipDir' to skip children?
+				w.recordError(path, op, err)
+				// Record the inaccessible item in its parent (if it has one).
+				// Walk doesn't attempt to visit the contents of a directory that cannot be read.
+				dir := head.curDir
+				if path == walkPath {
+					dir, name = parentDir, walkDir.Name
+				}
+				if dir != nil {
+					if info != nil && info.IsDir() {
+						dir.AppendInaccessibleDir(NewInaccessible(name, err.Error())) // Walk visits in lexical order
+					} else {
+						dir.AppendInaccessibleFile(NewInaccessible(name, err.Error())) // Walk visits in lexical order
+					}
+				}
 				return nil
 			}
 			// TODO: Should be able to test
@@ -150,24 +606,61 @@ func run(rootPath string, shouldSkip ShouldSkipPath, cache *Dir) (*Dir, error) {
 			//       These approaches should also be useful for testing other things currently deemed "cannot test".
 			return errors.Wrapf(err, "cannot walk %v %q", modeName, path) // cannot test
 		}
-		parentPath := filepath.Dir(path)
-
-		// Detect that the walk has returned up the stack, as we aren't given any information about that.
-		// Checking just the length of the path works because directories are guaranteed to be visited
-		// before the files that they contain.
-		for head.pathLen != len(parentPath) {
-			head = head.prev
-		}
 
-		name := filepath.Base(path)
-		if shouldSkip(parentPath, name) {
-			log.Printf("skipping %v %q based on skip list\n", util.FileModeName(info.Mode()), path)
-			if info.IsDir() {
-				head.curDir.AppendSkippedDir(name)
-				return filepath.SkipDir
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				err := util.CleanIOError(err)
+				log.Printf("error: cannot read symlink %q: %v\n", path, err) // cannot test
+				w.recordError(path, OpReadlink, err)
+				return nil
+			}
+			targetInfo, statErr := os.Stat(path)
+			if opts.FollowSymlinks && statErr == nil && targetInfo.IsDir() {
+				head.curDir.AppendSymlink(NewSymlink(name, target, false)) // Walk visits in lexical order
+				targetPath, err := filepath.EvalSymlinks(path)
+				if err != nil {
+					err := util.CleanIOError(err)
+					log.Printf("error: cannot resolve symlink %q: %v\n", path, err) // cannot test
+					w.recordError(path, OpResolve, err)
+					return nil
+				}
+				if isCycle(targetPath, parentPath, followedFrom) {
+					log.Printf("not following symlink %q to ancestor directory %q\n", path, targetPath)
+					return nil
+				}
+				if !w.onRootFileSystem(targetInfo) {
+					log.Printf("not following symlink %q to directory %q on another file system\n", path, targetPath)
+					return nil
+				}
+				linkPath := logicalPath + path[len(walkPath):]
+				return w.walk(targetPath, linkPath, NewDir(name), head.curDir, w.cacheDir(linkPath, head.cacheDir, name), append(followedFrom, parentPath))
+			}
+			if !shouldInclude(parentPath, name, info) {
+				head.curDir.AppendFilteredFile(name) // Walk visits in lexical order
+				return nil
+			}
+			head.curDir.AppendSymlink(NewSymlink(name, target, statErr != nil)) // Walk visits in lexical order
+			if !opts.FollowSymlinks || statErr != nil {
+				return nil
+			}
+			if reason := fileFilter(targetInfo); reason != "" {
+				log.Printf("filtering out file %q: %s\n", path, reason)
+				head.curDir.AppendFilteredFile(name) // Walk visits in lexical order
+				return nil
+			}
+			// Continue with the symlink's target in place of the symlink itself.
+			info = targetInfo
+		} else if !info.IsDir() {
+			if !shouldInclude(parentPath, name, info) {
+				head.curDir.AppendFilteredFile(name) // Walk visits in lexical order
+				return nil
+			}
+			if reason := fileFilter(info); reason != "" {
+				log.Printf("filtering out file %q: %s\n", path, reason)
+				head.curDir.AppendFilteredFile(name) // Walk visits in lexical order
+				return nil
 			}
-			head.curDir.AppendSkippedFile(name)
-			return nil
 		}
 
 		if mode := info.Mode(); mode.IsDir() {
@@ -177,20 +670,50 @@ func run(rootPath string, shouldSkip ShouldSkipPath, cache *Dir) (*Dir, error) {
 				prev:     head,
 				curDir:   dir,
 				pathLen:  len(path),
-				cacheDir: SafeFindDir(head.cacheDir, name),
+				cacheDir: w.cacheDir(logicalPath+path[len(walkPath):], head.cacheDir, name),
 			}
 		} else if !mode.IsRegular() {
-			// File is a symlink, named pipe, socket, device, etc.
-			// We don't currently support any of that.
-			// IDEA: If symlink, print target (and whether it exists).
-			log.Printf("skipping %v %q during scan\n", util.FileModeName(mode), path)
+			// File is a named pipe, socket, device, etc.
+			head.curDir.AppendSpecialFile(NewSpecialFile(name, util.FileModeName(mode))) // Walk visits in lexical order
 		} else if size := info.Size(); size == 0 {
 			head.curDir.AppendEmptyFile(name) // Walk visits in lexical order
 		} else {
 			// IDEA: Parallelize hash computation (via work queue for example).
 			// IDEA: Consider adding option to hash a limited number of bytes only
 			//       (the reason being that if two files differ, the first 1MB or so probably differ too).
-			h, hit := hashFromCache(head.cacheDir, name, size, info.ModTime().Unix())
+			id, hasID := FileIDOf(info)
+			modTime := info.ModTime()
+			cached := SafeFindFile(head.cacheDir, name)
+			h, hit := hashFromCache(cached, size, modTime, opts.ModTimeTolerance)
+			switch {
+			case hit && h != 0:
+				w.stats.CacheHits++
+			case cached != nil:
+				w.stats.CacheStale++
+			default:
+				w.stats.CacheMisses++
+			}
+			if !hit && hasID && id.Links > 1 {
+				// Reuse the hash of another hardlink to the same contents if one has already been scanned.
+				if l := w.linkedFiles[id.key()]; l != nil && l.Size == size && l.Hash != 0 {
+					h = l.Hash
+					w.stats.HardlinkHits++
+				}
+			}
+			key := FileKey{
+				Path:         path,
+				Device:       id.Device,
+				Inode:        id.Inode,
+				Size:         size,
+				ModTime:      modTime.Unix(),
+				ModTimeNanos: int64(modTime.Nanosecond()),
+			}
+			if h == 0 && !hit && opts.HashCache != nil {
+				var ok bool
+				if h, ok = opts.HashCache.Lookup(key); ok && h != 0 {
+					w.stats.HashCacheHits++
+				}
+			}
 			// If the cache contains the actual hash value 0,
 			// we assume that it's either caused by the file being inaccessible
 			// or by a mistake resulting in unintended zero-initialization somewhere.
@@ -202,27 +725,186 @@ func run(rootPath string, shouldSkip ShouldSkipPath, cache *Dir) (*Dir, error) {
 				if hit {
 					log.Printf("warning: cached hash value 0 of file %q ignored\n", path)
 				}
-				h, err = hash.File(path)
+				var stable bool
+				h, info, stable, err = w.hashFile(path, info)
 				if err != nil {
-					// Currently report error but keep going (i.e. include the file with empty hash).
+					// Report error but keep going (i.e. include the file explicitly marked as unhashed).
 					log.Printf("error: cannot hash file %q: %v\n", path, err)
-				} else if h == 0 {
+					w.recordError(path, OpHash, err)
+					w.appendFile(head.curDir, NewUnhashedFile(name, size, modTime.Unix()), modTime, id, hasID)
+					return nil
+				}
+				// Record the file as it was when it was last hashed.
+				size, modTime = info.Size(), info.ModTime()
+				id, hasID = FileIDOf(info)
+				w.stats.FilesHashed++
+				w.stats.BytesHashed += size
+				if !stable {
+					// Keep the hash (which is likely useless) to stay consistent with files that weren't stable but got lucky,
+					// but make sure that it's never used as cache.
+					log.Printf("warning: file %q kept changing while being hashed - recording it as unstable\n", path)
+					w.stats.FilesUnstable++
+					f := NewFile(name, size, modTime.Unix(), h)
+					f.Unstable = true
+					w.appendFile(head.curDir, f, modTime, id, hasID)
+					return nil
+				}
+				if size == 0 {
+					// File was truncated while being hashed.
+					head.curDir.AppendEmptyFile(name) // Walk visits in lexical order
+					return nil
+				}
+				key.Device, key.Inode = id.Device, id.Inode
+				key.Size, key.ModTime, key.ModTimeNanos = size, modTime.Unix(), int64(modTime.Nanosecond())
+				if h == 0 {
 					log.Printf("info: hash of file %q evaluated to 0 - this might result in warnings (which can be safely ignored) if the output is used as cache in future scans\n", path)
 				}
+			} else {
+				w.stats.BytesReused += size
 			}
-			head.curDir.AppendFile(NewFile(name, size, info.ModTime().Unix(), h)) // Walk visits in lexical order
+			if opts.HashCache != nil && h != 0 {
+				opts.HashCache.Store(key, h)
+			}
+			w.appendFile(head.curDir, NewFile(name, size, modTime.Unix(), h), modTime, id, hasID)
 		}
 		return nil
 	})
+	for ; head != nil; head = head.prev {
+		w.countMissingCacheFiles(head)
+	}
+	return err
+}
+
+// walkContext is the state of a directory on the stack of directories being walked.
+type walkContext struct {
+	prev     *walkContext
+	curDir   *Dir
+	pathLen  int
+	cacheDir *Dir
+	// Names of the files and subdirectories that have been visited in the directory.
+	// Only recorded if the directory has a cache.
+	seen map[string]struct{}
 }
 
-// hashFromCache looks up the hash of the contents of the provided file in the provided cache dir.
-// If the cached file size or modification time don't match that of the file being looked up, the cache is considered missed.
+// see records that the file or subdirectory with the provided name has been visited.
+func (c *walkContext) see(name string) {
+	if c.cacheDir == nil {
+		return
+	}
+	if c.seen == nil {
+		c.seen = make(map[string]struct{})
+	}
+	c.seen[name] = struct{}{}
+}
+
+// countMissingCacheFiles counts the files in the cache of the provided context
+// that weren't visited in the directory (or any of its subdirectories that weren't visited at all)
+// as missing.
+// This must only be called once the directory has been fully walked.
+func (w *walker) countMissingCacheFiles(c *walkContext) {
+	if c.cacheDir == nil {
+		return
+	}
+	for _, f := range c.cacheDir.Files {
+		if _, ok := c.seen[f.Name]; !ok {
+			w.stats.CacheMissing++
+		}
+	}
+	for _, d := range c.cacheDir.Dirs {
+		if _, ok := c.seen[d.Name]; !ok {
+			w.stats.CacheMissing += countFiles(d)
+		}
+	}
+}
+
+// countFiles returns the number of files in the provided Dir and all of its subdirectories.
+func countFiles(d *Dir) int {
+	n := len(d.Files)
+	for _, s := range d.Dirs {
+		n += countFiles(s)
+	}
+	return n
+}
+
+// appendFile appends the provided File to the provided Dir
+// after recording the sub-second part of its modification time and its identity (if available).
+// If the file has multiple hardlinks, it's also registered for reuse of its hash.
+func (w *walker) appendFile(dir *Dir, f *File, modTime time.Time, id FileID, hasID bool) {
+	f.ModTimeNanos = int64(modTime.Nanosecond())
+	if hasID {
+		f.Device, f.Inode, f.Links = id.Device, id.Inode, id.Links
+		if id.Links > 1 && !f.Unhashed && !f.Unstable && w.linkedFiles[id.key()] == nil {
+			w.linkedFiles[id.key()] = f
+		}
+	}
+	dir.AppendFile(f) // Walk visits in lexical order
+}
+
+// onRootFileSystem returns whether the file with the provided info is on the same file system as the root
+// or if this doesn't matter because the scan isn't restricted to a single file system.
+func (w *walker) onRootFileSystem(info os.FileInfo) bool {
+	if !w.opts.OneFileSystem {
+		return true
+	}
+	id, ok := FileIDOf(info)
+	return !ok || id.Device == w.rootDevice
+}
+
+// isCycle returns whether following a symlink in the directory at the provided path to the provided target directory
+// would result in a cycle.
+// This is the case if that directory or any of the ones containing previously followed symlinks
+// is equal to or nested inside the target directory.
+func isCycle(targetPath, dirPath string, followedFrom []string) bool {
+	if util.IsSubpath(targetPath, dirPath) {
+		return true
+	}
+	for _, p := range followedFrom {
+		if util.IsSubpath(targetPath, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// hashFile hashes the contents of the file at the provided path
+// and then checks that its size and modification time still match the provided info of the file.
+// If they don't, the file is rehashed (up to the number of times given by Options.HashRetries)
+// until they match the info obtained right before hashing.
+// Returns the hash along with the info of the file at the time it was last hashed
+// and whether the file was stable (i.e. didn't change) while being hashed.
+func (w *walker) hashFile(path string, info os.FileInfo) (uint64, os.FileInfo, bool, error) {
+	for i := 0; ; i++ {
+		h, err := hashFileContents(path)
+		if err != nil {
+			return 0, nil, false, err
+		}
+		newInfo, err := os.Stat(path)
+		if err != nil {
+			// File was removed or became inaccessible while being hashed.
+			return 0, nil, false, errors.Wrap(util.CleanIOError(err), "cannot stat file after hashing")
+		}
+		if newInfo.Size() == info.Size() && newInfo.ModTime().Equal(info.ModTime()) {
+			return h, info, true, nil
+		}
+		if i == w.opts.HashRetries {
+			return h, info, false, nil
+		}
+		log.Printf("info: file %q changed while being hashed - retrying\n", path)
+		info = newInfo
+	}
+}
+
+// hashFileContents computes the hash of the contents of the file at the provided path.
+// It's a variable only such that tests may simulate the file changing while being hashed.
+var hashFileContents = hash.File
+
+// hashFromCache returns the hash of the contents of the provided cached file (which may be nil).
+// If the cached file size or modification time (within the provided tolerance) don't match that of the file being looked up
+// or the cached file is marked as unhashed or unstable, the cache is considered missed.
 // A cache miss will always return hash value 0.
 // The boolean return value indicates whether the hash was found in the cache or not.
-func hashFromCache(cacheDir *Dir, fileName string, fileSize int64, modTimeUnix int64) (uint64, bool) {
-	f := SafeFindFile(cacheDir, fileName)
-	if f != nil && f.Size == fileSize && f.ModTime == modTimeUnix {
+func hashFromCache(f *File, fileSize int64, modTime time.Time, modTimeTolerance time.Duration) (uint64, bool) {
+	if f != nil && !f.Unhashed && !f.Unstable && f.Size == fileSize && ModTimesMatch(f.ModTime, f.ModTimeNanos, modTime.Unix(), int64(modTime.Nanosecond()), modTimeTolerance) {
 		return f.Hash, true
 	}
 	return 0, false
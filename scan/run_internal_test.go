@@ -0,0 +1,145 @@
+package scan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bisgardo/dupe-nukem/hash"
+	. "github.com/bisgardo/dupe-nukem/testutil"
+)
+
+// simulateWrites replaces hashFileContents with a function that appends to the hashed file
+// (and bumps its modification time) while it's being hashed the first n times.
+// Returns a pointer to the number of times that the file has been hashed.
+func simulateWrites(t *testing.T, n int) *int {
+	var calls int
+	t.Cleanup(func() {
+		hashFileContents = hash.File
+	})
+	hashFileContents = func(path string) (uint64, error) {
+		h, err := hash.File(path)
+		calls++
+		if calls <= n {
+			appendToFile(t, path, "x", time.Unix(int64(1000+calls), 0))
+		}
+		return h, err
+	}
+	return &calls
+}
+
+func appendToFile(t *testing.T, path, data string, modTime time.Time) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, os.Chtimes(path, time.Time{}, modTime))
+}
+
+func writeTestFile(t *testing.T, data string) (string, string) {
+	rootPath := t.TempDir()
+	path := filepath.Join(rootPath, "a")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+	require.NoError(t, os.Chtimes(path, time.Time{}, time.Unix(1000, 0)))
+	return rootPath, path
+}
+
+func Test__file_changed_while_hashing_is_rehashed(t *testing.T) {
+	rootPath, path := writeTestFile(t, "a")
+	calls := simulateWrites(t, 1)
+	logs := CaptureLogs(t)
+
+	res, err := RunWithOptions(rootPath, Options{HashRetries: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 2, *calls)
+	require.Len(t, res.Root.Files, 1)
+	f := res.Root.Files[0]
+	assert.Equal(t, int64(2), f.Size)
+	assert.Equal(t, int64(1001), f.ModTime)
+	assert.Equal(t, hash.Bytes([]byte("ax")), f.Hash)
+	assert.False(t, f.Unstable)
+	assert.Equal(t, 0, res.Stats.FilesUnstable)
+	assert.Equal(t, Lines(
+		`info: file "`+path+`" changed while being hashed - retrying`,
+	), logs.String())
+}
+
+func Test__file_changing_after_last_retry_is_marked_unstable(t *testing.T) {
+	rootPath, path := writeTestFile(t, "a")
+	calls := simulateWrites(t, 3)
+	logs := CaptureLogs(t)
+	hashCache := make(storeCounter)
+
+	res, err := RunWithOptions(rootPath, Options{HashRetries: 2, HashCache: hashCache})
+	require.NoError(t, err)
+	assert.Equal(t, 3, *calls)
+	require.Len(t, res.Root.Files, 1)
+	f := res.Root.Files[0]
+	// Recorded as it was when it was last hashed.
+	assert.Equal(t, int64(3), f.Size)
+	assert.Equal(t, int64(1002), f.ModTime)
+	assert.Equal(t, hash.Bytes([]byte("axx")), f.Hash)
+	assert.True(t, f.Unstable)
+	assert.Equal(t, 1, res.Stats.FilesUnstable)
+	assert.Empty(t, hashCache)
+	assert.Equal(t, Lines(
+		`info: file "`+path+`" changed while being hashed - retrying`,
+		`info: file "`+path+`" changed while being hashed - retrying`,
+		`warning: file "`+path+`" kept changing while being hashed - recording it as unstable`,
+	), logs.String())
+}
+
+func Test__file_changed_while_hashing_without_retries_is_marked_unstable(t *testing.T) {
+	rootPath, _ := writeTestFile(t, "a")
+	calls := simulateWrites(t, 1)
+	_ = CaptureLogs(t)
+
+	res, err := RunWithOptions(rootPath, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, *calls)
+	require.Len(t, res.Root.Files, 1)
+	assert.True(t, res.Root.Files[0].Unstable)
+}
+
+func Test__file_removed_while_hashing_is_recorded_as_unhashed(t *testing.T) {
+	rootPath, path := writeTestFile(t, "a")
+	t.Cleanup(func() {
+		hashFileContents = hash.File
+	})
+	hashFileContents = func(path string) (uint64, error) {
+		h, err := hash.File(path)
+		require.NoError(t, os.Remove(path))
+		return h, err
+	}
+	_ = CaptureLogs(t)
+
+	res, err := RunWithOptions(rootPath, Options{})
+	require.NoError(t, err)
+	require.Len(t, res.Root.Files, 1)
+	assert.True(t, res.Root.Files[0].Unhashed)
+	require.Len(t, res.Errors, 1)
+	assert.Equal(t, &ErrorRecord{Path: path, Op: OpHash, Error: "cannot stat file after hashing: not found"}, res.Errors[0])
+}
+
+func Test__unstable_cache_entry_is_ignored(t *testing.T) {
+	f := &File{Name: "a", Size: 1, ModTime: 1000, Hash: 42, Unstable: true}
+	h, ok := hashFromCache(f, 1, time.Unix(1000, 0), 0)
+	assert.False(t, ok)
+	assert.Equal(t, uint64(0), h)
+}
+
+// storeCounter is a HashCache that never finds anything and records the keys of stored hashes.
+type storeCounter map[FileKey]uint64
+
+func (c storeCounter) Lookup(FileKey) (uint64, bool) {
+	return 0, false
+}
+
+func (c storeCounter) Store(key FileKey, hash uint64) {
+	c[key] = hash
+}
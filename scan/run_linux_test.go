@@ -0,0 +1,64 @@
+package scan_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/bisgardo/dupe-nukem/scan"
+	. "github.com/bisgardo/dupe-nukem/scan/scantest"
+	. "github.com/bisgardo/dupe-nukem/testutil"
+	. "github.com/bisgardo/dupe-nukem/testutil/testdata"
+)
+
+// The tests in this file rely on "/proc" being a mount point of a separate (virtual) file system,
+// which is always the case on Linux.
+
+func Test__one_file_system_skips_mount_points(t *testing.T) {
+	// Skip everything in the root except "/proc".
+	skip := func(dir, name string, info os.FileInfo) bool {
+		return dir == "/" && name != "/" && name != "proc"
+	}
+	logs := CaptureLogs(t)
+	res, err := RunWithOptions("/", Options{ShouldSkip: skip, OneFileSystem: true})
+	require.NoError(t, err)
+	assert.Empty(t, res.Root.Dirs)
+	assert.Equal(t, []string{"proc"}, res.Root.SkippedMounts)
+	assert.NotContains(t, res.Root.SkippedDirs, "proc")
+	assert.Contains(t, logs.String(), Lines(`skipping mount point "/proc"`))
+}
+
+func Test__one_file_system_does_not_follow_symlink_to_other_file_system(t *testing.T) {
+	root := DirNode{
+		"a": FileNode{C: "x\n"},
+		"b": SymlinkNode("/proc"),
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	want := simulateScan(root, rootPath)
+
+	logs := CaptureLogs(t)
+	res, err := RunWithOptions(rootPath, Options{FollowSymlinks: true, OneFileSystem: true})
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+	assert.Equal(t,
+		Lines(`not following symlink "`+filepath.Join(rootPath, "b")+`" to directory "/proc" on another file system`),
+		logs.String(),
+	)
+}
+
+func Test__result_metadata_contains_volume(t *testing.T) {
+	rootPath := tempDir(t)
+	res, err := Run(rootPath, NoSkip, nil)
+	require.NoError(t, err)
+	require.NotNil(t, res.Metadata)
+	v := res.Metadata.Volume
+	require.NotNil(t, v)
+	assert.NotEmpty(t, v.Type)
+	assert.NotEmpty(t, v.MountPoint)
+	assert.True(t, strings.HasSuffix(rootPath, v.Path) || v.MountPoint != "/", "unexpected path %q for mount point %q", v.Path, v.MountPoint)
+}
@@ -106,6 +106,7 @@ func Test__inaccessible_root_is_skipped_and_logged(t *testing.T) {
 	want := &Result{
 		TypeVersion: CurrentResultTypeVersion,
 		Root:        &Dir{Name: rootPath},
+		Errors:      []*ErrorRecord{{Path: rootPath, Op: OpList, Error: "access denied"}},
 	}
 	logs := CaptureLogs(t)
 	res, err := Run(rootPath, NoSkip, nil)
@@ -154,7 +155,7 @@ func Test__SkipNameSet_empty_always_returns_false(t *testing.T) {
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			skip := shouldSkip(test.dirName, test.baseName)
+			skip := shouldSkip(test.dirName, test.baseName, nil)
 			assert.False(t, skip)
 		})
 	}
@@ -182,12 +183,295 @@ func Test__SkipNameSet_nonempty_returns_whether_basename_matches(t *testing.T) {
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			skip := shouldSkip(test.dirName, test.baseName)
+			skip := shouldSkip(test.dirName, test.baseName, nil)
 			assert.Equal(t, test.want, skip)
 		})
 	}
 }
 
+func Test__SkipAny_returns_whether_any_function_returns_true(t *testing.T) {
+	tests := []struct {
+		name string
+		fs   []ShouldSkipPath
+		want bool
+	}{
+		{name: "no functions", fs: nil, want: false},
+		{name: "single non-matching function", fs: []ShouldSkipPath{makeSkip("x")}, want: false},
+		{name: "single matching function", fs: []ShouldSkipPath{makeSkip("a")}, want: true},
+		{name: "non-matching functions", fs: []ShouldSkipPath{makeSkip("x"), makeSkip("y")}, want: false},
+		{name: "last function matching", fs: []ShouldSkipPath{makeSkip("x"), makeSkip("a")}, want: true},
+		{name: "first function matching", fs: []ShouldSkipPath{makeSkip("a"), makeSkip("x")}, want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			skip := SkipAny(test.fs...)("x", "a", nil)
+			assert.Equal(t, test.want, skip)
+		})
+	}
+}
+
+func Test__filter_by_file_size(t *testing.T) {
+	tests := []struct {
+		name    string
+		minSize int64
+		maxSize int64
+		root    DirNode
+	}{
+		{
+			name:    "min size",
+			minSize: 2,
+			maxSize: -1,
+			root: DirNode{
+				"a": FileNode{C: "x", Filtered: true},
+				"b": FileNode{C: "xy"},
+				"c": FileNode{C: "xyz"},
+				"d": DirNode{"e": FileNode{C: "x", Filtered: true}},
+				"f": FileNode{Filtered: true},
+			},
+		},
+		{
+			name:    "max size",
+			maxSize: 2,
+			root: DirNode{
+				"a": FileNode{C: "x"},
+				"b": FileNode{C: "xy"},
+				"c": FileNode{C: "xyz", Filtered: true},
+				"d": DirNode{"e": FileNode{C: "xyz", Filtered: true}},
+				"f": FileNode{},
+			},
+		},
+		{
+			name:    "max size zero",
+			maxSize: 0,
+			root: DirNode{
+				"a": FileNode{C: "x", Filtered: true},
+				"f": FileNode{},
+			},
+		},
+		{
+			name:    "min and max size",
+			minSize: 2,
+			maxSize: 2,
+			root: DirNode{
+				"a": FileNode{C: "x", Filtered: true},
+				"b": FileNode{C: "xy"},
+				"c": FileNode{C: "xyz", Filtered: true},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rootPath := tempDir(t)
+			test.root.WriteTestdata(t, rootPath)
+			want := simulateScan(test.root, rootPath)
+			res, err := RunWithOptions(rootPath, Options{FileFilter: FilterFileSize(test.minSize, test.maxSize)})
+			require.NoError(t, err)
+			AssertEqualResult(t, res, want)
+		})
+	}
+}
+
+func Test__filter_by_file_mod_time(t *testing.T) {
+	ts, err := time.Parse(time.Layout, time.Layout)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		newerThan time.Time
+		olderThan time.Time
+		root      DirNode
+	}{
+		{
+			name:      "newer than",
+			newerThan: ts,
+			root: DirNode{
+				"a": FileNode{C: "x", Ts: ts.Add(-time.Second), Filtered: true},
+				"b": FileNode{C: "x", Ts: ts, Filtered: true},
+				"c": FileNode{C: "x", Ts: ts.Add(time.Second)},
+				"d": DirNode{"e": FileNode{C: "x", Ts: ts.Add(-time.Hour), Filtered: true}},
+			},
+		},
+		{
+			name:      "older than",
+			olderThan: ts,
+			root: DirNode{
+				"a": FileNode{C: "x", Ts: ts.Add(-time.Second)},
+				"b": FileNode{C: "x", Ts: ts, Filtered: true},
+				"c": FileNode{C: "x", Ts: ts.Add(time.Second), Filtered: true},
+				"d": DirNode{"e": FileNode{C: "x", Ts: ts.Add(time.Hour), Filtered: true}},
+			},
+		},
+		{
+			name:      "newer and older than",
+			newerThan: ts.Add(-time.Minute),
+			olderThan: ts.Add(time.Minute),
+			root: DirNode{
+				"a": FileNode{C: "x", Ts: ts.Add(-time.Hour), Filtered: true},
+				"b": FileNode{C: "x", Ts: ts},
+				"c": FileNode{C: "x", Ts: ts.Add(time.Hour), Filtered: true},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rootPath := tempDir(t)
+			test.root.WriteTestdata(t, rootPath)
+			want := simulateScan(test.root, rootPath)
+			res, err := RunWithOptions(rootPath, Options{FileFilter: FilterFileModTime(test.newerThan, test.olderThan)})
+			require.NoError(t, err)
+			AssertEqualResult(t, res, want)
+		})
+	}
+}
+
+func Test__filter_logs_reason(t *testing.T) {
+	root := DirNode{
+		"a": FileNode{C: "x", Filtered: true},
+		"b": FileNode{C: "xyz", Filtered: true},
+		"c": FileNode{C: "xy"},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	logs := CaptureLogs(t)
+
+	res, err := RunWithOptions(rootPath, Options{FileFilter: FilterAny(FilterFileSize(2, -1), FilterFileSize(0, 2))})
+	require.NoError(t, err)
+	AssertEqualResult(t, res, simulateScan(root, rootPath))
+	assert.Contains(t, logs.String(), fmt.Sprintf("filtering out file %q: size 1 is smaller than min size 2\n", filepath.Join(rootPath, "a")))
+	assert.Contains(t, logs.String(), fmt.Sprintf("filtering out file %q: size 3 is larger than max size 2\n", filepath.Join(rootPath, "b")))
+	assert.NotContains(t, logs.String(), "skip list")
+}
+
+func Test__skip_function_receives_file_info(t *testing.T) {
+	root := DirNode{
+		"a": FileNode{C: "xyz"},
+		"b": DirNode{"c": FileNode{}},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+
+	infos := map[string]os.FileInfo{}
+	shouldSkip := func(dir, name string, info os.FileInfo) bool {
+		infos[filepath.Join(dir, name)] = info
+		return false
+	}
+	_, err := Run(rootPath, shouldSkip, nil)
+	require.NoError(t, err)
+	require.Len(t, infos, 4)
+	assert.True(t, infos[rootPath].IsDir())
+	assert.Equal(t, int64(3), infos[filepath.Join(rootPath, "a")].Size())
+	assert.True(t, infos[filepath.Join(rootPath, "b")].IsDir())
+	assert.True(t, infos[filepath.Join(rootPath, "b", "c")].Mode().IsRegular())
+}
+
+func Test__IncludeNamePatterns_returns_whether_basename_matches_any_pattern(t *testing.T) {
+	shouldInclude := IncludeNamePatterns([]string{"*.jpg", "a?c", "[xy]"})
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "", want: false},
+		{name: "x.jpg", want: true},
+		{name: ".jpg", want: true},
+		{name: "x.JPG", want: false},
+		{name: "x.jpg.bak", want: false},
+		{name: "abc", want: true},
+		{name: "ac", want: false},
+		{name: "x", want: true},
+		{name: "z", want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			include := shouldInclude("jpg", test.name, nil)
+			assert.Equal(t, test.want, include)
+		})
+	}
+}
+
+func Test__files_not_included_are_filtered(t *testing.T) {
+	root := DirNode{
+		"a.jpg": FileNode{C: "x\n"},
+		"b.txt": FileNode{C: "y\n", Filtered: true},
+		"c.jpg": FileNode{},
+		"d":     FileNode{Filtered: true},
+		"e.jpg": DirNode{
+			"f.txt": FileNode{C: "z\n", Filtered: true},
+		},
+		"g": DirNode{
+			"h.jpg": FileNode{C: "z\n"},
+			"i.jpg": FileNode{C: "q\n", Skipped: true},
+		},
+		"j.txt": DirNodeExt{Skipped: true},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	want := simulateScan(root, rootPath)
+
+	logs := CaptureLogs(t)
+	res, err := RunWithOptions(rootPath, Options{
+		ShouldSkip:    makeSkip("i.jpg", "j.txt"),
+		ShouldInclude: IncludeNamePatterns([]string{"*.jpg"}),
+	})
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+	assert.Equal(t,
+		fmt.Sprintf(
+			Lines(
+				"skipping file %q based on skip list",
+				"skipping directory %q based on skip list",
+			),
+			filepath.Join(rootPath, "g", "i.jpg"),
+			filepath.Join(rootPath, "j.txt"),
+		),
+		logs.String(),
+	)
+}
+
+func Test__dir_hashes_are_computed(t *testing.T) {
+	sub := func() DirNode {
+		return DirNode{
+			"a": FileNode{C: "x\n"},
+			"b": FileNode{},
+			"c": DirNode{"d": FileNode{C: "y\n"}},
+		}
+	}
+	root := DirNode{
+		"d1": sub(),
+		"d2": sub(),
+		"d3": DirNode{
+			"a2": FileNode{C: "x\n"},
+			"c":  DirNode{"d": FileNode{C: "y\n"}},
+		},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+
+	res, err := Run(rootPath, NoSkip, nil)
+	require.NoError(t, err)
+	d1, d2, d3 := res.Root.Dirs[0], res.Root.Dirs[1], res.Root.Dirs[2]
+	assert.NotZero(t, res.Root.Hash)
+	assert.NotZero(t, d1.Hash)
+	assert.Equal(t, d1.Hash, d2.Hash)
+	assert.NotEqual(t, d1.Hash, d3.Hash)
+	assert.Equal(t, d1.ContentHash, d3.ContentHash) // same files with other names
+	assert.Equal(t, 3*d1.ContentHash, res.Root.ContentHash)
+}
+
+func Test__zero_options_include_everything(t *testing.T) {
+	root := DirNode{
+		"a":   FileNode{C: "x\n"},
+		"b/c": FileNode{},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	want := simulateScan(root, rootPath)
+
+	res, err := RunWithOptions(rootPath, Options{})
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+}
+
 func Test__root_cannot_be_skipped(t *testing.T) {
 	root := DirNode{"a": FileNode{C: "x"}}
 	rootPath := tempDir(t)
@@ -436,6 +720,9 @@ func Test__inaccessible_internal_file_is_not_hashed_and_is_logged(t *testing.T)
 	rootPath := tempDir(t)
 	root.WriteTestdata(t, rootPath)
 	want := simulateScan(root, rootPath)
+	want.Errors = []*ErrorRecord{
+		{Path: filepath.Join(rootPath, "inaccessible"), Op: OpHash, Error: "cannot open file: access denied"},
+	}
 
 	logs := CaptureLogs(t)
 	res, err := Run(rootPath, NoSkip, nil)
@@ -452,7 +739,7 @@ func Test__inaccessible_internal_file_is_not_hashed_and_is_logged(t *testing.T)
 
 // On Windows, this test only works if the repository is stored on a filesystem
 // that supports the command 'icacls' (such as NTFS).
-func Test__inaccessible_internal_dir_is_logged(t *testing.T) {
+func Test__inaccessible_internal_dir_is_recorded_and_logged(t *testing.T) {
 	root := DirNode{
 		"f": DirNode{
 			"a":            FileNode{C: "z\n"},
@@ -463,6 +750,9 @@ func Test__inaccessible_internal_dir_is_logged(t *testing.T) {
 	rootPath := tempDir(t)
 	root.WriteTestdata(t, rootPath)
 	want := simulateScan(root, rootPath)
+	want.Errors = []*ErrorRecord{
+		{Path: filepath.Join(rootPath, "f", "inaccessible"), Op: OpList, Error: "access denied"},
+	}
 
 	logs := CaptureLogs(t)
 	res, err := Run(rootPath, NoSkip, nil)
@@ -477,6 +767,70 @@ func Test__inaccessible_internal_dir_is_logged(t *testing.T) {
 	)
 }
 
+// On Windows, this test only works if the repository is stored on a filesystem
+// that supports the command 'icacls' (such as NTFS).
+func Test__inaccessible_skipped_dir_is_not_recorded_as_inaccessible(t *testing.T) {
+	root := DirNode{
+		"inaccessible": DirNodeExt{Inaccessible: true, Skipped: true},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	want := &Result{
+		TypeVersion: CurrentResultTypeVersion,
+		Root:        &Dir{Name: rootPath, SkippedDirs: []string{"inaccessible"}},
+	}
+
+	logs := CaptureLogs(t)
+	res, err := Run(rootPath, makeSkip("inaccessible"), nil)
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+	assert.Equal(t,
+		fmt.Sprintf(
+			Lines("skipping directory %q based on skip list"),
+			filepath.Join(rootPath, "inaccessible"),
+		),
+		logs.String(),
+	)
+}
+
+// SKIPPED on Windows unless running as administrator.
+func Test__inaccessible_followed_symlink_target_dir_is_recorded_and_logged(t *testing.T) {
+	//goland:noinspection GoBoolExpressions
+	if runtime.GOOS == "windows" && !IsWindowsAdministrator() {
+		t.Skip("Creating symlinks on Windows requires elevated privileges.")
+	}
+	root := DirNode{
+		"a": DirNodeExt{Inaccessible: true},
+		"b": SymlinkNode("a"),
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	want := simulateScan(DirNode{
+		"a": DirNodeExt{Inaccessible: true},
+		"b": SymlinkExtNode{Symlink: "a", Followed: DirNodeExt{Inaccessible: true}},
+	}, rootPath)
+	want.Errors = []*ErrorRecord{
+		{Path: filepath.Join(rootPath, "a"), Op: OpList, Error: "access denied"},
+		{Path: filepath.Join(rootPath, "a"), Op: OpList, Error: "access denied"},
+	}
+
+	logs := CaptureLogs(t)
+	res, err := RunWithOptions(rootPath, Options{FollowSymlinks: true})
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+	assert.Equal(t,
+		fmt.Sprintf(
+			Lines(
+				"skipping inaccessible directory %q",
+				"skipping inaccessible directory %q",
+			),
+			filepath.Join(rootPath, "a"),
+			filepath.Join(rootPath, "a"),
+		),
+		logs.String(),
+	)
+}
+
 func Test__inaccessible_internal_empty_file_is_not_logged(t *testing.T) {
 	root := DirNode{
 		"a":                  FileNode{C: "x"},
@@ -505,10 +859,10 @@ func Test__cache_root_name_check(t *testing.T) {
 		cache := &Dir{Name: rootPath}
 		res, err := Run(rootPath, NoSkip, cache)
 		require.NoError(t, err)
-		assert.Equal(t, &Result{
+		AssertEqualResult(t, res, &Result{
 			TypeVersion: CurrentResultTypeVersion,
 			Root:        &Dir{Name: rootPath},
-		}, res)
+		})
 	})
 	t.Run("cache name matches after resolving root symlink", func(t *testing.T) {
 		rootName := "root"
@@ -525,10 +879,10 @@ func Test__cache_root_name_check(t *testing.T) {
 		cache := &Dir{Name: rootPath}
 		res, err := Run(rootSymlinkPath, NoSkip, cache)
 		require.NoError(t, err)
-		assert.Equal(t, &Result{
+		AssertEqualResult(t, res, &Result{
 			TypeVersion: CurrentResultTypeVersion,
 			Root:        &Dir{Name: rootPath}, //
-		}, res)
+		})
 	})
 	t.Run("cache name symlink is not followed", func(t *testing.T) {
 		rootName := "root"
@@ -654,92 +1008,478 @@ func Test__cache_with_mismatching_file_mod_time_is_not_used(t *testing.T) {
 	AssertEqualResult(t, res, want)
 }
 
-func Test__hash_of_inaccessible_file_is_used(t *testing.T) {
+func Test__cache_with_file_mod_time_within_tolerance_is_used(t *testing.T) {
 	ts, err := time.Parse(time.Layout, time.Layout)
 	require.NoError(t, err)
 
 	root := DirNode{
-		"a": FileNode{C: "x", Ts: ts, Inaccessible: true, HashFromCache: 42},
+		"d": FileNode{C: "x\n", Ts: ts},
 	}
-
 	rootPath := tempDir(t)
 	root.WriteTestdata(t, rootPath)
-	want := simulateScan(root, rootPath)
+	want := simulateScan(DirNode{
+		"d": FileNode{C: "x\n", Ts: ts, HashFromCache: 21},
+	}, rootPath)
 
 	cache := &Dir{
 		Name: want.Root.Name,
 		Files: []*File{
 			{
-				Name:    "a",
-				Size:    1,
-				ModTime: ts.Unix(),
-				Hash:    42,
+				Name:    "d",
+				Size:    2,             // size is correct,
+				ModTime: ts.Unix() + 2, // and mod time is within tolerance (as if rounded by FAT),
+				Hash:    21,            // so the cached hash value is used
 			},
 		},
 	}
-	res, err := Run(rootPath, NoSkip, cache)
+	res, err := RunWithOptions(rootPath, Options{Caches: []*Dir{cache}, ModTimeTolerance: 2 * time.Second})
 	require.NoError(t, err)
 	AssertEqualResult(t, res, want)
 }
 
-func Test__cache_entry_with_hash_0_is_ignored_and_logged(t *testing.T) {
+func Test__mod_time_nanos_are_recorded_and_compared_with_cache(t *testing.T) {
 	ts, err := time.Parse(time.Layout, time.Layout)
 	require.NoError(t, err)
+	ts = ts.Add(500 * time.Millisecond)
 
 	root := DirNode{
-		"d": FileNode{C: "x\n", Ts: ts},
+		"a": FileNode{C: "a\n", Ts: ts},
+		"b": FileNode{C: "b\n", Ts: ts},
+		"c": FileNode{C: "c\n", Ts: ts},
 	}
 	rootPath := tempDir(t)
 	root.WriteTestdata(t, rootPath)
-	want := simulateScan(root, rootPath)
+	want := simulateScan(DirNode{
+		"a": FileNode{C: "a\n", Ts: ts, HashFromCache: 42}, // cache has same nanos
+		"b": FileNode{C: "b\n", Ts: ts, HashFromCache: 53}, // cache has unknown nanos (like from older scans)
+		"c": FileNode{C: "c\n", Ts: ts},                    // cache has different nanos
+	}, rootPath)
+	require.Equal(t, int64(500000000), SafeFindFile(want.Root, "a").ModTimeNanos)
 
 	cache := &Dir{
 		Name: want.Root.Name,
 		Files: []*File{
-			{
-				Name:    "d",
-				Size:    2,         // size is correct,
-				ModTime: ts.Unix(), // time is correct,
-				Hash:    0,         // but value 0 is explicitly ignored
-			},
+			{Name: "a", Size: 2, ModTime: ts.Unix(), ModTimeNanos: 500000000, Hash: 42},
+			{Name: "b", Size: 2, ModTime: ts.Unix(), Hash: 53},
+			{Name: "c", Size: 2, ModTime: ts.Unix(), ModTimeNanos: 400000000, Hash: 69},
 		},
 	}
-	logs := CaptureLogs(t)
 	res, err := Run(rootPath, NoSkip, cache)
 	require.NoError(t, err)
 	AssertEqualResult(t, res, want)
-	assert.Equal(t,
-		fmt.Sprintf(
-			Lines("warning: cached hash value 0 of file %q ignored"),
-			filepath.Join(rootPath, "d"),
-		),
-		logs.String(),
-	)
 }
 
-func Test__hash_computed_as_0_is_logged(t *testing.T) {
+func Test__hash_of_inaccessible_file_is_used(t *testing.T) {
+	ts, err := time.Parse(time.Layout, time.Layout)
+	require.NoError(t, err)
+
 	root := DirNode{
-		// Contents hash to 0 (https://md5hashing.net/hash/fnv1a64/0000000000000000).
-		"hash0": FileNode{C: "77kepQFQ8Kl"},
+		"a": FileNode{C: "x", Ts: ts, Inaccessible: true, HashFromCache: 42},
 	}
+
 	rootPath := tempDir(t)
 	root.WriteTestdata(t, rootPath)
 	want := simulateScan(root, rootPath)
 
-	logs := CaptureLogs(t)
-	res, err := Run(rootPath, NoSkip, nil)
+	cache := &Dir{
+		Name: want.Root.Name,
+		Files: []*File{
+			{
+				Name:    "a",
+				Size:    1,
+				ModTime: ts.Unix(),
+				Hash:    42,
+			},
+		},
+	}
+	res, err := Run(rootPath, NoSkip, cache)
 	require.NoError(t, err)
 	AssertEqualResult(t, res, want)
-	assert.Equal(t,
-		fmt.Sprintf(
-			Lines("info: hash of file %q evaluated to 0 - this might result in warnings (which can be safely ignored) if the output is used as cache in future scans"),
-			filepath.Join(rootPath, "hash0"),
-		),
-		logs.String(),
-	)
 }
 
-// SKIPPED on Windows unless running as administrator.
+func Test__cache_of_ancestor_is_used(t *testing.T) {
+	ts, err := time.Parse(time.Layout, time.Layout)
+	require.NoError(t, err)
+
+	wrap := DirNode{
+		"x/root/a":   FileNode{C: "a\n", Ts: ts},
+		"x/root/b/c": FileNode{C: "c\n", Ts: ts},
+	}
+	wrapPath := tempDir(t)
+	wrap.WriteTestdata(t, wrapPath)
+	rootPath := filepath.Join(wrapPath, "x", "root")
+	want := simulateScan(DirNode{
+		"a":   FileNode{C: "a\n", Ts: ts, HashFromCache: 42},
+		"b/c": FileNode{C: "c\n", Ts: ts, HashFromCache: 53},
+	}, rootPath)
+
+	cache := &Dir{
+		Name: wrapPath,
+		Dirs: []*Dir{
+			{
+				Name: "x",
+				Dirs: []*Dir{
+					{
+						Name:  "root",
+						Dirs:  []*Dir{{Name: "b", Files: []*File{{Name: "c", Size: 2, ModTime: ts.Unix(), Hash: 53}}}},
+						Files: []*File{{Name: "a", Size: 2, ModTime: ts.Unix(), Hash: 42}},
+					},
+				},
+			},
+		},
+	}
+	res, err := Run(rootPath, NoSkip, cache)
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+}
+
+func Test__cache_of_ancestor_not_containing_root_is_ignored(t *testing.T) {
+	root := DirNode{
+		"a": FileNode{C: "a\n"},
+	}
+	wrapPath := tempDir(t)
+	rootPath := filepath.Join(wrapPath, "root")
+	root.WriteTestdata(t, rootPath)
+	want := simulateScan(root, rootPath)
+
+	cache := &Dir{Name: wrapPath, Dirs: []*Dir{{Name: "other"}}}
+	res, err := Run(rootPath, NoSkip, cache)
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+}
+
+func Test__cache_of_subdirectory_is_used(t *testing.T) {
+	ts, err := time.Parse(time.Layout, time.Layout)
+	require.NoError(t, err)
+
+	root := DirNode{
+		"a":   FileNode{C: "a\n", Ts: ts},
+		"b/c": FileNode{C: "c\n", Ts: ts},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	want := simulateScan(DirNode{
+		"a":   FileNode{C: "a\n", Ts: ts},
+		"b/c": FileNode{C: "c\n", Ts: ts, HashFromCache: 42},
+	}, rootPath)
+
+	cache := &Dir{
+		Name:  filepath.Join(rootPath, "b"),
+		Files: []*File{{Name: "c", Size: 2, ModTime: ts.Unix(), Hash: 42}},
+	}
+	res, err := Run(rootPath, NoSkip, cache)
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+}
+
+func Test__closest_of_multiple_caches_is_used(t *testing.T) {
+	ts, err := time.Parse(time.Layout, time.Layout)
+	require.NoError(t, err)
+
+	root := DirNode{
+		"a": FileNode{C: "a\n", Ts: ts},
+		"b": DirNode{
+			"c":   FileNode{C: "c\n", Ts: ts},
+			"d":   FileNode{C: "d\n", Ts: ts},
+			"e/f": FileNode{C: "f\n", Ts: ts},
+		},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	want := simulateScan(DirNode{
+		"a": FileNode{C: "a\n", Ts: ts, HashFromCache: 1},
+		"b": DirNode{
+			"c":   FileNode{C: "c\n", Ts: ts, HashFromCache: 2},
+			"d":   FileNode{C: "d\n", Ts: ts}, // only in the cache of the root, which isn't the closest one
+			"e/f": FileNode{C: "f\n", Ts: ts, HashFromCache: 2},
+		},
+	}, rootPath)
+
+	rootCache := &Dir{
+		Name: rootPath,
+		Dirs: []*Dir{
+			{
+				Name: "b",
+				Dirs: []*Dir{{Name: "e", Files: []*File{{Name: "f", Size: 2, ModTime: ts.Unix(), Hash: 1}}}},
+				Files: []*File{
+					{Name: "c", Size: 2, ModTime: ts.Unix(), Hash: 1},
+					{Name: "d", Size: 2, ModTime: ts.Unix(), Hash: 1},
+				},
+			},
+		},
+		Files: []*File{{Name: "a", Size: 2, ModTime: ts.Unix(), Hash: 1}},
+	}
+	subdirCache := &Dir{
+		Name:  filepath.Join(rootPath, "b"),
+		Dirs:  []*Dir{{Name: "e", Files: []*File{{Name: "f", Size: 2, ModTime: ts.Unix(), Hash: 2}}}},
+		Files: []*File{{Name: "c", Size: 2, ModTime: ts.Unix(), Hash: 2}},
+	}
+	// The order of the caches doesn't matter.
+	for _, caches := range [][]*Dir{{rootCache, subdirCache}, {subdirCache, rootCache}} {
+		res, err := RunWithOptions(rootPath, Options{Caches: caches})
+		require.NoError(t, err)
+		AssertEqualResult(t, res, want)
+	}
+}
+
+func Test__unrelated_or_duplicate_caches_are_rejected(t *testing.T) {
+	rootPath := tempDir(t)
+	t.Run("sibling with common prefix", func(t *testing.T) {
+		cache := &Dir{Name: rootPath + "x"}
+		_, err := Run(rootPath, NoSkip, cache)
+		assert.EqualError(t, err, fmt.Sprintf("cache of directory %q cannot be used with root directory %q", cache.Name, rootPath))
+	})
+	t.Run("duplicate", func(t *testing.T) {
+		caches := []*Dir{{Name: rootPath}, {Name: filepath.Join(rootPath, "a")}, {Name: rootPath}}
+		_, err := RunWithOptions(rootPath, Options{Caches: caches})
+		assert.EqualError(t, err, fmt.Sprintf("multiple caches of directory %q", rootPath))
+	})
+}
+
+func Test__cache_stats_are_recorded(t *testing.T) {
+	ts, err := time.Parse(time.Layout, time.Layout)
+	require.NoError(t, err)
+
+	root := DirNode{
+		"a":   FileNode{C: "a\n", Ts: ts},
+		"b":   FileNode{C: "bb\n", Ts: ts},
+		"c":   FileNode{C: "c\n", Ts: ts},
+		"d/e": FileNode{C: "e\n", Ts: ts},
+		"s":   FileNode{C: "s\n", Ts: ts, Skipped: true},
+		"z":   FileNode{},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	want := simulateScan(DirNode{
+		"a":   FileNode{C: "a\n", Ts: ts, HashFromCache: 1}, // hit
+		"b":   FileNode{C: "bb\n", Ts: ts},                  // stale (size changed)
+		"c":   FileNode{C: "c\n", Ts: ts},                   // miss
+		"d/e": FileNode{C: "e\n", Ts: ts, HashFromCache: 2}, // hit
+		"s":   FileNode{C: "s\n", Ts: ts, Skipped: true},    // cached but skipped (so not missing)
+		"z":   FileNode{},
+	}, rootPath)
+	want.Stats = &Stats{
+		FilesHashed:  2,
+		BytesHashed:  5,
+		BytesReused:  4,
+		CacheHits:    2,
+		CacheMisses:  1,
+		CacheStale:   1,
+		CacheMissing: 4, // "x", "d/y", and "gone/{p,q}"
+	}
+
+	cache := &Dir{
+		Name: rootPath,
+		Dirs: []*Dir{
+			{
+				Name: "d",
+				Files: []*File{
+					{Name: "e", Size: 2, ModTime: ts.Unix(), Hash: 2},
+					{Name: "y", Size: 2, ModTime: ts.Unix(), Hash: 3},
+				},
+			},
+			{
+				Name: "gone",
+				Dirs: []*Dir{{Name: "sub", Files: []*File{{Name: "q", Size: 2, ModTime: ts.Unix(), Hash: 5}}}},
+				Files: []*File{
+					{Name: "p", Size: 2, ModTime: ts.Unix(), Hash: 4},
+				},
+			},
+		},
+		Files: []*File{
+			{Name: "a", Size: 2, ModTime: ts.Unix(), Hash: 1},
+			{Name: "b", Size: 2, ModTime: ts.Unix(), Hash: 1},
+			{Name: "s", Size: 2, ModTime: ts.Unix(), Hash: 1},
+			{Name: "x", Size: 2, ModTime: ts.Unix(), Hash: 1},
+		},
+	}
+	res, err := Run(rootPath, makeSkip("s"), cache)
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+}
+
+func Test__stats_without_cache_count_all_files_as_misses(t *testing.T) {
+	root := DirNode{
+		"a":   FileNode{C: "a\n"},
+		"b/c": FileNode{C: "cc\n"},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	want := simulateScan(root, rootPath)
+	want.Stats = &Stats{FilesHashed: 2, BytesHashed: 5, CacheMisses: 2}
+
+	res, err := Run(rootPath, NoSkip, nil)
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+}
+
+// mapHashCache is a HashCache that looks up hashes by path only.
+type mapHashCache map[string]hashCacheEntry
+
+type hashCacheEntry struct {
+	Key  FileKey
+	Hash uint64
+}
+
+func (c mapHashCache) Lookup(key FileKey) (uint64, bool) {
+	e, ok := c[key.Path]
+	if !ok || e.Key.Size != key.Size || e.Key.ModTime != key.ModTime {
+		return 0, false
+	}
+	return e.Hash, true
+}
+
+func (c mapHashCache) Store(key FileKey, hash uint64) {
+	c[key.Path] = hashCacheEntry{Key: key, Hash: hash}
+}
+
+func Test__hash_cache_is_used_and_updated(t *testing.T) {
+	ts, err := time.Parse(time.Layout, time.Layout)
+	require.NoError(t, err)
+
+	root := DirNode{
+		"a":   FileNode{C: "a\n", Ts: ts},
+		"b/c": FileNode{C: "c\n", Ts: ts},
+		"d":   FileNode{C: "d\n", Ts: ts},
+		"e":   FileNode{C: "e\n", Ts: ts},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	want := simulateScan(DirNode{
+		"a":   FileNode{C: "a\n", Ts: ts, HashFromCache: 42}, // from hash cache
+		"b/c": FileNode{C: "c\n", Ts: ts, HashFromCache: 53}, // from dir cache (takes precedence)
+		"d":   FileNode{C: "d\n", Ts: ts},                    // hash cache entry has wrong mod time
+		"e":   FileNode{C: "e\n", Ts: ts},                    // not cached
+	}, rootPath)
+
+	pathA, pathC := filepath.Join(rootPath, "a"), filepath.Join(rootPath, "b", "c")
+	pathD, pathE := filepath.Join(rootPath, "d"), filepath.Join(rootPath, "e")
+	hashCache := mapHashCache{
+		pathA: {Key: FileKey{Path: pathA, Size: 2, ModTime: ts.Unix()}, Hash: 42},
+		pathC: {Key: FileKey{Path: pathC, Size: 2, ModTime: ts.Unix()}, Hash: 69},
+		pathD: {Key: FileKey{Path: pathD, Size: 2, ModTime: ts.Unix() + 1}, Hash: 69},
+	}
+	cache := &Dir{
+		Name: rootPath,
+		Dirs: []*Dir{{Name: "b", Files: []*File{{Name: "c", Size: 2, ModTime: ts.Unix(), Hash: 53}}}},
+	}
+	want.Stats = &Stats{
+		FilesHashed:   2, // "d" and "e"
+		BytesHashed:   4,
+		BytesReused:   4, // "a" and "c"
+		CacheHits:     1,
+		CacheMisses:   3,
+		HashCacheHits: 1,
+	}
+	res, err := RunWithOptions(rootPath, Options{Caches: []*Dir{cache}, HashCache: hashCache})
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+
+	// All hashes are stored in the hash cache.
+	require.Len(t, hashCache, 4)
+	for _, f := range []struct {
+		path string
+		file *File
+	}{
+		{path: pathA, file: SafeFindFile(want.Root, "a")},
+		{path: pathC, file: SafeFindFile(SafeFindDir(want.Root, "b"), "c")},
+		{path: pathD, file: SafeFindFile(want.Root, "d")},
+		{path: pathE, file: SafeFindFile(want.Root, "e")},
+	} {
+		e := hashCache[f.path]
+		assert.Equal(t, f.file.Hash, e.Hash)
+		assert.Equal(t, f.file.Size, e.Key.Size)
+		assert.Equal(t, ts.Unix(), e.Key.ModTime)
+	}
+}
+
+func Test__cache_entry_with_hash_0_is_ignored_and_logged(t *testing.T) {
+	ts, err := time.Parse(time.Layout, time.Layout)
+	require.NoError(t, err)
+
+	root := DirNode{
+		"d": FileNode{C: "x\n", Ts: ts},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	want := simulateScan(root, rootPath)
+
+	cache := &Dir{
+		Name: want.Root.Name,
+		Files: []*File{
+			{
+				Name:    "d",
+				Size:    2,         // size is correct,
+				ModTime: ts.Unix(), // time is correct,
+				Hash:    0,         // but value 0 is explicitly ignored
+			},
+		},
+	}
+	logs := CaptureLogs(t)
+	res, err := Run(rootPath, NoSkip, cache)
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+	assert.Equal(t,
+		fmt.Sprintf(
+			Lines("warning: cached hash value 0 of file %q ignored"),
+			filepath.Join(rootPath, "d"),
+		),
+		logs.String(),
+	)
+}
+
+func Test__cache_entry_marked_as_unhashed_is_ignored(t *testing.T) {
+	ts, err := time.Parse(time.Layout, time.Layout)
+	require.NoError(t, err)
+
+	root := DirNode{
+		"d": FileNode{C: "x\n", Ts: ts},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	want := simulateScan(root, rootPath)
+
+	cache := &Dir{
+		Name: want.Root.Name,
+		Files: []*File{
+			{
+				Name:     "d",
+				Size:     2,         // size is correct,
+				ModTime:  ts.Unix(), // time is correct,
+				Unhashed: true,      // but the file couldn't be hashed, so the cache entry is not used
+			},
+		},
+	}
+	logs := CaptureLogs(t)
+	res, err := Run(rootPath, NoSkip, cache)
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+	assert.Empty(t, logs.String())
+}
+
+func Test__hash_computed_as_0_is_logged(t *testing.T) {
+	root := DirNode{
+		// Contents hash to 0 (https://md5hashing.net/hash/fnv1a64/0000000000000000).
+		"hash0": FileNode{C: "77kepQFQ8Kl"},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	want := simulateScan(root, rootPath)
+
+	logs := CaptureLogs(t)
+	res, err := Run(rootPath, NoSkip, nil)
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+	assert.Equal(t,
+		fmt.Sprintf(
+			Lines("info: hash of file %q evaluated to 0 - this might result in warnings (which can be safely ignored) if the output is used as cache in future scans"),
+			filepath.Join(rootPath, "hash0"),
+		),
+		logs.String(),
+	)
+}
+
+// SKIPPED on Windows unless running as administrator.
 func Test__root_symlink_is_followed_and_logged(t *testing.T) {
 	//goland:noinspection GoBoolExpressions
 	if runtime.GOOS == "windows" && !IsWindowsAdministrator() {
@@ -807,7 +1547,7 @@ func Test__root_indirect_symlink_is_followed_and_logged(t *testing.T) {
 }
 
 // SKIPPED on Windows unless running as administrator.
-func Test__internal_symlink_is_skipped_and_logged(t *testing.T) {
+func Test__internal_symlink_is_recorded(t *testing.T) {
 	//goland:noinspection GoBoolExpressions
 	if runtime.GOOS == "windows" && !IsWindowsAdministrator() {
 		t.Skip("Creating symlinks on Windows requires elevated privileges.")
@@ -820,16 +1560,36 @@ func Test__internal_symlink_is_skipped_and_logged(t *testing.T) {
 		root DirNode
 	}{
 		{
-			name: "existing target",
+			name: "existing file target",
 			root: DirNode{
 				"a":         FileNode{C: "z\n"},
 				symlinkName: SymlinkNode("a"),
 			},
 		},
+		{
+			name: "existing dir target",
+			root: DirNode{
+				"a/b":       FileNode{C: "z\n"},
+				symlinkName: SymlinkNode("a"),
+			},
+		},
+		{
+			name: "external target",
+			root: DirNode{
+				symlinkName: SymlinkNode(".."),
+			},
+		},
 		{
 			name: "non-existing target",
 			root: DirNode{
-				symlinkName: SymlinkNode("x"),
+				symlinkName: SymlinkExtNode{Symlink: "x", Broken: true},
+			},
+		},
+		{
+			name: "indirectly non-existing target",
+			root: DirNode{
+				"a":         SymlinkExtNode{Symlink: "x", Broken: true},
+				symlinkName: SymlinkExtNode{Symlink: "a", Broken: true},
 			},
 		},
 	}
@@ -842,19 +1602,253 @@ func Test__internal_symlink_is_skipped_and_logged(t *testing.T) {
 			res, err := Run(rootPath, NoSkip, nil)
 			require.NoError(t, err)
 			AssertEqualResult(t, res, want)
-			assert.Equal(t,
-				fmt.Sprintf(
-					Lines("skipping symlink %q during scan"),
-					filepath.Join(rootPath, symlinkName),
-				),
-				logs.String(),
-			)
+			assert.Empty(t, logs.String())
 		})
 	}
 }
 
 // SKIPPED on Windows unless running as administrator.
-func Test__root_symlink_to_ancestor_is_followed_but_skipped_and_logged_when_internal(t *testing.T) {
+func Test__symlink_not_included_is_filtered(t *testing.T) {
+	//goland:noinspection GoBoolExpressions
+	if runtime.GOOS == "windows" && !IsWindowsAdministrator() {
+		t.Skip("Creating symlinks on Windows requires elevated privileges.")
+	}
+	root := DirNode{
+		"a.jpg": FileNode{C: "z\n"},
+		"b.jpg": SymlinkNode("a.jpg"),
+		"c.txt": DirNode{},
+		"d":     SymlinkNode("a.jpg"),
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	want := simulateScan(DirNode{
+		"a.jpg": FileNode{C: "z\n"},
+		"b.jpg": SymlinkNode("a.jpg"),
+		"c.txt": DirNode{},
+		"d":     FileNode{Filtered: true},
+	}, rootPath)
+
+	res, err := RunWithOptions(rootPath, Options{ShouldInclude: IncludeNamePatterns([]string{"*.jpg"})})
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+}
+
+// SKIPPED on Windows unless running as administrator.
+func Test__followed_symlinks_are_recorded_and_scanned(t *testing.T) {
+	//goland:noinspection GoBoolExpressions
+	if runtime.GOOS == "windows" && !IsWindowsAdministrator() {
+		t.Skip("Creating symlinks on Windows requires elevated privileges.")
+	}
+	ts, err := time.Parse(time.Layout, time.Layout)
+	require.NoError(t, err)
+
+	rootName := "root"
+	externalName := "external"
+	wrap := DirNode{
+		rootName: DirNode{
+			"a":        FileNode{C: "x\n", Ts: ts},
+			"b/c":      FileNode{C: "y\n", Ts: ts},
+			"b/d":      FileNode{},
+			"e":        SymlinkNode("a"),
+			"f":        SymlinkNode("b"),
+			"g":        SymlinkNode("e"),
+			"h":        SymlinkNode("x"),
+			"i":        SymlinkNode("../" + externalName),
+			"j":        SymlinkNode("b/d"),
+			"k/l":      SymlinkNode("../b"),
+			"skipped":  SymlinkNode("a"),
+			"filtered": SymlinkNode("a"),
+		},
+		externalName: DirNode{
+			"m": FileNode{C: "z\n", Ts: ts},
+		},
+	}
+	wrapPath := tempDir(t)
+	wrap.WriteTestdata(t, wrapPath)
+	rootPath := filepath.Join(wrapPath, rootName)
+
+	b := DirNode{
+		"c": FileNode{C: "y\n", Ts: ts},
+		"d": FileNode{},
+	}
+	root := DirNode{
+		"a":        FileNode{C: "x\n", Ts: ts},
+		"b":        b,
+		"e":        SymlinkExtNode{Symlink: "a", Followed: FileNode{C: "x\n", Ts: ts}},
+		"f":        SymlinkExtNode{Symlink: "b", Followed: b},
+		"g":        SymlinkExtNode{Symlink: "e", Followed: FileNode{C: "x\n", Ts: ts}},
+		"h":        SymlinkExtNode{Symlink: "x", Broken: true},
+		"i":        SymlinkExtNode{Symlink: SymlinkNode("../" + externalName), Followed: wrap[externalName]},
+		"j":        SymlinkExtNode{Symlink: "b/d", Followed: FileNode{}},
+		"k/l":      SymlinkExtNode{Symlink: "../b", Followed: b},
+		"skipped":  SymlinkExtNode{Symlink: "a", Skipped: true},
+		"filtered": FileNode{Filtered: true},
+	}
+	want := simulateScan(root, rootPath)
+
+	logs := CaptureLogs(t)
+	res, err := RunWithOptions(rootPath, Options{
+		ShouldSkip: makeSkip("skipped"),
+		ShouldInclude: func(dir, name string, info os.FileInfo) bool {
+			return name != "filtered"
+		},
+		FollowSymlinks: true,
+	})
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+	assert.Equal(t,
+		fmt.Sprintf(
+			Lines("skipping symlink %q based on skip list"),
+			filepath.Join(rootPath, "skipped"),
+		),
+		logs.String(),
+	)
+}
+
+// SKIPPED on Windows unless running as administrator.
+func Test__file_filter_applies_to_targets_of_followed_symlinks(t *testing.T) {
+	//goland:noinspection GoBoolExpressions
+	if runtime.GOOS == "windows" && !IsWindowsAdministrator() {
+		t.Skip("Creating symlinks on Windows requires elevated privileges.")
+	}
+	root := DirNode{
+		"a": FileNode{C: "x", Filtered: true},
+		"b": FileNode{C: "xy"},
+		"c": SymlinkExtNode{Symlink: "a", Followed: FileNode{C: "x", Filtered: true}},
+		"d": SymlinkExtNode{Symlink: "b", Followed: FileNode{C: "xy"}},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	logs := CaptureLogs(t)
+
+	res, err := RunWithOptions(rootPath, Options{FileFilter: FilterFileSize(2, -1), FollowSymlinks: true})
+	require.NoError(t, err)
+	AssertEqualResult(t, res, simulateScan(root, rootPath))
+	assert.Contains(t, logs.String(), fmt.Sprintf("filtering out file %q: size 1 is smaller than min size 2\n", filepath.Join(rootPath, "c")))
+	assert.NotContains(t, logs.String(), filepath.Join(rootPath, "d"))
+}
+
+// SKIPPED on Windows unless running as administrator.
+func Test__followed_symlink_uses_cache_of_link_path(t *testing.T) {
+	//goland:noinspection GoBoolExpressions
+	if runtime.GOOS == "windows" && !IsWindowsAdministrator() {
+		t.Skip("Creating symlinks on Windows requires elevated privileges.")
+	}
+	ts, err := time.Parse(time.Layout, time.Layout)
+	require.NoError(t, err)
+
+	root := DirNode{
+		"a/b": FileNode{C: "x\n", Ts: ts},
+		"c":   SymlinkNode("a"),
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	want := simulateScan(DirNode{
+		"a/b": FileNode{C: "x\n", Ts: ts},
+		"c":   SymlinkExtNode{Symlink: "a", Followed: DirNode{"b": FileNode{C: "x\n", Ts: ts, HashFromCache: 42}}},
+	}, rootPath)
+
+	cache := &Dir{
+		Name: rootPath,
+		Dirs: []*Dir{{Name: "c", Files: []*File{{Name: "b", Size: 2, ModTime: ts.Unix(), Hash: 42}}}},
+	}
+	res, err := RunWithOptions(rootPath, Options{Caches: []*Dir{cache}, FollowSymlinks: true})
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+}
+
+// SKIPPED on Windows unless running as administrator.
+func Test__followed_symlink_uses_cache_of_subdirectory_at_link_path(t *testing.T) {
+	//goland:noinspection GoBoolExpressions
+	if runtime.GOOS == "windows" && !IsWindowsAdministrator() {
+		t.Skip("Creating symlinks on Windows requires elevated privileges.")
+	}
+	ts, err := time.Parse(time.Layout, time.Layout)
+	require.NoError(t, err)
+
+	root := DirNode{
+		"a/b": FileNode{C: "x\n", Ts: ts},
+		"c":   SymlinkNode("a"),
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	want := simulateScan(DirNode{
+		"a/b": FileNode{C: "x\n", Ts: ts},
+		"c":   SymlinkExtNode{Symlink: "a", Followed: DirNode{"b": FileNode{C: "x\n", Ts: ts, HashFromCache: 42}}},
+	}, rootPath)
+
+	cache := &Dir{
+		Name:  filepath.Join(rootPath, "c"),
+		Files: []*File{{Name: "b", Size: 2, ModTime: ts.Unix(), Hash: 42}},
+	}
+	res, err := RunWithOptions(rootPath, Options{Caches: []*Dir{cache}, FollowSymlinks: true})
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+}
+
+func Test__followed_symlink_cycles_are_not_followed_and_logged(t *testing.T) {
+	//goland:noinspection GoBoolExpressions
+	if runtime.GOOS == "windows" && !IsWindowsAdministrator() {
+		t.Skip("Creating symlinks on Windows requires elevated privileges.")
+	}
+
+	rootName := "root"
+	externalName := "external"
+	wrap := DirNode{
+		rootName: DirNode{
+			"a/self":   SymlinkNode("."),
+			"a/parent": SymlinkNode(".."),
+			"b":        SymlinkNode("../" + externalName),
+		},
+		externalName: DirNode{
+			"c": SymlinkNode("../" + rootName + "/a"),
+			"d": FileNode{C: "x\n"},
+		},
+	}
+	wrapPath := tempDir(t)
+	wrap.WriteTestdata(t, wrapPath)
+	rootPath := filepath.Join(wrapPath, rootName)
+
+	// The symlinks in "a" are cycles both when reached directly and via "b" and "c":
+	// While following "c" into "a" from "b" isn't a cycle in itself,
+	// following "a/parent" from there would lead back to the root which contains "b".
+	a := DirNode{
+		"self":   SymlinkNode("."),
+		"parent": SymlinkNode(".."),
+	}
+	external := DirNode{
+		"c": SymlinkExtNode{Symlink: SymlinkNode("../" + rootName + "/a"), Followed: a},
+		"d": FileNode{C: "x\n"},
+	}
+	root := DirNode{
+		"a": a,
+		"b": SymlinkExtNode{Symlink: SymlinkNode("../" + externalName), Followed: external},
+	}
+	want := simulateScan(root, rootPath)
+
+	logs := CaptureLogs(t)
+	res, err := RunWithOptions(rootPath, Options{FollowSymlinks: true})
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+	assert.Equal(t,
+		fmt.Sprintf(
+			Lines(
+				"not following symlink %q to ancestor directory %q",
+				"not following symlink %q to ancestor directory %q",
+				"not following symlink %q to ancestor directory %q",
+				"not following symlink %q to ancestor directory %q",
+			),
+			filepath.Join(rootPath, "a", "parent"), rootPath,
+			filepath.Join(rootPath, "a", "self"), filepath.Join(rootPath, "a"),
+			filepath.Join(rootPath, "a", "parent"), rootPath,
+			filepath.Join(rootPath, "a", "self"), filepath.Join(rootPath, "a"),
+		),
+		logs.String(),
+	)
+}
+
+// SKIPPED on Windows unless running as administrator.
+func Test__root_symlink_to_ancestor_is_followed_but_recorded_when_internal(t *testing.T) {
 	//goland:noinspection GoBoolExpressions
 	if runtime.GOOS == "windows" && !IsWindowsAdministrator() {
 		t.Skip("Creating symlinks on Windows requires elevated privileges.")
@@ -888,13 +1882,9 @@ func Test__root_symlink_to_ancestor_is_followed_but_skipped_and_logged_when_inte
 	AssertEqualResult(t, res, want)
 	assert.Equal(t,
 		fmt.Sprintf(
-			Lines(
-				"following root symlink %q to %q",
-				"skipping symlink %q during scan",
-			),
+			Lines("following root symlink %q to %q"),
 			rootSymlinkPath,
 			rootPath,
-			rootSymlinkPath,
 		),
 		logs.String(),
 	)
@@ -911,6 +1901,24 @@ func Test__root_symlink_to_ancestor_is_followed_but_skipped_and_logged_when_inte
 // When passing such a path to Run, it will emit a log entry that the link has been followed
 // and thus break tests that make assertions about log output.
 // evaluating the links up front prevents this problem without breaking anything else.
+func Test__result_contains_metadata(t *testing.T) {
+	rootPath := tempDir(t)
+	host, err := os.Hostname()
+	require.NoError(t, err)
+
+	before := time.Now()
+	res, err := Run(rootPath, NoSkip, nil)
+	after := time.Now()
+	require.NoError(t, err)
+	m := res.Metadata
+	require.NotNil(t, m)
+	assert.Empty(t, m.ToolVersion) // not known by Run
+	assert.Equal(t, host, m.Hostname)
+	assert.False(t, m.StartTime.Before(before))
+	assert.False(t, m.EndTime.Before(m.StartTime))
+	assert.False(t, after.Before(m.EndTime))
+}
+
 func tempDir(t *testing.T) string {
 	dir, err := filepath.EvalSymlinks(t.TempDir())
 	require.NoError(t, err)
@@ -918,7 +1926,7 @@ func tempDir(t *testing.T) string {
 }
 
 func makeSkip(names ...string) ShouldSkipPath {
-	return func(dir, name string) bool {
+	return func(dir, name string, info os.FileInfo) bool {
 		for _, n := range names {
 			if n == name {
 				return true
@@ -0,0 +1,172 @@
+//go:build !windows
+// +build !windows
+
+package scan_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/bisgardo/dupe-nukem/scan"
+	. "github.com/bisgardo/dupe-nukem/scan/scantest"
+	. "github.com/bisgardo/dupe-nukem/testutil"
+	. "github.com/bisgardo/dupe-nukem/testutil/testdata"
+)
+
+func Test__special_files_are_recorded(t *testing.T) {
+	root := DirNode{
+		"a": FileNode{C: "x\n"},
+		"c": FileNode{},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	err := syscall.Mkfifo(filepath.Join(rootPath, "b"), 0600)
+	require.NoError(t, err)
+	want := simulateScan(root, rootPath)
+	want.Root.SpecialFiles = []*SpecialFile{{Name: "b", Type: "named pipe file"}}
+
+	logs := CaptureLogs(t)
+	res, err := Run(rootPath, NoSkip, nil)
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+	assert.Empty(t, logs.String())
+}
+
+func Test__unsearchable_dir_contents_are_recorded_as_inaccessible(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("Directories cannot be made unsearchable to root.")
+	}
+	root := DirNode{
+		"a/b": FileNode{C: "x\n"},
+		"a/c": DirNode{},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	// Make directory readable but not searchable such that its contents can be listed but not "stat'ed".
+	dirPath := filepath.Join(rootPath, "a")
+	err := os.Chmod(dirPath, 0400)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		err := os.Chmod(dirPath, 0700)
+		assert.NoError(t, err)
+	})
+	want := &Result{
+		TypeVersion: CurrentResultTypeVersion,
+		Root: &Dir{
+			Name: rootPath,
+			Dirs: []*Dir{
+				{
+					Name: "a",
+					InaccessibleFiles: []*Inaccessible{
+						{Name: "b", Error: "access denied"},
+						{Name: "c", Error: "access denied"},
+					},
+				},
+			},
+		},
+		Errors: []*ErrorRecord{
+			{Path: filepath.Join(dirPath, "b"), Op: OpStat, Error: "access denied"},
+			{Path: filepath.Join(dirPath, "c"), Op: OpStat, Error: "access denied"},
+		},
+	}
+
+	logs := CaptureLogs(t)
+	res, err := Run(rootPath, NoSkip, nil)
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+	assert.Equal(t,
+		fmt.Sprintf(
+			Lines(
+				"skipping inaccessible file or directory %q",
+				"skipping inaccessible file or directory %q",
+			),
+			filepath.Join(dirPath, "b"),
+			filepath.Join(dirPath, "c"),
+		),
+		logs.String(),
+	)
+}
+
+func Test__hardlinks_are_recorded_with_identity(t *testing.T) {
+	root := DirNode{
+		"a":   FileNode{C: "x\n"},
+		"b":   FileNode{C: "x\n"},
+		"c/d": FileNode{C: "y\n"},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	err := os.Link(filepath.Join(rootPath, "a"), filepath.Join(rootPath, "c", "e"))
+	require.NoError(t, err)
+
+	logs := CaptureLogs(t)
+	res, err := Run(rootPath, NoSkip, nil)
+	require.NoError(t, err)
+	assert.Empty(t, logs.String())
+
+	a, b := SafeFindFile(res.Root, "a"), SafeFindFile(res.Root, "b")
+	c := SafeFindDir(res.Root, "c")
+	d, e := SafeFindFile(c, "d"), SafeFindFile(c, "e")
+	require.NotNil(t, a)
+	require.NotNil(t, b)
+	require.NotNil(t, d)
+	require.NotNil(t, e)
+
+	assert.NotZero(t, a.Inode)
+	assert.Equal(t, uint64(2), a.Links)
+	assert.Equal(t, uint64(1), b.Links)
+	assert.Equal(t, uint64(1), d.Links)
+	assert.Equal(t, &File{Name: "e", Size: a.Size, ModTime: a.ModTime, ModTimeNanos: a.ModTimeNanos, Hash: a.Hash, Device: a.Device, Inode: a.Inode, Links: 2}, e)
+
+	assert.True(t, a.IsHardlinkOf(e))
+	assert.True(t, e.IsHardlinkOf(a))
+	assert.False(t, a.IsHardlinkOf(b)) // identical contents but not linked
+	assert.False(t, a.IsHardlinkOf(d))
+}
+
+func Test__hardlinked_file_is_hashed_once(t *testing.T) {
+	root := DirNode{
+		"a": FileNode{C: "x\n"},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	err := os.Link(filepath.Join(rootPath, "a"), filepath.Join(rootPath, "b"))
+	require.NoError(t, err)
+	info, err := os.Stat(filepath.Join(rootPath, "a"))
+	require.NoError(t, err)
+
+	// Cache (wrong) hash of the first link only.
+	// As the second link is the same file, its hash is reused rather than computed.
+	cache := &Dir{
+		Name:  rootPath,
+		Files: []*File{{Name: "a", Size: 2, ModTime: info.ModTime().Unix(), Hash: 42}},
+	}
+	res, err := Run(rootPath, NoSkip, cache)
+	require.NoError(t, err)
+	require.Len(t, res.Root.Files, 2)
+	assert.Equal(t, uint64(42), res.Root.Files[0].Hash)
+	assert.Equal(t, uint64(42), res.Root.Files[1].Hash)
+	assert.Equal(t, &Stats{BytesReused: 4, CacheHits: 1, CacheMisses: 1, HardlinkHits: 1}, res.Stats)
+}
+
+func Test__one_file_system_scans_tree_without_mount_points_normally(t *testing.T) {
+	root := DirNode{
+		"a":   FileNode{C: "x\n"},
+		"b/c": FileNode{C: "y\n"},
+		"d":   DirNode{},
+	}
+	rootPath := tempDir(t)
+	root.WriteTestdata(t, rootPath)
+	want := simulateScan(root, rootPath)
+
+	logs := CaptureLogs(t)
+	res, err := RunWithOptions(rootPath, Options{OneFileSystem: true})
+	require.NoError(t, err)
+	AssertEqualResult(t, res, want)
+	assert.Empty(t, logs.String())
+}
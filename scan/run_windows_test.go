@@ -0,0 +1,16 @@
+package scan_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/bisgardo/dupe-nukem/scan"
+)
+
+func Test__one_file_system_is_not_supported(t *testing.T) {
+	rootPath := tempDir(t)
+	_, err := RunWithOptions(rootPath, Options{OneFileSystem: true})
+	assert.EqualError(t, err, fmt.Sprintf("cannot determine file system of root directory %q: not supported on this platform", rootPath))
+}
@@ -10,6 +10,7 @@ import (
 
 // AssertEqualDir asserts that the provided scan.Dir matches the provided expectation.
 // The assertion works like assert.Equal except for a special rule explained in AssertEqualFile.
+// Likewise, the directory hashes are only compared if they're non-zero in the expectation.
 func AssertEqualDir(t *testing.T, d *scan.Dir, want *scan.Dir) {
 	if d == nil {
 		assert.Nil(t, want)
@@ -19,6 +20,18 @@ func AssertEqualDir(t *testing.T, d *scan.Dir, want *scan.Dir) {
 	assert.Equal(t, want.EmptyFiles, d.EmptyFiles)
 	assert.Equal(t, want.SkippedFiles, d.SkippedFiles)
 	assert.Equal(t, want.SkippedDirs, d.SkippedDirs)
+	assert.Equal(t, want.SkippedMounts, d.SkippedMounts)
+	assert.Equal(t, want.FilteredFiles, d.FilteredFiles)
+	assert.Equal(t, want.Symlinks, d.Symlinks)
+	assert.Equal(t, want.SpecialFiles, d.SpecialFiles)
+	assert.Equal(t, want.InaccessibleFiles, d.InaccessibleFiles)
+	assert.Equal(t, want.InaccessibleDirs, d.InaccessibleDirs)
+	if want.Hash != 0 {
+		assert.Equal(t, want.Hash, d.Hash)
+	}
+	if want.ContentHash != 0 {
+		assert.Equal(t, want.ContentHash, d.ContentHash)
+	}
 
 	dirCount := len(want.Dirs)
 	fileCount := len(want.Files)
@@ -39,6 +52,8 @@ func AssertEqualDir(t *testing.T, d *scan.Dir, want *scan.Dir) {
 // in which case they default to the time that the test is run.
 // The solution of patching the expectation with the current time didn't work well and was replaced with this one.
 // Now if only you could somehow specify how assert.Empty should test equality for a given type...
+// Likewise, the sub-second part of the modification time is only compared if it's non-zero in the expectation.
+// Similarly, the identity of the file (device, inode, and number of links) is only compared if the expected inode is non-zero.
 func AssertEqualFile(t *testing.T, f *scan.File, want *scan.File) {
 	if f == nil {
 		assert.Nil(t, want)
@@ -49,11 +64,23 @@ func AssertEqualFile(t *testing.T, f *scan.File, want *scan.File) {
 	if want.ModTime != 0 {
 		assert.Equal(t, want.ModTime, f.ModTime)
 	}
+	if want.ModTimeNanos != 0 {
+		assert.Equal(t, want.ModTimeNanos, f.ModTimeNanos)
+	}
 	assert.Equal(t, want.Hash, f.Hash)
+	assert.Equal(t, want.Unhashed, f.Unhashed)
+	assert.Equal(t, want.Unstable, f.Unstable)
+	if want.Inode != 0 {
+		assert.Equal(t, want.Device, f.Device)
+		assert.Equal(t, want.Inode, f.Inode)
+		assert.Equal(t, want.Links, f.Links)
+	}
 }
 
 // AssertEqualResult asserts that the provided scan.Result matches the provided expectation.
-// The assertion works like assert.Equal except for a special rule explained in AssertEqualFile.
+// The assertion works like assert.Equal except for a special rule explained in AssertEqualFile
+// and that the metadata isn't compared (as it depends on the environment and time of the scan).
+// The stats are only compared if the expected ones are non-nil.
 func AssertEqualResult(t *testing.T, r *scan.Result, want *scan.Result) {
 	if r == nil {
 		assert.Nil(t, want)
@@ -61,4 +88,8 @@ func AssertEqualResult(t *testing.T, r *scan.Result, want *scan.Result) {
 	}
 	assert.Equal(t, want.TypeVersion, r.TypeVersion)
 	AssertEqualDir(t, r.Root, want.Root)
+	assert.Equal(t, want.Errors, r.Errors)
+	if want.Stats != nil {
+		assert.Equal(t, want.Stats, r.Stats)
+	}
 }
@@ -0,0 +1,96 @@
+package scan
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/bisgardo/dupe-nukem/util"
+)
+
+// Verification is the result of verifying the hashes of a scan against a previous scan of the same data (see Verify).
+type Verification struct {
+	// Against is the root name of the previous scan.
+	Against string `json:"against"`
+	// Unchanged is the number of files whose size, modification time, and hash all match the previous scan.
+	Unchanged int `json:"unchanged"`
+	// Modified is the number of files whose size or modification time changed since the previous scan
+	// (such that any change of the hash is expected).
+	Modified int `json:"modified"`
+	// Corrupted lists the files whose hash changed since the previous scan
+	// even though their size and modification time didn't.
+	// Unless the file was deliberately modified in a way that preserved its modification time,
+	// this indicates silent corruption ("bit rot") of the data.
+	Corrupted []*Corruption `json:"corrupted,omitempty"`
+}
+
+// Corruption is a file whose hash changed since a previous scan even though its size and modification time didn't.
+type Corruption struct {
+	// Path of the file in the current scan.
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Modification time of the file in the current scan (see File).
+	ModTime      int64 `json:"ts"`
+	ModTimeNanos int64 `json:"ts_nanos,omitempty"`
+	// Hash of the file in the previous scan.
+	PrevHash uint64 `json:"prev_hash"`
+	// Hash of the file in the current scan.
+	Hash uint64 `json:"hash"`
+}
+
+// Verify compares the files of the provided scan root with those of the provided previous scan
+// and reports the files whose hash changed even though their size and modification time
+// (within the provided tolerance, see ModTimesMatch) didn't.
+// For the result to be meaningful, the hashes of the current scan must have been computed without using any caches.
+// The root of the previous scan must be the same directory as the current one, an ancestor, or a subdirectory,
+// in which case only the directory that the scans have in common is compared.
+// Files that aren't present in both scans or that are unhashed or unstable in either of them are ignored.
+func Verify(prev, cur *Dir, modTimeTolerance time.Duration) (*Verification, error) {
+	against := prev.Name
+	var path string
+	switch {
+	case util.IsSubpath(prev.Name, cur.Name):
+		path = cur.Name
+		prev = findSubdir(prev, path)
+	case util.IsSubpath(cur.Name, prev.Name):
+		path = prev.Name
+		cur = findSubdir(cur, path)
+	default:
+		return nil, fmt.Errorf("previous scan of directory %q cannot be used with root directory %q", prev.Name, cur.Name)
+	}
+	v := &Verification{Against: against}
+	v.verifyDir(path, prev, cur, modTimeTolerance)
+	return v, nil
+}
+
+// verifyDir verifies the files of the provided Dir (located at the provided path) and its subdirectories
+// against the ones of the provided previous Dir (which may be nil).
+func (v *Verification) verifyDir(path string, prev, cur *Dir, modTimeTolerance time.Duration) {
+	if prev == nil || cur == nil {
+		return
+	}
+	for _, f := range cur.Files {
+		p := SafeFindFile(prev, f.Name)
+		if p == nil || f.Unhashed || f.Unstable || p.Unhashed || p.Unstable {
+			continue
+		}
+		switch {
+		case p.Size != f.Size || !p.ModTimeMatches(f, modTimeTolerance):
+			v.Modified++
+		case p.Hash == f.Hash:
+			v.Unchanged++
+		default:
+			v.Corrupted = append(v.Corrupted, &Corruption{
+				Path:         filepath.Join(path, f.Name),
+				Size:         f.Size,
+				ModTime:      f.ModTime,
+				ModTimeNanos: f.ModTimeNanos,
+				PrevHash:     p.Hash,
+				Hash:         f.Hash,
+			})
+		}
+	}
+	for _, d := range cur.Dirs {
+		v.verifyDir(filepath.Join(path, d.Name), SafeFindDir(prev, d.Name), d, modTimeTolerance)
+	}
+}
@@ -0,0 +1,125 @@
+package scan
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test__Verify_reports_files_whose_hash_changed_without_metadata_changing(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	prev := &Dir{
+		Name: p("/x"),
+		Dirs: []*Dir{
+			{
+				Name: "d",
+				Files: []*File{
+					{Name: "a", Size: 1, ModTime: 10, Hash: 1},
+					{Name: "b", Size: 2, ModTime: 10, Hash: 2},
+				},
+			},
+			{
+				Name:  "e",
+				Files: []*File{{Name: "a", Size: 1, ModTime: 10, Hash: 1}},
+			},
+		},
+		Files: []*File{
+			{Name: "a", Size: 1, ModTime: 10, Hash: 1},
+			{Name: "b", Size: 2, ModTime: 10, Hash: 2},
+			{Name: "c", Size: 3, ModTime: 10, Hash: 3},
+			{Name: "e", Size: 5, ModTime: 10, Unhashed: true},
+			{Name: "f", Size: 6, ModTime: 10, Hash: 6, Unstable: true},
+			{Name: "g", Size: 7, ModTime: 10, Hash: 7},
+		},
+	}
+	cur := &Dir{
+		Name: p("/x"),
+		Dirs: []*Dir{
+			{
+				Name: "d",
+				Files: []*File{
+					{Name: "a", Size: 1, ModTime: 10, Hash: 1},
+					{Name: "b", Size: 2, ModTime: 10, ModTimeNanos: 5, Hash: 20}, // corrupted
+				},
+			},
+			{
+				Name:  "f", // not in previous scan
+				Files: []*File{{Name: "a", Size: 1, ModTime: 10, Hash: 10}},
+			},
+		},
+		Files: []*File{
+			{Name: "a", Size: 1, ModTime: 10, Hash: 1},   // unchanged
+			{Name: "b", Size: 2, ModTime: 11, Hash: 20},  // modified
+			{Name: "c", Size: 4, ModTime: 10, Hash: 30},  // modified
+			{Name: "d", Size: 4, ModTime: 10, Hash: 4},   // not in previous scan
+			{Name: "e", Size: 5, ModTime: 10, Hash: 5},   // unhashed in previous scan
+			{Name: "f", Size: 6, ModTime: 10, Hash: 60},  // unstable in previous scan
+			{Name: "g", Size: 7, ModTime: 10, Hash: 700}, // corrupted
+		},
+	}
+	res, err := Verify(prev, cur, 0)
+	require.NoError(t, err)
+	assert.Equal(t, &Verification{
+		Against:   p("/x"),
+		Unchanged: 2,
+		Modified:  2,
+		Corrupted: []*Corruption{
+			{Path: p("/x/g"), Size: 7, ModTime: 10, PrevHash: 7, Hash: 700},
+			{Path: p("/x/d/b"), Size: 2, ModTime: 10, ModTimeNanos: 5, PrevHash: 2, Hash: 20},
+		},
+	}, res)
+}
+
+func Test__Verify_uses_mod_time_tolerance(t *testing.T) {
+	prev := &Dir{Name: "x", Files: []*File{{Name: "a", Size: 1, ModTime: 10, Hash: 1}}}
+	cur := &Dir{Name: "x", Files: []*File{{Name: "a", Size: 1, ModTime: 12, Hash: 2}}}
+
+	res, err := Verify(prev, cur, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Modified)
+	assert.Empty(t, res.Corrupted)
+
+	res, err = Verify(prev, cur, 2*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Modified)
+	assert.Len(t, res.Corrupted, 1)
+}
+
+func Test__Verify_compares_common_directory_of_nested_roots(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	outer := &Dir{
+		Name: p("/x"),
+		Dirs: []*Dir{
+			{Name: "y", Files: []*File{{Name: "a", Size: 1, ModTime: 10, Hash: 1}}},
+		},
+		Files: []*File{{Name: "a", Size: 1, ModTime: 10, Hash: 1}},
+	}
+	inner := &Dir{Name: p("/x/y"), Files: []*File{{Name: "a", Size: 1, ModTime: 10, Hash: 2}}}
+	want := &Verification{
+		Corrupted: []*Corruption{{Path: p("/x/y/a"), Size: 1, ModTime: 10, PrevHash: 1, Hash: 2}},
+	}
+
+	t.Run("previous scan of ancestor", func(t *testing.T) {
+		res, err := Verify(outer, inner, 0)
+		require.NoError(t, err)
+		want.Against = p("/x")
+		assert.Equal(t, want, res)
+	})
+	t.Run("previous scan of subdirectory", func(t *testing.T) {
+		res, err := Verify(inner, outer, 0)
+		require.NoError(t, err)
+		want.Against = p("/x/y")
+		want.Corrupted[0].PrevHash, want.Corrupted[0].Hash = 2, 1
+		assert.Equal(t, want, res)
+	})
+}
+
+func Test__Verify_unrelated_root_fails(t *testing.T) {
+	p := filepath.FromSlash // because Windows...
+	_, err := Verify(&Dir{Name: p("/x")}, &Dir{Name: p("/y")}, 0)
+	assert.EqualError(t, err, fmt.Sprintf("previous scan of directory %q cannot be used with root directory %q", p("/x"), p("/y")))
+}
@@ -0,0 +1,19 @@
+package scan
+
+// Volume identifies the file system (i.e. disk partition or similar) containing a scanned directory.
+// Fields that couldn't be determined are left empty.
+type Volume struct {
+	// UUID of the file system.
+	UUID string `json:"uuid,omitempty"`
+	// Label of the file system.
+	Label string `json:"label,omitempty"`
+	// Source is the device (or similar) that the file system was mounted from.
+	Source string `json:"source,omitempty"`
+	// Type is the type of the file system (like "ext4").
+	Type string `json:"type,omitempty"`
+	// MountPoint is the path at which the file system was mounted when scanning.
+	MountPoint string `json:"mount_point,omitempty"`
+	// Path of the scanned directory relative to the root of the file system.
+	// Unlike the root path of the scan, this path doesn't depend on where the file system is mounted.
+	Path string `json:"path,omitempty"`
+}
@@ -0,0 +1,189 @@
+package scan
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/bisgardo/dupe-nukem/util"
+)
+
+const (
+	mountInfoPath   = "/proc/self/mountinfo"
+	diskByUUIDPath  = "/dev/disk/by-uuid"
+	diskByLabelPath = "/dev/disk/by-label"
+)
+
+// detectVolume determines the identity of the file system containing the provided (absolute, symlink free) path.
+// The mount is looked up in the mount info of the process and the UUID and label are looked up
+// among the symlinks that udev maintains in "/dev/disk".
+// Any information that isn't available (for instance when running in a container) is left empty.
+// If nothing could be determined, nil is returned.
+func detectVolume(path string) *Volume {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil // cannot test
+	}
+	id, ok := FileIDOf(info)
+	if !ok {
+		return nil // cannot test
+	}
+	v := &Volume{}
+	if f, err := os.Open(mountInfoPath); err == nil {
+		mounts, err := parseMountInfo(f)
+		_ = f.Close()
+		if err == nil {
+			if m := findMount(mounts, id.Device, path); m != nil {
+				*v = m.volume(path)
+			}
+		}
+	}
+	v.UUID = findDiskName(diskByUUIDPath, id.Device)
+	v.Label = unescapeDiskLabel(findDiskName(diskByLabelPath, id.Device))
+	if *v == (Volume{}) {
+		return nil // cannot test
+	}
+	return v
+}
+
+// mountInfo is the relevant part of an entry of the mount info of a process.
+// See 'man 5 proc' for the format.
+type mountInfo struct {
+	// Device number of the file system as "major:minor".
+	device string
+	// Root of the mount within the file system.
+	root       string
+	mountPoint string
+	fsType     string
+	source     string
+}
+
+// volume constructs a Volume of the mount for the provided path that is assumed to be located on it.
+func (m *mountInfo) volume(path string) Volume {
+	rel, err := filepath.Rel(m.mountPoint, path)
+	if err != nil {
+		rel = "" // cannot test
+	}
+	return Volume{
+		Source:     m.source,
+		Type:       m.fsType,
+		MountPoint: m.mountPoint,
+		Path:       filepath.Join(m.root, rel),
+	}
+}
+
+// parseMountInfo parses the mount info of a process.
+// Malformed lines are ignored.
+func parseMountInfo(r io.Reader) ([]*mountInfo, error) {
+	var res []*mountInfo
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fs := strings.Fields(s.Text())
+		// The fields up to and including the mount options are followed by
+		// an arbitrary number of optional fields terminated by a separator.
+		sep := -1
+		for i := 6; i < len(fs); i++ {
+			if fs[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep == -1 || len(fs) < sep+3 {
+			continue
+		}
+		res = append(res, &mountInfo{
+			device:     fs[2],
+			root:       unescapeMountInfo(fs[3]),
+			mountPoint: unescapeMountInfo(fs[4]),
+			fsType:     fs[sep+1],
+			source:     unescapeMountInfo(fs[sep+2]),
+		})
+	}
+	return res, s.Err()
+}
+
+// findMount finds the mount of the file system with the provided device number which contains the provided path.
+// If multiple mounts of the file system contain the path, the one with the longest mount point is selected.
+// Returns nil if no such mount exists.
+func findMount(mounts []*mountInfo, device uint64, path string) *mountInfo {
+	dev := fmt.Sprintf("%d:%d", deviceMajor(device), deviceMinor(device))
+	var res *mountInfo
+	for _, m := range mounts {
+		if m.device == dev && util.IsSubpath(m.mountPoint, path) && (res == nil || len(m.mountPoint) > len(res.mountPoint)) {
+			res = m
+		}
+	}
+	return res
+}
+
+// findDiskName finds the name of the entry of the provided directory (like "/dev/disk/by-uuid")
+// which resolves to the block device with the provided device number.
+// Returns the empty string if there's no such entry or the directory cannot be read.
+func findDiskName(dir string, device uint64) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		info, err := os.Stat(filepath.Join(dir, e.Name()))
+		if err != nil || info.Mode()&os.ModeDevice == 0 {
+			continue
+		}
+		s, ok := info.Sys().(*syscall.Stat_t)
+		//goland:noinspection GoRedundantConversion
+		if ok && uint64(s.Rdev) == device {
+			return e.Name()
+		}
+	}
+	return ""
+}
+
+// deviceMajor extracts the major number of a Linux device number (see 'gnu_dev_major' in glibc).
+func deviceMajor(dev uint64) uint64 {
+	return (dev&0x00000000000fff00)>>8 | (dev&0xfffff00000000000)>>32
+}
+
+// deviceMinor extracts the minor number of a Linux device number (see 'gnu_dev_minor' in glibc).
+func deviceMinor(dev uint64) uint64 {
+	return dev&0x00000000000000ff | (dev&0x00000ffffff00000)>>12
+}
+
+// unescapeMountInfo reverts the octal escaping of whitespace and backslashes (like "\040") in mount info fields.
+func unescapeMountInfo(s string) string {
+	return unescape(s, `\`, 3, 8)
+}
+
+// unescapeDiskLabel reverts the hex escaping of special characters (like "\x20") in the names of "/dev/disk/by-label".
+func unescapeDiskLabel(s string) string {
+	return unescape(s, `\x`, 2, 16)
+}
+
+// unescape replaces all occurrences of the provided prefix followed by a number
+// of the provided length and base with the byte of that value.
+// Occurrences that aren't followed by a valid number are left as is.
+func unescape(s string, prefix string, length int, base int) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, prefix)
+		if i == -1 {
+			b.WriteString(s)
+			return b.String()
+		}
+		end := i + len(prefix) + length
+		if end <= len(s) {
+			if c, err := strconv.ParseUint(s[i+len(prefix):end], base, 8); err == nil {
+				b.WriteString(s[:i])
+				b.WriteByte(byte(c))
+				s = s[end:]
+				continue
+			}
+		}
+		b.WriteString(s[:i+len(prefix)])
+		s = s[i+len(prefix):]
+	}
+}
@@ -0,0 +1,119 @@
+package scan
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMountInfo = `22 1 252:1 / / rw,relatime shared:1 - ext4 /dev/vda1 rw
+23 22 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+24 22 8:17 / /mnt/usb\040disk rw,relatime shared:30 - vfat /dev/sdb1 rw,fmask=0022
+25 22 8:17 /photos /home/x/photos rw,relatime shared:30 master:2 - vfat /dev/sdb1 rw,fmask=0022
+malformed line
+`
+
+func Test__parseMountInfo(t *testing.T) {
+	res, err := parseMountInfo(strings.NewReader(testMountInfo))
+	require.NoError(t, err)
+	want := []*mountInfo{
+		{device: "252:1", root: "/", mountPoint: "/", fsType: "ext4", source: "/dev/vda1"},
+		{device: "0:21", root: "/", mountPoint: "/proc", fsType: "proc", source: "proc"},
+		{device: "8:17", root: "/", mountPoint: "/mnt/usb disk", fsType: "vfat", source: "/dev/sdb1"},
+		{device: "8:17", root: "/photos", mountPoint: "/home/x/photos", fsType: "vfat", source: "/dev/sdb1"},
+	}
+	assert.Equal(t, want, res)
+}
+
+func Test__findMount(t *testing.T) {
+	mounts, err := parseMountInfo(strings.NewReader(testMountInfo))
+	require.NoError(t, err)
+	const sdb1 = 8<<8 | 17
+	tests := []struct {
+		name   string
+		device uint64
+		path   string
+		want   *Volume
+	}{
+		{
+			name:   "root",
+			device: 252<<8 | 1,
+			path:   "/home/x/docs",
+			want:   &Volume{Source: "/dev/vda1", Type: "ext4", MountPoint: "/", Path: "/home/x/docs"},
+		},
+		{
+			name:   "mount point",
+			device: sdb1,
+			path:   "/mnt/usb disk",
+			want:   &Volume{Source: "/dev/sdb1", Type: "vfat", MountPoint: "/mnt/usb disk", Path: "/"},
+		},
+		{
+			name:   "nested in mount point",
+			device: sdb1,
+			path:   "/mnt/usb disk/photos/2020",
+			want:   &Volume{Source: "/dev/sdb1", Type: "vfat", MountPoint: "/mnt/usb disk", Path: "/photos/2020"},
+		},
+		{
+			name:   "nested in bind mount",
+			device: sdb1,
+			path:   "/home/x/photos/2020",
+			want:   &Volume{Source: "/dev/sdb1", Type: "vfat", MountPoint: "/home/x/photos", Path: "/photos/2020"},
+		},
+		{
+			name:   "unknown device",
+			device: 1<<8 | 1,
+			path:   "/home/x",
+		},
+		{
+			name:   "device not mounted at path",
+			device: sdb1,
+			path:   "/home/y",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := findMount(mounts, test.device, test.path)
+			if test.want == nil {
+				assert.Nil(t, m)
+				return
+			}
+			require.NotNil(t, m)
+			v := m.volume(test.path)
+			assert.Equal(t, test.want, &v)
+		})
+	}
+}
+
+func Test__deviceMajor_and_deviceMinor(t *testing.T) {
+	tests := []struct {
+		dev          uint64
+		major, minor uint64
+	}{
+		{dev: 0, major: 0, minor: 0},
+		{dev: 0x0811, major: 8, minor: 17},
+		{dev: 0xfc01, major: 252, minor: 1},
+		// Large numbers are split into low and high bits:
+		// The lowest 8 bits of the minor number, then the lowest 12 bits of the major number,
+		// then the remaining bits of the minor number, and finally the remaining bits of the major number.
+		{dev: 0x12<<44 | 0x678<<20 | 0x345<<8 | 0x9a, major: 0x12345, minor: 0x6789a},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.major, deviceMajor(test.dev))
+		assert.Equal(t, test.minor, deviceMinor(test.dev))
+	}
+}
+
+func Test__unescape(t *testing.T) {
+	assert.Equal(t, "a b\\c", unescapeMountInfo(`a\040b\134c`))
+	assert.Equal(t, `a\04`, unescapeMountInfo(`a\04`))   // too short
+	assert.Equal(t, `a\09b`, unescapeMountInfo(`a\09b`)) // invalid octal
+	assert.Equal(t, "My Disk/", unescapeDiskLabel(`My\x20Disk\x2f`))
+	assert.Equal(t, `x\xZZ`, unescapeDiskLabel(`x\xZZ`))
+	assert.Equal(t, "", unescapeDiskLabel(""))
+}
+
+func Test__findDiskName_of_missing_dir_is_empty(t *testing.T) {
+	assert.Equal(t, "", findDiskName(t.TempDir()+"/missing", 1))
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package scan
+
+// detectVolume determines the identity of the file system containing the provided path.
+// This is only supported on Linux, so on other platforms nil is always returned.
+func detectVolume(string) *Volume {
+	return nil
+}
@@ -104,6 +104,7 @@ type DirNodeExt struct {
 // SimulateScanFromParent implements Node.SimulateScanFromParent.
 func (d DirNodeExt) SimulateScanFromParent(parent *scan.Dir, name string) {
 	if d.Inaccessible {
+		parent.AppendInaccessibleDir(scan.NewInaccessible(name, "access denied"))
 		return
 	}
 	if d.Skipped {
@@ -133,6 +134,8 @@ type FileNode struct {
 	HashFromCache uint64
 	// Whether SimulateScan should expect the file to be skipped by Run.
 	Skipped bool
+	// Whether SimulateScan should expect the file to not be included by Run.
+	Filtered bool
 	// Whether WriteTestdata is to make the file inaccessible (and thus expecting Run to find it so).
 	Inaccessible bool
 }
@@ -143,11 +146,15 @@ func (f FileNode) SimulateScanFromParent(parent *scan.Dir, name string) {
 		parent.AppendSkippedFile(name)
 		return
 	}
+	if f.Filtered {
+		parent.AppendFilteredFile(name)
+		return
+	}
 	if len(f.C) == 0 {
 		parent.AppendEmptyFile(name)
 		return
 	}
-	// Inaccessibility is handled in SimulateScan (by hashing to 0).
+	// Inaccessibility is handled in SimulateScan (by marking the file as unhashed).
 	// We don't have to check whether the file is already there,
 	// as that cannot be expressed without duplicating dir (which is already checked).
 	s := f.SimulateScan(name)
@@ -164,11 +171,19 @@ func (f FileNode) SimulateScan(name string) *scan.File {
 		// As the hash is cached, we make no attempts of opening the file, and thus won't notice it being inaccessible.
 		h = hash.Bytes(data)
 	}
-	var unixTime int64
+	var unixTime, nanos int64
 	if !f.Ts.IsZero() {
 		unixTime = f.Ts.Unix()
+		nanos = int64(f.Ts.Nanosecond())
 	}
-	return scan.NewFile(name, int64(len(data)), unixTime, h)
+	var res *scan.File
+	if h == 0 && f.Inaccessible {
+		res = scan.NewUnhashedFile(name, int64(len(data)), unixTime)
+	} else {
+		res = scan.NewFile(name, int64(len(data)), unixTime, h)
+	}
+	res.ModTimeNanos = nanos
+	return res
 }
 
 // WriteTestdata implements Node.WriteTestdata.
@@ -195,8 +210,8 @@ func (f FileNode) WriteTestdata(t *testing.T, path string) {
 type SymlinkNode string
 
 // SimulateScanFromParent implements Node.SimulateScanFromParent.
-func (s SymlinkNode) SimulateScanFromParent(*scan.Dir, string) {
-	// Symlinks are ignored.
+func (s SymlinkNode) SimulateScanFromParent(parent *scan.Dir, name string) {
+	parent.AppendSymlink(scan.NewSymlink(name, string(s), false))
 }
 
 // WriteTestdata implements Node.WriteTestdata.
@@ -206,18 +221,27 @@ func (s SymlinkNode) WriteTestdata(t *testing.T, path string) {
 }
 
 // SymlinkExtNode is an extension of SymlinkNode that adds the ability
-// to expect the directory to be skipped.
+// to expect the symlink to be skipped, broken, or followed.
 type SymlinkExtNode struct {
 	Symlink SymlinkNode
 	Skipped bool
+	// Whether SimulateScan should expect the symlink's target to not exist.
+	Broken bool
+	// If non-nil, SimulateScan will expect the symlink to be followed and its target scanned as this Node.
+	// The Node is only used for simulating the scan; WriteTestdata doesn't write it.
+	Followed Node
 }
 
 // SimulateScanFromParent implements Node.SimulateScanFromParent.
-func (s SymlinkExtNode) SimulateScanFromParent(dir *scan.Dir, name string) {
+func (s SymlinkExtNode) SimulateScanFromParent(parent *scan.Dir, name string) {
 	if s.Skipped {
-		dir.AppendSkippedFile(name)
+		parent.AppendSkippedFile(name)
+		return
+	}
+	parent.AppendSymlink(scan.NewSymlink(name, string(s.Symlink), s.Broken))
+	if s.Followed != nil {
+		s.Followed.SimulateScanFromParent(parent, name)
 	}
-	s.Symlink.SimulateScanFromParent(dir, name)
 }
 
 // WriteTestdata implements Node.WriteTestdata.
@@ -33,6 +33,7 @@ func Test__node(t *testing.T) {
 				"h": FileNode{C: "h\n", Ts: ts, Inaccessible: true},
 			},
 			"h": FileNode{C: "q", Skipped: true},
+			"i": FileNode{C: "i", Filtered: true},
 			"x": DirNode{},
 			"y": DirNodeExt{Inaccessible: true, Dir: DirNode{"z": FileNode{C: "zzz"}}},
 		}
@@ -64,6 +65,7 @@ func Test__node(t *testing.T) {
 			p("e/f/g"): {Name: "g", Contents: "", Mode: 0},              // cannot read contents of inaccessible file
 			p("e/f/h"): {Name: "h", Contents: "", Mode: 0, ModTime: ts}, // cannot read contents of inaccessible file
 			p("h"):     {Name: "h", Contents: "q", Mode: 0600},
+			p("i"):     {Name: "i", Contents: "i", Mode: 0600},
 			p("x"):     {Name: "x", Mode: os.ModeDir | 0700},
 			p("y"):     {Name: "y", Mode: os.ModeDir}, // not seeing contained file "z" (it is there, but we'd have to be root to see it)
 		}
@@ -93,8 +95,8 @@ func Test__node(t *testing.T) {
 						{
 							Name: "f",
 							Files: []*scan.File{
-								{Name: "a", Size: 2, ModTime: ts.Unix(), Hash: 42}, // cached
-								{Name: "h", Size: 2, ModTime: ts.Unix(), Hash: 0},  // cannot hash inaccessible file
+								{Name: "a", Size: 2, ModTime: ts.Unix(), Hash: 42},       // cached
+								{Name: "h", Size: 2, ModTime: ts.Unix(), Unhashed: true}, // cannot hash inaccessible file
 							},
 							EmptyFiles: []string{"g"},
 						},
@@ -107,9 +109,13 @@ func Test__node(t *testing.T) {
 			Files: []*scan.File{
 				{Name: "c", Size: 2, Hash: 53}, // cached + no mod time
 			},
-			EmptyFiles:   []string{"a"},
-			SkippedFiles: []string{"h"},
-			SkippedDirs:  []string{"d"},
+			EmptyFiles:    []string{"a"},
+			SkippedFiles:  []string{"h"},
+			SkippedDirs:   []string{"d"},
+			FilteredFiles: []string{"i"},
+			InaccessibleDirs: []*scan.Inaccessible{
+				{Name: "y", Error: "access denied"},
+			},
 		}
 		scantest.AssertEqualDir(t, s, want)
 	})
@@ -0,0 +1,14 @@
+package util
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// IsSubpath returns whether the provided (clean) path is equal to or nested inside the provided (clean) parent path.
+func IsSubpath(parent, path string) bool {
+	if !strings.HasPrefix(path, parent) {
+		return false
+	}
+	return len(path) == len(parent) || strings.HasSuffix(parent, string(filepath.Separator)) || path[len(parent)] == filepath.Separator
+}